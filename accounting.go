@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// countMarker stands in for every value while -strict-count counts the
+// substitutions an engine performs.
+const countMarker = "\x00charmap-count\x00"
+
+// renderStats accounts for the placeholders of one render, see -strict-count.
+type renderStats struct {
+	// Placeholders counts the open...close pairs left once stages, protected
+	// text and conditional blocks are resolved.
+	Placeholders int
+	// Replacements counts the substitutions the engine and filters performed.
+	Replacements int
+}
+
+func (s *renderStats) add(o renderStats) {
+	s.Placeholders += o.Placeholders
+	s.Replacements += o.Replacements
+}
+
+// check fails unless every placeholder was replaced exactly once.
+func (s *renderStats) check() error {
+	if s.Placeholders != s.Replacements {
+		return fmt.Errorf("strict count: %d placeholder(s) but %d replacement(s)", s.Placeholders, s.Replacements)
+	}
+	return nil
+}
+
+// countPlaceholders counts the placeholders in txt. Of nested opens, as in
+// "<::<::KEY::>", only the innermost counts.
+func countPlaceholders(txt, open, close string) int {
+	n := 0
+	for {
+		idx := strings.Index(txt, open)
+		if idx == -1 {
+			return n
+		}
+		txt = txt[idx+len(open):]
+		end := strings.Index(txt, close)
+		if end == -1 {
+			return n
+		}
+		n++
+		txt = txt[end+len(close):]
+	}
+}
+
+// countValues maps every key of values to countMarker.
+func countValues(values map[string]string) map[string]string {
+	marks := make(map[string]string, len(values))
+	for k := range values {
+		marks[k] = countMarker
+	}
+	return marks
+}
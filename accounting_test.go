@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrictCount(t *testing.T) {
+	values := map[string]string{"A": "1", "B": "two", "NESTED": "<::B | upper::>"}
+	r := buildCountingReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{StrictCount: true})
+
+	var st renderStats
+	out, _, err := r([]byte("a: <::A::>\nb: <::B | upper::>\nc: <::<::A::>\n"), &st)
+	if err != nil {
+		t.Fatalf("replacer: %v", err)
+	}
+	if want := "a: 1\nb: TWO\nc: <::1\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	if st != (renderStats{Placeholders: 3, Replacements: 3}) {
+		t.Errorf("stats = %+v, want 3 placeholders and 3 replacements", st)
+	}
+
+	// A value holding a placeholder is substituted again, one more time than
+	// the template asked for.
+	st = renderStats{}
+	_, _, err = r([]byte("n: <::NESTED::>\n"), &st)
+	if err == nil || !strings.Contains(err.Error(), "1 placeholder(s) but 2 replacement(s)") {
+		t.Errorf("got %v, want a strict count error", err)
+	}
+
+	lax := buildNewReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{})
+	if _, _, err := lax([]byte("n: <::NESTED::>\n")); err != nil {
+		t.Errorf("without -strict-count: %v", err)
+	}
+}
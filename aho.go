@@ -0,0 +1,139 @@
+package main
+
+import "strings"
+
+// ahoMatcher is an Aho-Corasick automaton over the placeholders of a set of
+// keys. It finds every placeholder in a single pass regardless of how many
+// keys there are.
+type ahoMatcher struct {
+	root  [256]int32 // transitions out of the root, dense for speed
+	nodes []ahoNode
+}
+
+type ahoNode struct {
+	edges []ahoEdge
+	fail  int32
+	// out is the index of the pattern ending here or at the end of a suffix,
+	// -1 if none.
+	out int32
+}
+
+type ahoEdge struct {
+	b  byte
+	to int32
+}
+
+func (n *ahoNode) next(b byte) int32 {
+	for _, e := range n.edges {
+		if e.b == b {
+			return e.to
+		}
+	}
+	return -1
+}
+
+func newAhoMatcher(patterns []string) *ahoMatcher {
+	m := &ahoMatcher{nodes: []ahoNode{{out: -1}}}
+	for i, p := range patterns {
+		cur := int32(0)
+		for j := 0; j < len(p); j++ {
+			nxt := m.nodes[cur].next(p[j])
+			if nxt == -1 {
+				nxt = int32(len(m.nodes))
+				m.nodes = append(m.nodes, ahoNode{out: -1})
+				m.nodes[cur].edges = append(m.nodes[cur].edges, ahoEdge{p[j], nxt})
+			}
+			cur = nxt
+		}
+		if m.nodes[cur].out == -1 {
+			m.nodes[cur].out = int32(i)
+		}
+	}
+
+	// Breadth-first: a node's failure link is the longest proper suffix of its
+	// path that is also a path from the root.
+	queue := make([]int32, 0, len(m.nodes))
+	for _, e := range m.nodes[0].edges {
+		queue = append(queue, e.to)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range m.nodes[cur].edges {
+			f := m.nodes[cur].fail
+			for f != 0 && m.nodes[f].next(e.b) == -1 {
+				f = m.nodes[f].fail
+			}
+			if nxt := m.nodes[f].next(e.b); nxt != -1 && nxt != e.to {
+				f = nxt
+			}
+			m.nodes[e.to].fail = f
+			if m.nodes[e.to].out == -1 {
+				m.nodes[e.to].out = m.nodes[f].out
+			}
+			queue = append(queue, e.to)
+		}
+	}
+
+	for b := range m.root {
+		m.root[b] = max(m.nodes[0].next(byte(b)), 0)
+	}
+	return m
+}
+
+func (m *ahoMatcher) step(state int32, b byte) int32 {
+	for state != 0 {
+		if nxt := m.nodes[state].next(b); nxt != -1 {
+			return nxt
+		}
+		state = m.nodes[state].fail
+	}
+	return m.root[b]
+}
+
+// replace substitutes the leftmost non-overlapping occurrences of patterns
+// with the value at the same index.
+func (m *ahoMatcher) replace(txt string, patterns, repl []string) string {
+	var sb strings.Builder
+	sb.Grow(len(txt) + len(txt)/8)
+	last, state := 0, int32(0)
+	for i := 0; i < len(txt); i++ {
+		state = m.step(state, txt[i])
+		if out := m.nodes[state].out; out != -1 {
+			start := i + 1 - len(patterns[out])
+			sb.WriteString(txt[last:start])
+			sb.WriteString(repl[out])
+			last, state = i+1, 0
+		}
+	}
+	sb.WriteString(txt[last:])
+	return sb.String()
+}
+
+// ahoCompiled is a cached matcher with its patterns and replacements.
+type ahoCompiled struct {
+	m        *ahoMatcher
+	patterns []string
+	repl     []string
+}
+
+// ahoEngine matches the placeholders of the keys a text uses with an
+// Aho-Corasick automaton cached across files.
+func ahoEngine(txt, open, close string, values map[string]string) string {
+	keys := usedKeys(txt, open, close, values)
+	if len(keys) == 0 {
+		return txt
+	}
+	sum, sorted := keySetHash(open, close, keys, values)
+	ac, ok := ahoCache.load(sum)
+	if !ok {
+		ac = &ahoCompiled{}
+		for _, k := range sorted {
+			ac.patterns = append(ac.patterns, open+k+close)
+			ac.repl = append(ac.repl, values[k])
+		}
+		ac.m = newAhoMatcher(ac.patterns)
+		ac = ahoCache.store(sum, ac)
+	}
+	return ac.m.replace(txt, ac.patterns, ac.repl)
+}
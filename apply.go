@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// applyOutputMu keeps the output of concurrently running apply commands from
+// interleaving.
+var applyOutputMu sync.Mutex
+
+// runApplyCmd streams rendered output into the stdin of the -apply-cmd shell
+// command. The rendered content never touches the disk. CHARMAP_FILE and
+// CHARMAP_PROFILE tell the command what it is receiving.
+func runApplyCmd(command, path, profile string, rendered []byte) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "CHARMAP_FILE="+path, "CHARMAP_PROFILE="+profile)
+	cmd.Stdin = bytes.NewReader(rendered)
+
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	err := cmd.Run()
+
+	applyOutputMu.Lock()
+	os.Stdout.Write(output.Bytes())
+	applyOutputMu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("apply command failed for %q: %w: %s", path, err, strings.TrimSpace(lastLine(output.String())))
+	}
+	return nil
+}
+
+func lastLine(s string) string {
+	s = strings.TrimRight(s, "\n")
+	if idx := strings.LastIndexByte(s, '\n'); idx != -1 {
+		return s[idx+1:]
+	}
+	return s
+}
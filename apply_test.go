@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestProcessFiles_ApplyCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell command")
+	}
+
+	src, capture := t.TempDir(), filepath.Join(t.TempDir(), "applied")
+	tmpl := filepath.Join(src, "secret.yaml")
+	const input = "password: <::PASSWORD::>\n"
+	if err := os.WriteFile(tmpl, []byte(input), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	t.Setenv("CAPTURE", capture)
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		Workers:    1,
+		KeyMap:     map[string]string{"PASSWORD": "hunter2"},
+		FileFilter: ff,
+		ApplyCmd:   `test -n "$CHARMAP_FILE" && cat > "$CAPTURE"`,
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+
+	if got, _ := os.ReadFile(tmpl); string(got) != input {
+		t.Errorf("template rewritten with -apply-cmd: %q", got)
+	}
+	if got, _ := os.ReadFile(capture); string(got) != "password: hunter2\n" {
+		t.Errorf("apply command received %q", got)
+	}
+
+	cfg.ApplyCmd = "exit 3"
+	if err := processFiles(cfg); err == nil {
+		t.Errorf("expected error from failing apply command")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchMinDuration is how long each engine and worker count combination is
+// run for before its throughput is taken.
+var benchMinDuration = 300 * time.Millisecond
+
+// benchResult is the fastest combination found by the bench command, read by
+// -engine auto.
+type benchResult struct {
+	Engine  string    `json:"engine"`
+	Workers int       `json:"workers"`
+	Dir     string    `json:"dir"`
+	Time    time.Time `json:"time"`
+}
+
+// benchCmd renders the tree under -dir in memory with every engine and a range
+// of worker counts, prints the throughput of each combination and records the
+// fastest one. Nothing is written to the tree.
+func benchCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("bench: unexpected arguments %v", args)
+	}
+
+	var files [][]byte
+	var total int
+	err := walkFiles(cfg, func(path string) error {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, in)
+		total += len(in)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("bench: no files matched under %q", cfg.TargetDir)
+	}
+	fmt.Printf("%d file(s), %d bytes, %d key(s)\n\n", len(files), total, len(cfg.KeyMap))
+
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENGINE\tWORKERS\tMB/s\tFAILED")
+	var best benchResult
+	var bestRate float64
+	for _, name := range names {
+		opts := cfg.replacerOptions()
+		opts.Engine = name
+		r := buildNewReplacer([]byte(cfg.OpenDelim), []byte(cfg.CloseDelim), cfg.KeyMap, opts)
+		for _, w := range benchWorkerCounts() {
+			rate, failed := benchRun(r, files, total, w)
+			fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\n", name, w, rate, failed)
+			if rate > bestRate {
+				best, bestRate = benchResult{Engine: name, Workers: w}, rate
+			}
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nfastest: -engine %s -workers %d\n", best.Engine, best.Workers)
+	best.Dir, _ = filepath.Abs(cfg.TargetDir)
+	best.Time = time.Now().UTC()
+	path, err := saveBenchResult(best)
+	if err != nil {
+		return fmt.Errorf("bench: failed to save result: %w", err)
+	}
+	fmt.Printf("saved to %s\n", path)
+	return nil
+}
+
+// benchWorkerCounts returns the powers of two below GOMAXPROCS and
+// GOMAXPROCS itself.
+func benchWorkerCounts() []int {
+	procs := runtime.GOMAXPROCS(0)
+	var counts []int
+	for n := 1; n < procs; n *= 2 {
+		counts = append(counts, n)
+	}
+	return append(counts, procs)
+}
+
+// benchRun renders files with workers goroutines until benchMinDuration has
+// passed and returns the throughput in MB/s and how many files failed to
+// render in a round.
+func benchRun(r replacer, files [][]byte, total, workers int) (float64, int) {
+	var failed int
+	var rounds int
+	start := time.Now()
+	for rounds == 0 || time.Since(start) < benchMinDuration {
+		work := make(chan []byte, len(files))
+		for _, f := range files {
+			work <- f
+		}
+		close(work)
+
+		var mu sync.Mutex
+		failed = 0
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for f := range work {
+					if _, _, err := r(f); err != nil {
+						mu.Lock()
+						failed++
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		rounds++
+	}
+	return float64(total*rounds) / time.Since(start).Seconds() / 1e6, failed
+}
+
+// startCPUProfile starts writing a CPU profile to path and returns the func
+// that stops it.
+func startCPUProfile(path string) (func(), error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+	}, nil
+}
+
+func benchResultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "charmap", "bench.json"), nil
+}
+
+func saveBenchResult(res benchResult) (string, error) {
+	path, err := benchResultPath()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// loadBenchResult returns the result saved by the last bench run.
+func loadBenchResult() (benchResult, error) {
+	var res benchResult
+	path, err := benchResultPath()
+	if err != nil {
+		return res, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return res, err
+	}
+	return res, json.Unmarshal(data, &res)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBenchCmd(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	t.Setenv("LocalAppData", cache)
+	defer func(d time.Duration) { benchMinDuration = d }(benchMinDuration)
+	benchMinDuration = time.Millisecond
+
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		KeyMap:     map[string]string{"V": "1"},
+		FileFilter: ff,
+	}
+	if err := benchCmd(cfg, nil); err != nil {
+		t.Fatalf("benchCmd: %v", err)
+	}
+
+	path, err := benchResultPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read bench result: %v", err)
+	}
+	var res benchResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if engines[res.Engine] == nil || res.Workers < 1 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if got, _ := os.ReadFile(filepath.Join(src, "app.yaml")); string(got) != "v: <::V::>\n" {
+		t.Errorf("bench modified the tree: %q", got)
+	}
+
+	if name, workers, err := resolveEngine("auto", 99); err != nil || name != res.Engine || workers != res.Workers {
+		t.Errorf("resolveEngine(auto) = %q, %d, %v; want %q, %d", name, workers, err, res.Engine, res.Workers)
+	}
+	if _, _, err := resolveEngine("nope", 1); err == nil {
+		t.Errorf("expected error for unknown engine")
+	}
+}
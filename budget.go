@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// runBudget bounds how much a run may render, see -max-files and
+// -max-total-bytes. Zero fields are unlimited.
+type runBudget struct {
+	MaxFiles int
+	MaxBytes int64
+}
+
+// errOverBudget stops the budget walk at the first file over the budget.
+var errOverBudget = errors.New("over budget")
+
+// checkBudget walks the tree once, before any worker starts, and fails if the
+// matching files exceed b, so a pattern matching half a monorepo aborts
+// before a single file is written. Sizes are those of the templates as read.
+func checkBudget(cfg config, b runBudget) error {
+	if b.MaxFiles == 0 && b.MaxBytes == 0 {
+		return nil
+	}
+	var files int
+	var bytes int64
+	err := walkFiles(cfg, func(path string) error {
+		files++
+		if b.MaxFiles > 0 && files > b.MaxFiles {
+			return errOverBudget
+		}
+		fi, err := cfg.input().stat(path)
+		if err != nil {
+			return err
+		}
+		if bytes += fi.Size(); b.MaxBytes > 0 && bytes > b.MaxBytes {
+			return errOverBudget
+		}
+		return nil
+	})
+	switch {
+	case !errors.Is(err, errOverBudget):
+		return err
+	case b.MaxFiles > 0 && files > b.MaxFiles:
+		return fmt.Errorf("more than %d files match under %q (-max-files), nothing was written", b.MaxFiles, cfg.TargetDir)
+	default:
+		return fmt.Errorf("matching files under %q exceed %d bytes (-max-total-bytes), nothing was written", cfg.TargetDir, b.MaxBytes)
+	}
+}
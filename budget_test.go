@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessTree_Budget(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	for _, name := range []string{"a.yaml", "b.yaml", "c.yaml"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte("v: <::V::>\n"), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		Workers:    1,
+		KeyMap:     map[string]string{"V": "1"},
+		FileFilter: ff,
+	}
+
+	for _, b := range []runBudget{{MaxFiles: 2}, {MaxBytes: 20}} {
+		cfg.Budget = b
+		_, err := processTree(cfg)
+		if err == nil || !strings.Contains(err.Error(), "nothing was written") {
+			t.Errorf("%+v: got %v, want a budget error", b, err)
+		}
+		if entries, _ := os.ReadDir(out); len(entries) != 0 {
+			t.Errorf("%+v: %d file(s) written over budget", b, len(entries))
+		}
+	}
+
+	cfg.Budget = runBudget{MaxFiles: 3, MaxBytes: 33}
+	if _, err := processTree(cfg); err != nil {
+		t.Errorf("within budget: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer caps the buffers kept for reuse so that one huge file does
+// not pin its memory for the rest of the run.
+const maxPooledBuffer = 64 << 20
+
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// readFilePooled reads path from src into a pooled buffer grown to the file's
+// size. The returned release func must be called once the contents are no
+// longer used.
+func readFilePooled(src inputSource, path string, size int64) ([]byte, func(), error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	release := func() {
+		if buf.Cap() <= maxPooledBuffer {
+			bufferPool.Put(buf)
+		}
+	}
+
+	f, err := src.open(path)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	// One extra byte lets ReadFrom see EOF without growing the buffer.
+	buf.Grow(int(size) + 1)
+	if _, err := buf.ReadFrom(f); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return buf.Bytes(), release, nil
+}
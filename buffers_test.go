@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// allocBudget is the number of bytes processFile may allocate per byte of
+// input for a file rendered in place. Reading, rendering and converting the
+// result each cost about one copy; anything beyond that is a regression.
+const allocBudget = 4
+
+func writeAllocFixture(tb testing.TB, size int) (string, renderTarget) {
+	blob, values := makeTestBlob(size, 100, 42)
+	path := filepath.Join(tb.TempDir(), "blob.yaml")
+	if err := os.WriteFile(path, blob, 0o644); err != nil {
+		tb.Fatalf("write temp file: %v", err)
+	}
+	return path, renderTarget{replacer: buildCountingReplacer(benchOpenDelim, benchCloseDelim, values, replacerOptions{})}
+}
+
+func BenchmarkProcessFileAllocs(b *testing.B) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, size := range []int{4 << 10, 64 << 10, 1 << 20} {
+		b.Run(humanSize(size), func(b *testing.B) {
+			path, target := writeAllocFixture(b, size)
+			root := filepath.Dir(path)
+			orig, _ := os.ReadFile(path)
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := processFile(config{TargetDir: root}, path, nil, []renderTarget{target}); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				_ = os.WriteFile(path, orig, 0o644)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestProcessFile_AllocBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocation budget is measured with a benchmark run")
+	}
+	if raceEnabled {
+		t.Skip("allocation budget does not hold under the race detector")
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	const size = 1 << 20
+	res := testing.Benchmark(func(b *testing.B) {
+		path, target := writeAllocFixture(b, size)
+		dest := filepath.Join(b.TempDir(), "out")
+		target.outDir = dest
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := processFile(config{TargetDir: filepath.Dir(path)}, path, nil, []renderTarget{target}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	if perByte := float64(res.AllocedBytesPerOp()) / size; perByte > allocBudget {
+		t.Errorf("processFile allocated %.1f bytes per input byte, budget is %d", perByte, allocBudget)
+	}
+}
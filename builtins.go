@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+// builtinValues returns the keys -builtins provides, for templates to stamp
+// where and when they were rendered. They are resolved once per run, so every
+// file and profile of a run sees the same charmap.time and charmap.runid.
+// Keys set by any other source win.
+func builtinValues() (map[string]string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("-builtins: %w", err)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("-builtins: %w", err)
+	}
+	return map[string]string{
+		"charmap.hostname": host,
+		"charmap.os":       runtime.GOOS,
+		"charmap.arch":     runtime.GOARCH,
+		"charmap.time":     time.Now().UTC().Format(time.RFC3339),
+		"charmap.runid":    hex.EncodeToString(id),
+	}, nil
+}
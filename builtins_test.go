@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/hex"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestBuiltinValues(t *testing.T) {
+	first, err := builtinValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first["charmap.os"] != runtime.GOOS || first["charmap.arch"] != runtime.GOARCH || first["charmap.hostname"] == "" {
+		t.Errorf("got %v", first)
+	}
+	if _, err := time.Parse(time.RFC3339, first["charmap.time"]); err != nil {
+		t.Errorf("charmap.time: %v", err)
+	}
+	if id, err := hex.DecodeString(first["charmap.runid"]); err != nil || len(id) != 8 {
+		t.Errorf("charmap.runid = %q", first["charmap.runid"])
+	}
+
+	second, err := builtinValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first["charmap.runid"] == second["charmap.runid"] {
+		t.Errorf("two runs share the id %s", first["charmap.runid"])
+	}
+}
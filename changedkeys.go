@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// changedKeyIndex selects the files a -changed-keys run renders, from the
+// keys each file referenced in the -manifest of an earlier run.
+type changedKeyIndex struct {
+	known    map[string]bool // inputs the manifest lists
+	affected map[string]bool // inputs referencing a changed key
+}
+
+// loadChangedKeyIndex reads the manifest at path as the key to file index
+// for keys.
+func loadChangedKeyIndex(path string, keys []string) (*changedKeyIndex, error) {
+	m, err := readManifest(path)
+	if err != nil {
+		return nil, fmt.Errorf("-changed-keys needs the -manifest of an earlier run: %w", err)
+	}
+	x := &changedKeyIndex{known: make(map[string]bool), affected: make(map[string]bool)}
+	for _, f := range m.Files {
+		x.known[f.Input] = true
+		for _, k := range f.Keys {
+			if slices.Contains(keys, k) {
+				x.affected[f.Input] = true
+			}
+		}
+	}
+	return x, nil
+}
+
+// selects reports whether path is rendered: it references a changed key, or
+// the manifest does not know it, being new since.
+func (x *changedKeyIndex) selects(path string) bool {
+	return x.affected[path] || !x.known[path]
+}
+
+// carryOver returns files, the manifest entries of a -changed-keys run, with
+// the entries of prev for every input the run did not render, so that the
+// manifest keeps describing the whole tree.
+func carryOver(prev, files []manifestFile) []manifestFile {
+	rendered := make(map[string]bool, len(files))
+	for _, f := range files {
+		rendered[f.Input] = true
+	}
+	for _, f := range prev {
+		if !rendered[f.Input] {
+			files = append(files, f)
+		}
+	}
+	return files
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestProcessTree_ChangedKeys(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	manifest := filepath.Join(t.TempDir(), "run.json")
+	write := func(name, txt string) {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(out, name))
+		return string(data)
+	}
+	write("db.yaml", "host: <::DB_HOST::>\n")
+	write("app.yaml", "name: <::APP::>\n")
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:    "<::",
+		CloseDelim:   "::>",
+		TargetDir:    src,
+		OutDir:       out,
+		Workers:      2,
+		KeyMap:       map[string]string{"DB_HOST": "db1", "APP": "web"},
+		FileFilter:   ff,
+		ManifestPath: manifest,
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("full run: %v", err)
+	}
+
+	// Only the file referencing the rotated key, and the new one, render.
+	write("new.yaml", "app: <::APP::>\n")
+	cfg.KeyMap = map[string]string{"DB_HOST": "db2", "APP": "api"}
+	cfg.ChangedKeys = []string{"DB_HOST"}
+	results, err := processTree(cfg)
+	if err != nil {
+		t.Fatalf("changed-keys run: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("rendered %d files, want 2", len(results))
+	}
+	for name, want := range map[string]string{"db.yaml": "host: db2\n", "app.yaml": "name: web\n", "new.yaml": "app: api\n"} {
+		if got := read(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	// The manifest still indexes the whole tree.
+	m, err := readManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputs []string
+	for _, f := range m.Files {
+		inputs = append(inputs, filepath.Base(f.Input))
+	}
+	slices.Sort(inputs)
+	if want := []string{"app.yaml", "db.yaml", "new.yaml"}; !slices.Equal(inputs, want) {
+		t.Errorf("manifest lists %v, want %v", inputs, want)
+	}
+
+	cfg.ManifestPath = filepath.Join(t.TempDir(), "missing.json")
+	if _, err := processTree(cfg); err == nil {
+		t.Error("changed-keys run without an earlier manifest succeeded")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// writeChecksums writes a sha256sum compatible listing of every rendered file
+// to path. Paths are relative to the directory of path, so that
+// `sha256sum -c` run there verifies the bundle. Files written to an -out-tar
+// archive are listed by their member name instead, to verify where it is
+// extracted. Files piped to -apply-cmd have no destination and are left out.
+func writeChecksums(path string, results []fileResult) error {
+	base, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	type entry struct{ name, sum string }
+	entries := make([]entry, 0, len(results))
+	for _, r := range results {
+		if r.Dest == "" {
+			continue
+		}
+		if r.Member != "" {
+			entries = append(entries, entry{r.Member, r.OutSum})
+			continue
+		}
+		abs, err := filepath.Abs(r.Dest)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, abs)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{filepath.ToSlash(rel), r.OutSum})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	var sb strings.Builder
+	for _, e := range entries {
+		sb.WriteString(e.sum + "  " + e.name + "\n")
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0o644)
+}
+
+// verifyChecksums checks every file listed in the checksum file at path and
+// writes one line per file that is missing or differs. It returns how many
+// failed.
+func verifyChecksums(path string, w io.Writer) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	base := filepath.Dir(path)
+
+	var n int
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		sum, name, ok := strings.Cut(sc.Text(), "  ")
+		if !ok || len(sum) != 64 {
+			return n, fmt.Errorf("%s:%d: expected HASH  PATH", path, line)
+		}
+		file := filepath.Join(base, filepath.FromSlash(name))
+		data, err := os.ReadFile(file)
+		switch {
+		case err != nil:
+			n++
+			fmt.Fprintf(w, "%s: %v\n", file, err)
+		case sha256Hex(data) != sum:
+			n++
+			fmt.Fprintf(w, "%s: checksum mismatch\n", file)
+		}
+	}
+	return n, sc.Err()
+}
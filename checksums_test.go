@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	for name, content := range map[string]string{"a.yaml": "a: <::A::>\n", "sub/b.yaml": "b: 2\n"} {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	sums := filepath.Join(out, "sha256sums.txt")
+	cfg := config{
+		OpenDelim:     "<::",
+		CloseDelim:    "::>",
+		TargetDir:     src,
+		OutDir:        out,
+		Workers:       2,
+		KeyMap:        map[string]string{"A": "1"},
+		FileFilter:    ff,
+		ChecksumsPath: sums,
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+
+	data, err := os.ReadFile(sums)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256Hex([]byte("a: 1\n")) + "  a.yaml\n" + sha256Hex([]byte("b: 2\n")) + "  sub/b.yaml\n"
+	if string(data) != want {
+		t.Errorf("checksums = %q, want %q", data, want)
+	}
+
+	if n, err := verifyChecksums(sums, io.Discard); err != nil || n != 0 {
+		t.Fatalf("verify fresh render: %d failure(s), %v", n, err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "a.yaml"), []byte("a: 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var report strings.Builder
+	if n, err := verifyChecksums(sums, &report); err != nil || n != 1 {
+		t.Fatalf("verify edited render: %d failure(s), %v", n, err)
+	}
+	if !strings.Contains(report.String(), "a.yaml: checksum mismatch") {
+		t.Errorf("report = %q", report.String())
+	}
+}
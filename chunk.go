@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// renderChunked renders txt in chunks of roughly chunkSize bytes in parallel,
+// so one huge file does not occupy a single worker for the whole run. Chunks
+// end at line boundaries outside any placeholder. Text with conditional
+// directives is rendered whole, since a block may span any number of lines.
+// Placeholders of every chunk are accounted in st unless it is nil.
+func renderChunked(render renderFunc, txt, open, close string, chunkSize int, st *renderStats) (string, error) {
+	if chunkSize <= 0 || len(txt) <= chunkSize || strings.Contains(txt, open+"#") {
+		return render(txt, st)
+	}
+	chunks := splitChunks(txt, open, close, chunkSize)
+	if len(chunks) == 1 {
+		return render(txt, st)
+	}
+
+	outs := make([]string, len(chunks))
+	errs := make([]error, len(chunks))
+	var stats []renderStats
+	if st != nil {
+		stats = make([]renderStats, len(chunks))
+	}
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, c := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			var cst *renderStats
+			if st != nil {
+				cst = &stats[i]
+			}
+			outs[i], errs[i] = render(c, cst)
+			<-sem
+		}()
+	}
+	wg.Wait()
+
+	// Report the first error in file order, as a whole-file render would.
+	start := 0
+	for i, err := range errs {
+		if err != nil {
+			if pe := (*placeholderError)(nil); errors.As(err, &pe) {
+				pe.shift(start)
+			}
+			return "", err
+		}
+		start += len(chunks[i])
+	}
+	for _, s := range stats {
+		st.add(s)
+	}
+	return strings.Join(outs, ""), nil
+}
+
+// splitChunks cuts txt after a newline at or beyond every chunkSize bytes,
+// moving the cut forward while it would split an open...close pair.
+func splitChunks(txt, open, close string, chunkSize int) []string {
+	var chunks []string
+	for len(txt) > chunkSize {
+		cut := safeCut(txt, open, close, chunkSize)
+		if cut == -1 {
+			break
+		}
+		chunks = append(chunks, txt[:cut])
+		txt = txt[cut:]
+	}
+	return append(chunks, txt)
+}
+
+// safeCut returns the offset just past the first newline at or after from
+// that lies outside a placeholder, or -1 if there is none.
+func safeCut(txt, open, close string, from int) int {
+	for {
+		nl := strings.IndexByte(txt[from:], '\n')
+		if nl == -1 {
+			return -1
+		}
+		cut := from + nl + 1
+		if cut == len(txt) {
+			return -1
+		}
+		last := strings.LastIndex(txt[:cut], open)
+		if last == -1 {
+			return cut
+		}
+		end := strings.Index(txt[last+len(open):], close)
+		if end != -1 && last+len(open)+end+len(close) <= cut {
+			return cut
+		}
+		from = cut
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderChunked(t *testing.T) {
+	values := map[string]string{"A": "alpha", "B": "beta"}
+	var sb strings.Builder
+	for i := 0; i < 500; i++ {
+		sb.WriteString("a: <::A::> b: <::B | upper::>\n")
+	}
+	in := sb.String()
+
+	whole := buildNewReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{})
+	chunked := buildNewReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{ChunkSize: 256})
+	want, _, err := whole([]byte(in))
+	if err != nil {
+		t.Fatalf("whole: %v", err)
+	}
+	got, changed, err := chunked([]byte(in))
+	if err != nil {
+		t.Fatalf("chunked: %v", err)
+	}
+	if string(got) != string(want) || !changed {
+		t.Errorf("chunked output differs from whole-file render")
+	}
+
+	_, _, err = chunked([]byte(in + "<::FIRST::>\n" + in + "<::SECOND::>\n"))
+	if err == nil || !strings.Contains(err.Error(), "FIRST") {
+		t.Errorf("expected the first missing key to be reported, got %v", err)
+	}
+}
+
+func TestSplitChunks_KeepsPlaceholdersWhole(t *testing.T) {
+	txt := "aaaa\nb <::K\nEY::> c\nddd\n"
+	chunks := splitChunks(txt, "<::", "::>", 4)
+	if strings.Join(chunks, "") != txt {
+		t.Fatalf("chunks do not reassemble: %q", chunks)
+	}
+	for _, c := range chunks {
+		if strings.Count(c, "<::") != strings.Count(c, "::>") {
+			t.Errorf("chunk %q splits a placeholder", c)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+// command is a subcommand entry point. args are the positional arguments left
+// over after flag parsing.
+type command func(cfg config, args []string) error
+
+// commands maps subcommand names to their entry points. Two-word names such
+// as "config validate" are matched before one-word ones.
+var commands = map[string]command{
+	"render":  renderCmd,
+	"diff":    diffCmd,
+	"test":    testCmd,
+	"bench":   benchCmd,
+	"check":   checkCmd,
+	"rewrite": rewriteCmd,
+	"doctor":  doctorCmd,
+	"lock":    lockCmd,
+	"sidecar": sidecarWatchCmd,
+	"graph":   graphCmd,
+
+	"snapshot-values": snapshotValuesCmd,
+	"gen-fixtures":    genFixturesCmd,
+	"secrets":         secretsCmd,
+	"verify":          verifyCmd,
+	"history":         historyCmd,
+	"list-keys":       listKeysCmd,
+	"config validate": configValidateCmd,
+	"config print":    configPrintCmd,
+}
+
+// renderCmd renders exactly one file, given as the argument or with -i ("-"
+// for stdin), to stdout or to the -o file. Nothing else is written.
+func renderCmd(cfg config, args []string) error {
+	path := cfg.RenderIn
+	switch {
+	case path != "" && len(args) != 0:
+		return fmt.Errorf("render: -i and a file argument are mutually exclusive")
+	case path == "" && len(args) != 1:
+		return fmt.Errorf("render: expected exactly one file, got %d", len(args))
+	case path == "":
+		path = args[0]
+	}
+	if err := checkDelims(cfg); err != nil {
+		return err
+	}
+	if cfg.Frozen {
+		if err := checkFrozen(cfg); err != nil {
+			return err
+		}
+	}
+	if cfg.RenderOut == "" {
+		return renderFile(path, cfg, os.Stdout)
+	}
+
+	var buf bytes.Buffer
+	if err := renderFile(path, cfg, &buf); err != nil {
+		return err
+	}
+	mode := fs.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil && path != "-" {
+		mode = fi.Mode().Perm()
+	}
+	return diskSink{}.WriteFile(filepath.ToSlash(cfg.RenderOut), path, buf.Bytes(), mode)
+}
+
+// renderFile renders path, or standard input for "-", to w.
+func renderFile(path string, cfg config, w io.Writer) error {
+	var in []byte
+	var err error
+	if path == "-" {
+		in, err = readStdin()
+	} else {
+		in, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	open, close := cfg.delims(path)
+	in, spans, err := expandIncludesMapped(cfg.includeLookup(), path, in, open, close)
+	if err != nil {
+		return err
+	}
+	if err := checkPragmaDelims(cfg, in); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	values, opts, err := fileValues(path, cfg, cfg.KeyMap, cfg.replacerOptions())
+	if err != nil {
+		return err
+	}
+	replacer := buildNewReplacer([]byte(open), []byte(close), values, opts)
+	out, _, err := replacer(in)
+	if err != nil {
+		if pe := (*placeholderError)(nil); errors.As(err, &pe) {
+			pe.relocate(path, spans)
+		}
+		return fmt.Errorf("failed to render %q: %w", path, err)
+	}
+	if normalize := normalizerFor(cfg.Normalize, path); normalize != nil {
+		if out, err = normalize(out); err != nil {
+			return fmt.Errorf("failed to normalize %q: %w", path, err)
+		}
+	}
+
+	_, err = w.Write(out)
+	return err
+}
+
+// diffCmd is the read-only counterpart of -out: it renders every matching
+// file and prints how the result differs from what is currently in -out.
+func diffCmd(cfg config, args []string) error {
+	if cfg.OutDir == "" {
+		return fmt.Errorf("diff: -out must be set")
+	}
+	if cfg.Encrypter != nil {
+		return fmt.Errorf("diff: encrypted output cannot be compared")
+	}
+	if err := checkDelims(cfg); err != nil {
+		return err
+	}
+
+	changed, err := diffTree(cfg, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if len(changed) > 0 {
+		fmt.Printf("\n%d changed file(s):\n", len(changed))
+		for _, p := range changed {
+			fmt.Println("  " + p)
+		}
+	}
+	return nil
+}
+
+// diffTree writes a unified diff for every file whose fresh render differs
+// from its counterpart under cfg.OutDir and returns the differing output paths.
+func diffTree(cfg config, w io.Writer) ([]string, error) {
+	open, close := []byte(cfg.OpenDelim), []byte(cfg.CloseDelim)
+	base := renderTarget{
+		replacer: buildCountingReplacer(open, close, cfg.KeyMap, cfg.replacerOptions()),
+		keyMap:   cfg.KeyMap,
+		open:     open,
+		close:    close,
+		opts:     cfg.replacerOptions(),
+	}
+	base.compileExtDelims(cfg.ExtDelims)
+
+	var changed []string
+	err := walkFiles(cfg, func(path string) error {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if cfg.skipContent(in) {
+			return nil
+		}
+		if in, err = cfg.decode(path, in); err != nil {
+			return err
+		}
+		if in, err = cfg.withIncludes(path, in); err != nil {
+			return err
+		}
+		if err := checkPragmaDelims(cfg, in); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		t := base.forPath(path)
+		if side, _, err := loadLocalValues(path, cfg); err != nil {
+			return err
+		} else if side != nil {
+			values, opts, err := withSidecar(side, cfg.KeyMap, cfg.replacerOptions())
+			if err != nil {
+				return err
+			}
+			t.replacer = buildCountingReplacer(t.open, t.close, values, opts)
+		}
+		out, _, err := t.replacer(in, nil)
+		if err != nil {
+			return fmt.Errorf("failed to render %q: %w", path, err)
+		}
+		if normalize := normalizerFor(cfg.Normalize, path); normalize != nil {
+			if out, err = normalize(out); err != nil {
+				return fmt.Errorf("failed to normalize %q: %w", path, err)
+			}
+		}
+
+		dest, err := targetPath(cfg.TargetDir, path, renderTarget{outDir: cfg.OutDir, outPath: cfg.OutPath})
+		if err != nil {
+			return err
+		}
+		prevName := dest
+		prev, err := os.ReadFile(dest)
+		if errors.Is(err, fs.ErrNotExist) {
+			prevName = "/dev/null"
+		} else if err != nil {
+			return err
+		}
+		if cfg.Header {
+			prev = withoutHeader(prev)
+		}
+
+		if d := unifiedDiff(prevName, dest, prev, out); d != "" {
+			changed = append(changed, dest)
+			_, err = io.WriteString(w, d)
+		}
+		return err
+	})
+	return changed, err
+}
+
+// testCmd renders every template with fixture values and compares the result
+// with the golden copy at the same relative path under -golden. With -update
+// the golden files are rewritten instead.
+func testCmd(cfg config, args []string) error {
+	if cfg.GoldenDir == "" {
+		return fmt.Errorf("test: -golden must be set")
+	}
+	if cfg.Mode != "flag" {
+		return fmt.Errorf("test: use -mode flag so renders do not depend on the environment")
+	}
+
+	cfg.OutDir = cfg.GoldenDir
+	if cfg.Update {
+		return processFiles(cfg)
+	}
+
+	failed, err := diffTree(cfg, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d template(s) do not match golden output, run with -update to accept", len(failed))
+	}
+	return nil
+}
+
+// checkCmd reports every placeholder under -dir whose key has no value, with
+// its line, for each profile. With -checksums it also verifies the rendered
+// files listed there. Nothing is rendered or written.
+func checkCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("check: unexpected arguments %v", args)
+	}
+	n, err := checkTree(cfg, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if cfg.ChecksumsPath != "" {
+		bad, err := verifyChecksums(cfg.ChecksumsPath, os.Stdout)
+		if err != nil {
+			return err
+		}
+		if bad > 0 {
+			return fmt.Errorf("%d unresolved placeholder(s), %d file(s) failing %s", n, bad, cfg.ChecksumsPath)
+		}
+	}
+	if n > 0 {
+		return fmt.Errorf("%d unresolved placeholder(s)", n)
+	}
+	return nil
+}
+
+// checkTree writes one line per unresolved placeholder and returns how many
+// it found. With cfg.KeySummary it then writes how many files and placeholders
+// use each key.
+func checkTree(cfg config, w io.Writer) (int, error) {
+	keySets := []profile{{KeyMap: cfg.KeyMap}}
+	if len(cfg.Profiles) > 0 {
+		keySets = cfg.Profiles
+	}
+	usage := make([]keyUsages, len(keySets))
+	for i := range usage {
+		usage[i] = make(keyUsages)
+	}
+
+	var n int
+	err := walkFiles(cfg, func(path string) error {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if cfg.skipContent(in) {
+			return nil
+		}
+		if in, err = cfg.withIncludes(path, in); err != nil {
+			return err
+		}
+		open, close := cfg.delims(path)
+		for i, ks := range keySets {
+			values, opts, err := fileValues(path, cfg, ks.KeyMap, cfg.replacerOptions())
+			if err != nil {
+				return err
+			}
+			refs, err := scanPlaceholders(string(in), open, close, values, opts)
+			if err != nil {
+				return fmt.Errorf("failed to check %q: %w", path, err)
+			}
+			for _, ref := range refs {
+				var e error
+				if ref.Source != nil {
+					if _, e = ref.Source.value(opts); e == nil || ref.HasDefault {
+						continue
+					}
+				} else {
+					usage[i].add(ref.Key, path)
+					if _, ok := values[ref.Key]; ok || ref.HasDefault {
+						continue
+					}
+					e = &missingKeyError{key: ref.Key, denied: opts.Denied[ref.Key]}
+				}
+				n++
+				if ks.Name != "" {
+					fmt.Fprintf(w, "%s:%d: profile %q: %v\n", path, ref.Line, ks.Name, e)
+				} else {
+					fmt.Fprintf(w, "%s:%d: %v\n", path, ref.Line, e)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil || !cfg.KeySummary {
+		return n, err
+	}
+
+	for i, ks := range keySets {
+		fmt.Fprintln(w)
+		if ks.Name != "" {
+			fmt.Fprintf(w, "profile %q:\n", ks.Name)
+		}
+		if err := writeKeyUsage(w, usage[i]); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// keyUsage counts the files and placeholders referencing one key.
+type keyUsage struct {
+	files       int
+	occurrences int
+	last        string // last file counted
+}
+
+// keyUsages holds the keyUsage of every key. Files must be added one after
+// the other, as walkFiles visits them.
+type keyUsages map[string]*keyUsage
+
+func (u keyUsages) add(key, path string) {
+	ku := u[key]
+	if ku == nil {
+		ku = &keyUsage{}
+		u[key] = ku
+	}
+	ku.occurrences++
+	if ku.last != path {
+		ku.files++
+		ku.last = path
+	}
+}
+
+// writeKeyUsage writes a table of u sorted by key.
+func writeKeyUsage(w io.Writer, u keyUsages) error {
+	keys := make([]string, 0, len(u))
+	for k := range u {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tFILES\tOCCURRENCES")
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", k, u[k].files, u[k].occurrences)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRenderFile_DoesNotWrite(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "config.yaml")
+	const input = "domain: <::PUBLIC_DOMAIN::>\n"
+	if err := os.WriteFile(src, []byte(input), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		KeyMap:     map[string]string{"PUBLIC_DOMAIN": "example.com"},
+	}
+
+	var buf bytes.Buffer
+	if err := renderFile(src, cfg, &buf); err != nil {
+		t.Fatalf("renderFile: %v", err)
+	}
+	if want := "domain: example.com\n"; buf.String() != want {
+		t.Errorf("rendered %q, want %q", buf.String(), want)
+	}
+
+	got, _ := os.ReadFile(src)
+	if string(got) != input {
+		t.Errorf("source file was modified: %q", got)
+	}
+}
+
+func TestRenderCmd_InOut(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "app.tpl"), filepath.Join(dir, "app.yaml")
+	if err := os.WriteFile(src, []byte("v: <::V::>\n"), 0o600); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		KeyMap:     map[string]string{"V": "1"},
+		RenderIn:   src,
+		RenderOut:  dest,
+	}
+	if err := renderCmd(cfg, nil); err != nil {
+		t.Fatalf("renderCmd: %v", err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil || string(got) != "v: 1\n" {
+		t.Errorf("rendered %q (%v), want %q", got, err, "v: 1\n")
+	}
+	if fi, err := os.Stat(dest); err == nil && runtime.GOOS != "windows" && fi.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want the template's 0600", fi.Mode().Perm())
+	}
+
+	if err := renderCmd(cfg, []string{src}); err == nil {
+		t.Error("-i together with a file argument was accepted")
+	}
+}
+
+func TestDiffTree(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	write := func(path, data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	write(filepath.Join(src, "same.yaml"), "a: <::A::>\n")
+	write(filepath.Join(src, "changed.yaml"), "a: 1\nb: <::B::>\nc: 3\n")
+	write(filepath.Join(src, "new.yaml"), "n: <::A::>\n")
+	write(filepath.Join(out, "same.yaml"), "a: x\n")
+	write(filepath.Join(out, "changed.yaml"), "a: 1\nb: old\nc: 3\n")
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		KeyMap:     map[string]string{"A": "x", "B": "new"},
+		FileFilter: ff,
+	}
+
+	var buf bytes.Buffer
+	changed, err := diffTree(cfg, &buf)
+	if err != nil {
+		t.Fatalf("diffTree: %v", err)
+	}
+	if len(changed) != 2 {
+		t.Errorf("changed = %v, want changed.yaml and new.yaml", changed)
+	}
+
+	wantHunk := "@@ -1,3 +1,3 @@\n a: 1\n-b: old\n+b: new\n c: 3\n"
+	if !strings.Contains(buf.String(), wantHunk) {
+		t.Errorf("diff output missing hunk %q:\n%s", wantHunk, buf.String())
+	}
+	if !strings.Contains(buf.String(), "--- /dev/null") {
+		t.Errorf("diff output missing new file header:\n%s", buf.String())
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "changed.yaml")); string(got) != "a: 1\nb: old\nc: 3\n" {
+		t.Errorf("diff modified output file: %q", got)
+	}
+}
+
+func TestTestCmd_UpdateThenCompare(t *testing.T) {
+	src, golden := t.TempDir(), t.TempDir()
+	tmpl := filepath.Join(src, "app.yaml")
+	if err := os.WriteFile(tmpl, []byte("replicas: <::REPLICAS::>\n"), 0o644); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		GoldenDir:  golden,
+		Mode:       "flag",
+		Workers:    1,
+		KeyMap:     map[string]string{"REPLICAS": "3"},
+		FileFilter: ff,
+	}
+
+	if err := testCmd(cfg, nil); err == nil {
+		t.Fatalf("expected failure with missing golden files")
+	}
+
+	cfg.Update = true
+	if err := testCmd(cfg, nil); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	cfg.Update = false
+	if err := testCmd(cfg, nil); err != nil {
+		t.Fatalf("compare after update: %v", err)
+	}
+
+	cfg.KeyMap["REPLICAS"] = "5"
+	if err := testCmd(cfg, nil); err == nil {
+		t.Errorf("expected mismatch after template values changed")
+	}
+}
+
+func TestCheckTree(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("a: <::A::>\nb: <::B::>\nc: <::C::> <::B::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		KeyMap:     map[string]string{"A": "1"},
+		FileFilter: ff,
+		Denied:     map[string]bool{"C": true},
+	}
+
+	var buf bytes.Buffer
+	n, err := checkTree(cfg, &buf)
+	if err != nil {
+		t.Fatalf("checkTree: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("found %d unresolved placeholders, want 3:\n%s", n, buf.String())
+	}
+	for _, want := range []string{
+		"app.yaml:2: env/flag \"B\" not set",
+		"app.yaml:3: key \"C\" is not in the allow-list",
+		"app.yaml:3: env/flag \"B\" not set",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestCheckTree_KeySummary(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"a.yaml": "host: <::PUBLIC_DOMAIN::>\nurl: https://<::PUBLIC_DOMAIN | lower::>/\n",
+		"b.yaml": "host: <::PUBLIC_DOMAIN::>\nport: <::PORT::>\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		KeyMap:     map[string]string{"PUBLIC_DOMAIN": "example.com", "PORT": "80"},
+		FileFilter: ff,
+		KeySummary: true,
+	}
+
+	var buf bytes.Buffer
+	if n, err := checkTree(cfg, &buf); err != nil || n != 0 {
+		t.Fatalf("checkTree = %d, %v", n, err)
+	}
+	want := "\nKEY            FILES  OCCURRENCES\nPORT           1      1\nPUBLIC_DOMAIN  2      3\n"
+	if buf.String() != want {
+		t.Errorf("summary = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expandConditionals resolves #if/#elif/#else/#end directive blocks, e.g.
+//
+//	<::#if eq(ENV, "prod")::>
+//	replicas: 3
+//	<::#else::>
+//	replicas: 1
+//	<::#end::>
+//
+// Text in branches that are not taken is dropped before any substitution, so
+// keys only referenced there need not be set. A directive alone on its line
+// removes the whole line.
+func expandConditionals(txt, open, close string, values map[string]string) (string, error) {
+	return resolveConditionals(txt, open, close, values, false)
+}
+
+// resolveConditionals implements expandConditionals. With keepLines, dropped
+// text and directives are replaced by their newlines so that line numbers in
+// the result match txt.
+func resolveConditionals(txt, open, close string, values map[string]string, keepLines bool) (string, error) {
+	if !strings.Contains(txt, open+"#") {
+		return txt, nil
+	}
+
+	type frame struct {
+		active bool // current branch is emitting
+		taken  bool // some branch of this block has been taken
+		parent bool // enclosing block is emitting
+		line   int
+	}
+	var stack []frame
+	emitting := func() bool {
+		return len(stack) == 0 || (stack[len(stack)-1].active && stack[len(stack)-1].parent)
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(txt))
+	drop := func(s string) {
+		if keepLines {
+			sb.WriteString(strings.Repeat("\n", strings.Count(s, "\n")))
+		}
+	}
+	pos := 0
+	for {
+		idx := strings.Index(txt[pos:], open)
+		if idx == -1 {
+			break
+		}
+		idx += pos
+		start := idx + len(open)
+		end := strings.Index(txt[start:], close)
+		if end == -1 {
+			break
+		}
+		end += start
+		expr := strings.TrimSpace(txt[start:end])
+		if !strings.HasPrefix(expr, "#") {
+			if emitting() {
+				sb.WriteString(txt[pos : end+len(close)])
+			} else {
+				drop(txt[pos : end+len(close)])
+			}
+			pos = end + len(close)
+			continue
+		}
+
+		// Swallow the line when the directive is the only thing on it.
+		before, after := idx, end+len(close)
+		lineStart := strings.LastIndexByte(txt[:idx], '\n') + 1
+		lineEnd := strings.IndexByte(txt[after:], '\n')
+		if lineEnd == -1 {
+			lineEnd = len(txt)
+		} else {
+			lineEnd += after + 1
+		}
+		if strings.TrimSpace(txt[lineStart:idx]) == "" && strings.TrimSpace(txt[after:lineEnd]) == "" && lineStart >= pos {
+			before, after = lineStart, lineEnd
+		}
+		if emitting() {
+			sb.WriteString(txt[pos:before])
+		} else {
+			drop(txt[pos:before])
+		}
+		drop(txt[before:after])
+		pos = after
+
+		line := strings.Count(txt[:idx], "\n") + 1
+		name, arg, _ := strings.Cut(expr[1:], " ")
+		arg = strings.TrimSpace(arg)
+		switch name {
+		case "if":
+			f := frame{parent: emitting(), line: line}
+			if f.parent {
+				ok, err := evalCondition(arg, values)
+				if err != nil {
+					return "", fmt.Errorf("line %d: %w", line, err)
+				}
+				f.active, f.taken = ok, ok
+			}
+			stack = append(stack, f)
+		case "elif":
+			if len(stack) == 0 {
+				return "", fmt.Errorf("line %d: #elif without #if", line)
+			}
+			f := &stack[len(stack)-1]
+			f.active = false
+			if f.parent && !f.taken {
+				ok, err := evalCondition(arg, values)
+				if err != nil {
+					return "", fmt.Errorf("line %d: %w", line, err)
+				}
+				f.active, f.taken = ok, ok
+			}
+		case "else":
+			if len(stack) == 0 {
+				return "", fmt.Errorf("line %d: #else without #if", line)
+			}
+			f := &stack[len(stack)-1]
+			f.active = !f.taken
+			f.taken = true
+		case "end":
+			if len(stack) == 0 {
+				return "", fmt.Errorf("line %d: #end without #if", line)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			return "", fmt.Errorf("line %d: unknown directive %q", line, "#"+name)
+		}
+	}
+	if len(stack) > 0 {
+		return "", fmt.Errorf("line %d: #if without #end", stack[len(stack)-1].line)
+	}
+	sb.WriteString(txt[pos:])
+	return sb.String(), nil
+}
+
+// evalCondition evaluates a directive expression. The grammar is deliberately
+// small: string and number literals, keys, function calls, !, && and ||.
+//
+//	eq(a, b)  ne(a, b)  has(KEY)  empty(a)  contains(s, sub)
+//
+// A value is true unless it is empty, "false" or "0".
+func evalCondition(src string, values map[string]string) (bool, error) {
+	p := &exprParser{src: src, values: values}
+	v, err := p.parseOr()
+	if err != nil {
+		return false, fmt.Errorf("condition %q: %w", src, err)
+	}
+	if p.skipSpace(); p.pos != len(p.src) {
+		return false, fmt.Errorf("condition %q: unexpected %q", src, p.src[p.pos:])
+	}
+	return truthy(v), nil
+}
+
+func truthy(v string) bool {
+	return v != "" && v != "false" && v != "0"
+}
+
+func boolString(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+type exprParser struct {
+	src    string
+	pos    int
+	values map[string]string
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) consume(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (string, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for p.consume("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		l = boolString(truthy(l) || truthy(r))
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAnd() (string, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return "", err
+	}
+	for p.consume("&&") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return "", err
+		}
+		l = boolString(truthy(l) && truthy(r))
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseUnary() (string, error) {
+	if p.consume("!") {
+		v, err := p.parseUnary()
+		if err != nil {
+			return "", err
+		}
+		return boolString(!truthy(v)), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+
+	switch c := p.src[p.pos]; {
+	case c == '(':
+		p.pos++
+		v, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if !p.consume(")") {
+			return "", fmt.Errorf("missing ')'")
+		}
+		return v, nil
+	case c == '"':
+		end := p.pos + 1
+		for end < len(p.src) && p.src[end] != '"' {
+			if p.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.src) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		s, err := strconv.Unquote(p.src[p.pos : end+1])
+		if err != nil {
+			return "", err
+		}
+		p.pos = end + 1
+		return s, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		end := p.pos + 1
+		for end < len(p.src) && (p.src[end] == '.' || (p.src[end] >= '0' && p.src[end] <= '9')) {
+			end++
+		}
+		num := p.src[p.pos:end]
+		p.pos = end
+		return num, nil
+	case isIdentByte(c):
+		end := p.pos
+		for end < len(p.src) && (isIdentByte(p.src[end]) || (p.src[end] >= '0' && p.src[end] <= '9') || p.src[end] == '.') {
+			end++
+		}
+		ident := p.src[p.pos:end]
+		p.pos = end
+		if p.consume("(") {
+			return p.parseCall(ident)
+		}
+		v, ok := p.values[ident]
+		if !ok {
+			return "", &missingKeyError{key: ident}
+		}
+		return v, nil
+	default:
+		return "", fmt.Errorf("unexpected %q", p.src[p.pos:])
+	}
+}
+
+// conditionKeys returns the keys a condition refers to: identifiers outside
+// string literals that are not function names.
+func conditionKeys(src string) []string {
+	var keys []string
+	for i := 0; i < len(src); {
+		switch c := src[i]; {
+		case c == '"':
+			i++
+			for i < len(src) && src[i] != '"' {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+		case isIdentByte(c):
+			end := i
+			for end < len(src) && (isIdentByte(src[end]) || (src[end] >= '0' && src[end] <= '9') || src[end] == '.') {
+				end++
+			}
+			if !strings.HasPrefix(strings.TrimLeft(src[end:], " \t"), "(") {
+				keys = append(keys, src[i:end])
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return keys
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (p *exprParser) parseCall(name string) (string, error) {
+	// has() takes a bare key that may be unset, so it cannot evaluate its argument.
+	if name == "has" {
+		p.skipSpace()
+		end := p.pos
+		for end < len(p.src) && p.src[end] != ')' && p.src[end] != ' ' {
+			end++
+		}
+		key := p.src[p.pos:end]
+		p.pos = end
+		if !p.consume(")") {
+			return "", fmt.Errorf("has: missing ')'")
+		}
+		_, ok := p.values[key]
+		return boolString(ok), nil
+	}
+
+	var args []string
+	if !p.consume(")") {
+		for {
+			v, err := p.parseOr()
+			if err != nil {
+				return "", err
+			}
+			args = append(args, v)
+			if p.consume(")") {
+				break
+			}
+			if !p.consume(",") {
+				return "", fmt.Errorf("%s: expected ',' or ')'", name)
+			}
+		}
+	}
+
+	want := map[string]int{"eq": 2, "ne": 2, "empty": 1, "contains": 2}
+	n, ok := want[name]
+	if !ok {
+		return "", fmt.Errorf("unknown function %q", name)
+	}
+	if len(args) != n {
+		return "", fmt.Errorf("%s: expected %d arguments, got %d", name, n, len(args))
+	}
+
+	switch name {
+	case "eq":
+		return boolString(args[0] == args[1]), nil
+	case "ne":
+		return boolString(args[0] != args[1]), nil
+	case "empty":
+		return boolString(args[0] == ""), nil
+	default: // contains
+		return boolString(strings.Contains(args[0], args[1])), nil
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestExpandConditionals(t *testing.T) {
+	values := map[string]string{"ENV": "prod", "TIER": "web"}
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "taken if",
+			in:   "a\n<::#if eq(ENV, \"prod\")::>\nreplicas: 3\n<::#else::>\nreplicas: 1\n<::#end::>\nb\n",
+			want: "a\nreplicas: 3\nb\n",
+		},
+		{
+			name: "elif and unset key in dropped branch",
+			in:   "<::#if eq(ENV, \"dev\")::>\nx: <::DEV_ONLY::>\n<::#elif has(TIER) && !empty(TIER)::>\ntier\n<::#end::>\n",
+			want: "tier\n",
+		},
+		{
+			name: "nested and inline",
+			in:   "v: <::#if ne(ENV, \"dev\")::><::#if contains(TIER, \"we\")::>yes<::#end::><::#end::>\n",
+			want: "v: yes\n",
+		},
+		{
+			name: "plain placeholders pass through",
+			in:   "<::#if ENV::>k: <::KEY::>\n<::#end::>",
+			want: "k: <::KEY::>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := expandConditionals(tt.in, "<::", "::>", values)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"<::#if ENV::>",
+		"<::#end::>",
+		"<::#if eq(ENV)::><::#end::>",
+		"<::#if os.exit(1)::><::#end::>",
+		"<::#if MISSING::><::#end::>",
+	} {
+		if _, err := expandConditionals(bad, "<::", "::>", values); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// loadConfigFile sets every flag named in the JSON object at path that was not
+// given on the command line, so the command line always wins. Values are
+// strings, numbers or booleans, or arrays of them for repeatable flags such
+// as include or set:
+//
+//	{"dir": "templates", "include": ["\\.tpl$"], "workers": 4}
+func loadConfigFile(fset *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	given := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	for name, v := range raw {
+		if fset.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown flag %q", path, name)
+		}
+		if given[name] {
+			continue
+		}
+		vals, ok := v.([]any)
+		if !ok {
+			vals = []any{v}
+		}
+		for _, v := range vals {
+			var s string
+			switch v := v.(type) {
+			case string:
+				s = v
+			case json.Number:
+				s = v.String()
+			case bool:
+				s = strconv.FormatBool(v)
+			default:
+				return fmt.Errorf("%s: flag %q: unsupported value %v", path, name, v)
+			}
+			if err := fset.Set(name, s); err != nil {
+				return fmt.Errorf("%s: flag %q: %w", path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// noteFlagOrigins records source as the origin of every flag of fset that is
+// set and has no origin yet. Called after each stage of loading, it tells
+// flags of the command line from those of the -config file.
+func noteFlagOrigins(fset *flag.FlagSet, origins map[string]string, source string) {
+	fset.Visit(func(f *flag.Flag) {
+		if _, ok := origins[f.Name]; !ok {
+			origins[f.Name] = source
+		}
+	})
+}
+
+// configPrintCmd prints the effective configuration: every flag that was set
+// and where, and with -resolved every other flag at its default and every key
+// with its value and source, per profile. Values of secret looking keys are
+// redacted.
+func configPrintCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("config print: unexpected arguments %v", args)
+	}
+	return writeEffectiveConfig(os.Stdout, flag.CommandLine, cfg)
+}
+
+// writeEffectiveConfig implements configPrintCmd for the flags of fset. Key
+// values are only printed with cfg.ShowValues, and neither there nor in flags
+// such as -set are values printed that look like secrets, by the name of
+// their key or by their content.
+func writeEffectiveConfig(w io.Writer, fset *flag.FlagSet, cfg config) error {
+	var secrets []string
+	for k, v := range cfg.KeyMap {
+		if v != "" && secretValue(k, v) {
+			secrets = append(secrets, v)
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	fset.VisitAll(func(f *flag.Flag) {
+		source, set := cfg.FlagOrigins[f.Name]
+		if !set {
+			if !cfg.ResolvedConfig {
+				return
+			}
+			source = "default"
+		}
+		v := f.Value.String()
+		for _, s := range secrets {
+			v = strings.ReplaceAll(v, s, redactedValue)
+		}
+		if v == "" {
+			v = `""`
+		}
+		fmt.Fprintf(tw, "-%s\t%s\t%s\n", f.Name, v, source)
+	})
+	if !cfg.ResolvedConfig {
+		return tw.Flush()
+	}
+
+	sets := []profile{{KeyMap: cfg.KeyMap, Origins: cfg.Origins}}
+	if len(cfg.Profiles) > 0 {
+		sets = cfg.Profiles
+	}
+	for _, ks := range sets {
+		fmt.Fprintln(tw)
+		header := "KEY"
+		if ks.Name != "" {
+			header = fmt.Sprintf("KEY (profile %s)", ks.Name)
+		}
+		if cfg.ShowValues {
+			header += "\tVALUE"
+		}
+		fmt.Fprintln(tw, header+"\tSOURCE")
+		keys := make([]string, 0, len(ks.KeyMap))
+		for k := range ks.KeyMap {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			source := ks.Origins[k]
+			if source == "" {
+				source = "-"
+			}
+			if !cfg.ShowValues {
+				fmt.Fprintf(tw, "%s\t%s\n", k, source)
+				continue
+			}
+			v := strconv.Quote(ks.KeyMap[k])
+			if secretValue(k, ks.KeyMap[k]) {
+				v = redactedValue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", k, v, source)
+		}
+	}
+	return tw.Flush()
+}
+
+// secretValue reports whether the value v of key k must not be printed: the
+// name of k or v itself looks like a secret.
+func secretValue(k, v string) bool {
+	return looksSecret(k) || classifySecret(k, v) != ""
+}
+
+// configValidateCmd checks the configuration without reading templates or
+// writing anything. Loading it already compiled every pattern and parsed
+// every values, profile and filter file; this also walks -dir, which fails on
+// symlinks or mounts escaping it, and reports -include patterns that match no
+// file.
+func configValidateCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("config validate: unexpected arguments %v", args)
+	}
+	n, err := validateConfig(cfg, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%d configuration problem(s)", n)
+	}
+	return nil
+}
+
+// validateConfig writes one line per problem found in cfg and returns how many
+// it found.
+func validateConfig(cfg config, w io.Writer) (int, error) {
+	var files int
+	var used []bool
+	if cfg.FileFilter != nil {
+		used = make([]bool, len(cfg.FileFilter.includes))
+	}
+	err := walkFiles(cfg, func(path string) error {
+		files++
+		for i, rx := range cfg.FileFilter.includes {
+			used[i] = used[i] || rx.MatchString(filepath.ToSlash(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to walk directory %q: %w", cfg.TargetDir, err)
+	}
+
+	var n int
+	if files == 0 {
+		n++
+		fmt.Fprintf(w, "no file under %q matches -include/-ignore\n", cfg.TargetDir)
+	}
+	for i, ok := range used {
+		if !ok && files > 0 {
+			n++
+			fmt.Fprintf(w, "-include %q matches no file under %q\n", cfg.FileFilter.includes[i], cfg.TargetDir)
+		}
+	}
+	if n == 0 {
+		fmt.Fprintf(w, "configuration ok: %d file(s) match, %d key(s) defined\n", files, len(cfg.KeyMap))
+	}
+	return n, nil
+}
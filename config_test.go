@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "charmap.json")
+	data := `{"dir": "templates", "workers": 4, "header": true, "include": ["\\.tpl$", "\\.env$"]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+
+	fset := flag.NewFlagSet("test", flag.ContinueOnError)
+	dir := fset.String("dir", ".", "")
+	workers := fset.Int("workers", 1, "")
+	hdr := fset.Bool("header", false, "")
+	var include sliceFlag
+	fset.Var(&include, "include", "")
+	if err := fset.Parse([]string{"-workers", "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadConfigFile(fset, path); err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	if *dir != "templates" || !*hdr || len(include) != 2 {
+		t.Errorf("got dir=%q header=%v include=%v, want the config file values", *dir, *hdr, include)
+	}
+	if *workers != 2 {
+		t.Errorf("workers = %d, want the command line value 2", *workers)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"wrokers": 4}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfigFile(fset, path); err == nil || !strings.Contains(err.Error(), `unknown flag "wrokers"`) {
+		t.Errorf("got %v, want an unknown flag error", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`, `\.tpl$`}, nil)
+	cfg := config{TargetDir: src, FileFilter: ff}
+
+	var buf bytes.Buffer
+	n, err := validateConfig(cfg, &buf)
+	if err != nil {
+		t.Fatalf("validateConfig: %v", err)
+	}
+	if n != 1 || !strings.Contains(buf.String(), `-include "\\.tpl$" matches no file`) {
+		t.Errorf("got %d problem(s):\n%s\nwant the unused .tpl pattern", n, buf.String())
+	}
+}
+
+func TestWriteEffectiveConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "charmap.json")
+	if err := os.WriteFile(path, []byte(`{"dir": "templates", "workers": 4}`), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	fset := flag.NewFlagSet("test", flag.ContinueOnError)
+	fset.String("dir", ".", "")
+	fset.Int("workers", 1, "")
+	fset.String("out", "", "")
+	var set sliceFlag
+	fset.Var(&set, "set", "")
+	if err := fset.Parse([]string{"-workers", "2", "-set", "DB_PASSWORD=hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	origins := make(map[string]string)
+	noteFlagOrigins(fset, origins, "command line")
+	if err := loadConfigFile(fset, path); err != nil {
+		t.Fatal(err)
+	}
+	noteFlagOrigins(fset, origins, "config file "+path)
+
+	cfg := config{
+		FlagOrigins: origins,
+		KeyMap:      map[string]string{"DB_PASSWORD": "hunter2", "HOST": "db1"},
+		Origins:     map[string]string{"DB_PASSWORD": "flag", "HOST": "env"},
+	}
+	var buf bytes.Buffer
+	if err := writeEffectiveConfig(&buf, fset, cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := "SETTING   VALUE                     SOURCE\n" +
+		"-dir      templates                 config file " + path + "\n" +
+		"-set      [DB_PASSWORD=<redacted>]  command line\n" +
+		"-workers  2                         command line\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	cfg.ResolvedConfig = true
+	if err := writeEffectiveConfig(&buf, fset, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"-out      \"\"", "HOST         env", "DB_PASSWORD  flag"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("resolved output lacks %q:\n%s", line, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "db1") {
+		t.Errorf("resolved output prints a value without -show-values:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	cfg.ShowValues = true
+	cfg.KeyMap["DEPLOY_KEY"] = "ghp_0123456789abcdefghijklmnop"
+	cfg.Origins["DEPLOY_KEY"] = "env"
+	if err := writeEffectiveConfig(&buf, fset, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"HOST         \"db1\"", "DB_PASSWORD  <redacted>  flag", "DEPLOY_KEY   <redacted>  env"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("-show-values output lacks %q:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// commonDelims are delimiter pairs that other syntaxes use all the time, so
+// that unrelated text is likely to look like a placeholder.
+var commonDelims = [][2]string{
+	{"{{", "}}"},
+	{"${", "}"},
+	{"{%", "%}"},
+	{"<%", "%>"},
+	{"[[", "]]"},
+	{"$(", ")"},
+}
+
+// dangerousDelims returns why rendering with open and close takes values from
+// the environment recklessly, or "" when it does not. With a single-character
+// or very common delimiter, any environment variable whose name happens to
+// sit between braces of a file is substituted, which can rewrite most files
+// of a tree with unrelated values.
+func dangerousDelims(open, close, mode string) string {
+	if mode != "env" && mode != "both" {
+		return ""
+	}
+	if len(open) < 2 || len(close) < 2 {
+		return fmt.Sprintf("single-character delimiters %q %q with -mode %s", open, close, mode)
+	}
+	for _, p := range commonDelims {
+		if open == p[0] && close == p[1] {
+			return fmt.Sprintf("common delimiters %q %q with -mode %s", open, close, mode)
+		}
+	}
+	return ""
+}
+
+// checkDelims refuses a run whose delimiters, or those of an -ext-delims
+// group, are dangerous unless -force is set.
+func checkDelims(cfg config) error {
+	if cfg.Force {
+		return nil
+	}
+	pairs := [][2]string{{cfg.OpenDelim, cfg.CloseDelim}}
+	for _, d := range cfg.ExtDelims {
+		pairs = append(pairs, d)
+	}
+	for _, p := range pairs {
+		if why := dangerousDelims(p[0], p[1], cfg.Mode); why != "" {
+			return fmt.Errorf("%s would substitute any environment variable named between them in every file; use -mode flag or longer delimiters, or -force if this is intended", why)
+		}
+	}
+	return nil
+}
+
+// checkPragmaDelims refuses txt when a `charmap delims` pragma in it switches
+// to dangerous delimiters, unless -force is set. checkDelims only sees the
+// delimiters of the command line.
+func checkPragmaDelims(cfg config, txt []byte) error {
+	if cfg.Force || !bytes.Contains(txt, []byte("charmap delims:")) {
+		return nil
+	}
+	for _, r := range splitDelimPragmas(string(txt)) {
+		if r.open == "" {
+			continue
+		}
+		if why := dangerousDelims(r.open, r.close, cfg.Mode); why != "" {
+			return fmt.Errorf("line %d: pragma switches to %s, which would substitute any environment variable named between them; use -mode flag or longer delimiters, or -force if this is intended", r.line-1, why)
+		}
+	}
+	return nil
+}
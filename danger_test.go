@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDangerousDelims(t *testing.T) {
+	tests := []struct {
+		open, close, mode string
+		dangerous         bool
+	}{
+		{"{", "}", "env", true},
+		{"<::", ":", "both", true},
+		{"{{", "}}", "env", true},
+		{"${", "}", "both", true},
+		{"{", "}", "flag", false},
+		{"<::", "::>", "env", false},
+		{"{{", "}}}", "env", false},
+	}
+	for _, tt := range tests {
+		if got := dangerousDelims(tt.open, tt.close, tt.mode) != ""; got != tt.dangerous {
+			t.Errorf("dangerousDelims(%q, %q, %q) = %v, want %v", tt.open, tt.close, tt.mode, got, tt.dangerous)
+		}
+	}
+}
+
+func TestProcessTree_DangerousDelims(t *testing.T) {
+	src := t.TempDir()
+	path := filepath.Join(src, "app.txt")
+	const in = "home: {HOME}\n"
+	if err := os.WriteFile(path, []byte(in), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.txt$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		ExtDelims:  extDelims{".txt": {"{", "}"}},
+		Mode:       "env",
+		TargetDir:  src,
+		Workers:    1,
+		KeyMap:     map[string]string{"HOME": "/root"},
+		FileFilter: ff,
+	}
+	_, err := processTree(cfg)
+	if err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Fatalf("got error %v, want a refusal naming -force", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != in {
+		t.Errorf("refused run rewrote the file: %q", got)
+	}
+
+	cfg.Force = true
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("forced run: %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "home: /root\n" {
+		t.Errorf("forced run: got %q", got)
+	}
+}
+
+func TestProcessTree_DangerousPragma(t *testing.T) {
+	src := t.TempDir()
+	path := filepath.Join(src, "app.txt")
+	const in = "a: <::A::>\n# charmap delims: { }\nhome: {HOME}\n"
+	if err := os.WriteFile(path, []byte(in), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.txt$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		Mode:       "env",
+		TargetDir:  src,
+		Workers:    1,
+		KeyMap:     map[string]string{"A": "1", "HOME": "/root"},
+		FileFilter: ff,
+	}
+	_, err := processTree(cfg)
+	if err == nil || !strings.Contains(err.Error(), "line 2: pragma") {
+		t.Fatalf("got error %v, want a refusal of the pragma on line 2", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != in {
+		t.Errorf("refused run rewrote the file: %q", got)
+	}
+
+	cfg.Force = true
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("forced run: %v", err)
+	}
+}
+
+func TestRenderCmd_DangerousDelims(t *testing.T) {
+	dir := t.TempDir()
+	path, out := filepath.Join(dir, "app.txt"), filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(path, []byte("home: {HOME}\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	cfg := config{OpenDelim: "{", CloseDelim: "}", Mode: "env", KeyMap: map[string]string{"HOME": "/root"}, RenderOut: out}
+	if err := renderCmd(cfg, []string{path}); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Fatalf("got error %v, want a refusal naming -force", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("refused render wrote %s", out)
+	}
+
+	cfg.OpenDelim, cfg.CloseDelim = "<::", "::>"
+	if err := os.WriteFile(path, []byte("# charmap delims: { }\nhome: {HOME}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := renderCmd(cfg, []string{path}); err == nil || !strings.Contains(err.Error(), "pragma") {
+		t.Fatalf("got error %v, want a refusal of the pragma", err)
+	}
+}
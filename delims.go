@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// extDelims maps lower-case file extensions, dot included, to the delimiter
+// pair placeholders use in files with that extension, see -ext-delims. Other
+// files use -open and -close.
+type extDelims map[string][2]string
+
+// parseExtDelims parses -ext-delims specs of the form ".md={{ }}".
+func parseExtDelims(specs []string) (extDelims, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	d := make(extDelims, len(specs))
+	for _, spec := range specs {
+		ext, pair, ok := strings.Cut(spec, "=")
+		fields := strings.Fields(pair)
+		if !ok || !strings.HasPrefix(ext, ".") || len(fields) != 2 {
+			return nil, fmt.Errorf("invalid -ext-delims %q, expected format \".EXT=OPEN CLOSE\"", spec)
+		}
+		d[strings.ToLower(ext)] = [2]string{fields[0], fields[1]}
+	}
+	return d, nil
+}
+
+// delims returns the delimiters of path: those of its extension in
+// c.ExtDelims, or -open and -close.
+func (c config) delims(path string) (open, close string) {
+	if d, ok := c.ExtDelims[strings.ToLower(filepath.Ext(path))]; ok {
+		return d[0], d[1]
+	}
+	return c.OpenDelim, c.CloseDelim
+}
+
+// compileExtDelims builds one replacer per -ext-delims group for t, once for
+// the whole run rather than per file.
+func (t *renderTarget) compileExtDelims(d extDelims) {
+	if len(d) == 0 {
+		return
+	}
+	t.extDelims = d
+	t.extReplacers = make(map[string]countingReplacer, len(d))
+	for ext, pair := range d {
+		t.extReplacers[ext] = buildCountingReplacer([]byte(pair[0]), []byte(pair[1]), t.keyMap, t.opts)
+	}
+}
+
+// forPath returns t with the delimiters and replacer of the -ext-delims group
+// of path, or t itself when its extension has no group.
+func (t renderTarget) forPath(path string) renderTarget {
+	ext := strings.ToLower(filepath.Ext(path))
+	pair, ok := t.extDelims[ext]
+	if !ok {
+		return t
+	}
+	t.open, t.close = []byte(pair[0]), []byte(pair[1])
+	t.replacer = t.extReplacers[ext]
+	return t
+}
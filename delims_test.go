@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessTree_ExtDelims(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		"app.yaml":  "v: <::V::> {{V}}\n",
+		"README.md": "v: {{V}} <::V::>\n",
+		"NOTES.MD":  "v: {{ V | upper }}\n",
+	}
+	for name, txt := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	d, err := parseExtDelims([]string{".md={{ }}"})
+	if err != nil {
+		t.Fatalf("parseExtDelims: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`.*\.(ya?ml|md|MD)$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		Workers:    2,
+		KeyMap:     map[string]string{"V": "x"},
+		FileFilter: ff,
+		ExtDelims:  d,
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+
+	want := map[string]string{
+		"app.yaml":  "v: x {{V}}\n",
+		"README.md": "v: x <::V::>\n",
+		"NOTES.MD":  "v: X\n",
+	}
+	for name, w := range want {
+		if got, _ := os.ReadFile(filepath.Join(out, name)); string(got) != w {
+			t.Errorf("%s = %q, want %q", name, got, w)
+		}
+	}
+
+	if n, err := checkTree(cfg, new(bytes.Buffer)); err != nil || n != 0 {
+		t.Errorf("checkTree = %d, %v, want no unresolved placeholders", n, err)
+	}
+}
+
+func TestParseExtDelims(t *testing.T) {
+	for _, spec := range []string{"md={{ }}", ".md={{}}", ".md", ".md=a b c"} {
+		if _, err := parseExtDelims([]string{spec}); err == nil {
+			t.Errorf("%q: parsed, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// deviceID is not available on this platform; device checks are skipped.
+func deviceID(fi fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// isSparse cannot be determined on this platform.
+func isSparse(fi fs.FileInfo) bool {
+	return false
+}
+
+// hardLinkID is not available on this platform; every path is its own file.
+func hardLinkID(fi fs.FileInfo) ([2]uint64, bool) {
+	return [2]uint64{}, false
+}
+
+// advisoryLocked is not checked on this platform.
+func advisoryLocked(path string) bool {
+	return false
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines computes a minimal line edit script turning a into b using
+// Myers' O(ND) algorithm in its linear space variant: the middle snake of an
+// optimal path splits the problem in two, so memory stays proportional to the
+// input however far apart a and b are.
+func diffLines(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, max(len(a), len(b)))
+	if !shareLine(a, b) {
+		// Nothing in common, such as a file rewritten from scratch, is the
+		// slowest case for the search, which would only confirm it.
+		return replaceLines(ops, a, b)
+	}
+	return diffRange(ops, a, b)
+}
+
+// shareLine reports whether a and b have any line in common.
+func shareLine(a, b []string) bool {
+	seen := make(map[string]bool, len(a))
+	for _, l := range a {
+		seen[l] = true
+	}
+	for _, l := range b {
+		if seen[l] {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceLines appends the removal of a and the addition of b to ops.
+func replaceLines(ops []diffOp, a, b []string) []diffOp {
+	for _, l := range a {
+		ops = append(ops, diffOp{'-', l})
+	}
+	for _, l := range b {
+		ops = append(ops, diffOp{'+', l})
+	}
+	return ops
+}
+
+// diffRange appends the edit script turning a into b to ops.
+func diffRange(ops []diffOp, a, b []string) []diffOp {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		ops = append(ops, diffOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	common := 0
+	for common < len(a) && common < len(b) && a[len(a)-1-common] == b[len(b)-1-common] {
+		common++
+	}
+	suffix := a[len(a)-common:]
+	a, b = a[:len(a)-common], b[:len(b)-common]
+
+	x, y := 0, 0
+	if len(a) > 0 && len(b) > 0 {
+		x, y = middleSnake(a, b)
+	}
+	if (x == 0 && y == 0) || (x == len(a) && y == len(b)) {
+		// Nothing left in common: one side is empty, or no split helps.
+		ops = replaceLines(ops, a, b)
+	} else {
+		ops = diffRange(ops, a[:x], b[:y])
+		ops = diffRange(ops, a[x:], b[y:])
+	}
+	for _, l := range suffix {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+// middleSnake returns a point on an optimal edit path from a to b, found
+// where the search forward from the start meets the one backward from the
+// end. The backward search works on reversed indices: x elements of a and y
+// of b consumed from their ends.
+func middleSnake(a, b []string) (int, int) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	delta := n - m
+	odd := delta%2 != 0
+	offset := maxD + 1
+	vf := make([]int, 2*maxD+3)
+	vb := make([]int, 2*maxD+3)
+	for d := 0; d <= maxD; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[offset+k] = x
+			if kb := delta - k; odd && kb >= -(d-1) && kb <= d-1 && x+vb[offset+kb] >= n {
+				return x0, y0
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			vb[offset+k] = x
+			if kf := delta - k; !odd && kf >= -d && kf <= d && x+vf[offset+kf] >= n {
+				return n - x, m - y
+			}
+		}
+	}
+	return 0, 0
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// unifiedDiff renders the differences between a and b in unified diff format.
+// It returns an empty string when they are equal.
+func unifiedDiff(aName, bName string, a, b []byte) string {
+	if string(a) == string(b) {
+		return ""
+	}
+	ops := diffLines(splitLines(string(a)), splitLines(string(b)))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+
+	// aLine/bLine track the 1-based line numbers at ops[i].
+	aLine, bLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+
+		// Extend the hunk until diffContext*2 unchanged lines separate it from the next change.
+		start := max(i-diffContext, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > diffContext*2 {
+				end = min(end+diffContext, len(ops))
+				break
+			}
+			end = run
+		}
+
+		hunkA, hunkB := aLine-(i-start), bLine-(i-start)
+		var countA, countB int
+		var body strings.Builder
+		for _, op := range ops[start:end] {
+			body.WriteByte(op.kind)
+			body.WriteString(op.line)
+			body.WriteByte('\n')
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+		if countA == 0 {
+			hunkA--
+		}
+		if countB == 0 {
+			hunkB--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", hunkA, countA, hunkB, countB)
+		sb.WriteString(body.String())
+
+		for _, op := range ops[i:end] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		i = end
+	}
+	return sb.String()
+}
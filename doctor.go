@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// doctorSample is how many matching files doctor reads to check delimiters.
+const doctorSample = 20
+
+// doctorCmd checks that a run could succeed in this environment: the tools it
+// shells out to are on PATH, the output directories are writable and the
+// delimiters make sense for the files under -dir. Every check prints one line,
+// with a hint when it fails. Nothing is rendered or written.
+func doctorCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("doctor: unexpected arguments %v", args)
+	}
+	n, err := doctorTree(cfg, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%d check(s) failed", n)
+	}
+	return nil
+}
+
+// doctorTree writes the result of every check and returns how many failed.
+// Warnings do not count.
+func doctorTree(cfg config, w io.Writer) (int, error) {
+	var failed int
+	report := func(level, msg, hint string) {
+		if level == "FAIL" {
+			failed++
+		}
+		fmt.Fprintf(w, "%-5s %s\n", level, msg)
+		if hint != "" && level != "ok" {
+			fmt.Fprintf(w, "      %s\n", hint)
+		}
+	}
+
+	report("ok", fmt.Sprintf("%d key(s) from %d value source(s)", len(cfg.KeyMap), len(cfg.Sources)), "")
+
+	var tools []string
+	if cfg.SignSpec != "" {
+		tool, _, _ := strings.Cut(cfg.SignSpec, ":")
+		tools = append(tools, tool)
+	}
+	if cfg.ApplyCmd != "" && runtime.GOOS != "windows" {
+		tools = append(tools, "sh")
+	}
+	for _, tool := range tools {
+		if path, err := exec.LookPath(tool); err != nil {
+			report("FAIL", fmt.Sprintf("%s not found", tool), "install it or add its directory to PATH")
+		} else {
+			report("ok", fmt.Sprintf("%s found at %s", tool, path), "")
+		}
+	}
+
+	for _, t := range renderTargets(cfg) {
+		dir := t.outDir
+		if dir == "" {
+			dir = cfg.TargetDir
+		}
+		if t.applyCmd != "" && t.outDir == "" {
+			continue
+		}
+		if err := checkWritable(dir); err != nil {
+			report("FAIL", fmt.Sprintf("cannot write to %s: %v", dir, err), "fix its permissions or pick another -out")
+		} else {
+			report("ok", fmt.Sprintf("%s is writable", dir), "")
+		}
+	}
+
+	var sampled, withDelims int
+	errSampled := errors.New("sampled")
+	err := walkFiles(cfg, func(path string) error {
+		if sampled == doctorSample {
+			return errSampled
+		}
+		sampled++
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		open, close := cfg.delims(path)
+		opens, closes := strings.Count(string(in), open), strings.Count(string(in), close)
+		if opens > 0 {
+			withDelims++
+		}
+		if opens != closes {
+			report("warn", fmt.Sprintf("%s has %d %q but %d %q", path, opens, open, closes, close),
+				"the delimiters may clash with the file's own syntax; try other -open/-close, -ext-delims or -opaque")
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSampled) {
+		report("FAIL", fmt.Sprintf("cannot walk %s: %v", cfg.TargetDir, err), "see -allow-outside for symlinks and mounts leaving -dir")
+		return failed, nil
+	}
+	switch {
+	case sampled == 0:
+		report("FAIL", fmt.Sprintf("no file under %s matches -include/-ignore", cfg.TargetDir), "check -dir and the -include patterns")
+	case withDelims == 0:
+		report("warn", fmt.Sprintf("none of %d sampled file(s) contains %q", sampled, cfg.OpenDelim), "check -open and -close")
+	default:
+		report("ok", fmt.Sprintf("%d of %d sampled file(s) contain placeholders", withDelims, sampled), "")
+	}
+	return failed, nil
+}
+
+// checkWritable reports whether a file can be created in dir or, when dir does
+// not exist yet, in its nearest existing parent.
+func checkWritable(dir string) error {
+	for {
+		_, err := os.Stat(dir)
+		if err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if !errors.Is(err, fs.ErrNotExist) || parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".charmap-doctor-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
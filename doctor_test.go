@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDoctorTree(t *testing.T) {
+	src := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	write("ok.yaml", "v: <::V::>\n")
+	write("clash.yaml", "v: <::V::>\nw: <:: broken\n")
+
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     filepath.Join(t.TempDir(), "not", "yet"),
+		FileFilter: ff,
+		SignSpec:   "no-such-signing-tool:key",
+	}
+
+	var buf bytes.Buffer
+	n, err := doctorTree(cfg, &buf)
+	if err != nil {
+		t.Fatalf("doctorTree: %v", err)
+	}
+	out := buf.String()
+	if n != 1 || !strings.Contains(out, "FAIL  no-such-signing-tool not found") {
+		t.Errorf("got %d failure(s), want the missing tool only:\n%s", n, out)
+	}
+	for _, want := range []string{"not/yet is writable", `clash.yaml has 2 "<::" but 1 "::>"`, "2 of 2 sampled file(s)"} {
+		if !strings.Contains(filepath.ToSlash(out), want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if _, err := os.Stat(cfg.OutDir); err == nil {
+		t.Errorf("doctor created %s", cfg.OutDir)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// downwardEnv maps the environment variables pod specs conventionally fill
+// from the downward API, in the plain and the MY_ spelling of the Kubernetes
+// documentation, to the keys -source k8s-downward gives them.
+var downwardEnv = [][2]string{
+	{"POD_NAME", "pod.name"},
+	{"POD_NAMESPACE", "pod.namespace"},
+	{"POD_UID", "pod.uid"},
+	{"POD_IP", "pod.ip"},
+	{"POD_SERVICE_ACCOUNT", "pod.serviceAccount"},
+	{"NODE_NAME", "pod.nodeName"},
+	{"MY_POD_NAME", "pod.name"},
+	{"MY_POD_NAMESPACE", "pod.namespace"},
+	{"MY_POD_UID", "pod.uid"},
+	{"MY_POD_IP", "pod.ip"},
+	{"MY_POD_SERVICE_ACCOUNT", "pod.serviceAccount"},
+	{"MY_NODE_NAME", "pod.nodeName"},
+}
+
+// downwardFiles maps the files of a downward API volume that hold a single
+// field to their keys. labels and annotations are read separately.
+var downwardFiles = [][2]string{
+	{"name", "pod.name"},
+	{"namespace", "pod.namespace"},
+	{"uid", "pod.uid"},
+}
+
+// downwardValues reads the downward API of the pod charmap runs in: the
+// volume mounted at dir, whose labels and annotations files become
+// pod.labels.KEY and pod.annotations.KEY keys, and the conventional
+// environment variables. The volume wins over the environment. It fails when
+// neither is there, which means charmap does not run in a pod set up for it.
+func downwardValues(dir string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, f := range downwardFiles {
+		data, err := os.ReadFile(filepath.Join(dir, f[0]))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("-source k8s-downward: %w", err)
+		}
+		values[f[1]] = strings.TrimSpace(string(data))
+	}
+	for _, name := range []string{"labels", "annotations"} {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("-source k8s-downward: %w", err)
+		}
+		fields, err := parseDownwardMap(data)
+		if err != nil {
+			return nil, fmt.Errorf("-source k8s-downward: %s: %w", path, err)
+		}
+		for k, v := range fields {
+			values["pod."+name+"."+k] = v
+		}
+	}
+	for _, e := range downwardEnv {
+		if v, ok := os.LookupEnv(e[0]); ok {
+			if _, set := values[e[1]]; !set {
+				values[e[1]] = v
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("-source k8s-downward: no downward API volume at %s and none of POD_NAME, POD_NAMESPACE, POD_IP, NODE_NAME set", dir)
+	}
+	return values, nil
+}
+
+// parseDownwardMap parses the labels or annotations file of a downward API
+// volume: one key="value" line per entry, the value quoted like a Go string.
+func parseDownwardMap(data []byte) (map[string]string, error) {
+	fields := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		k, quoted, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key=\"value\"", n)
+		}
+		v, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		fields[k] = v
+	}
+	return fields, sc.Err()
+}
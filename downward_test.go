@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownwardValues(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"name":        "web-7d9f\n",
+		"labels":      "app=\"web\"\npod-template-hash=\"7d9f\"\n",
+		"annotations": "kubernetes.io/config.source=\"api\"\nnote=\"line one\\nline two\"\n",
+	}
+	for name, txt := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(txt), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("POD_NAME", "shadowed")
+	t.Setenv("MY_POD_NAMESPACE", "prod")
+	t.Setenv("NODE_NAME", "node-1")
+
+	values, err := downwardValues(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"pod.name":                     "web-7d9f",
+		"pod.namespace":                "prod",
+		"pod.nodeName":                 "node-1",
+		"pod.labels.app":               "web",
+		"pod.labels.pod-template-hash": "7d9f",
+		"pod.annotations.kubernetes.io/config.source": "api",
+		"pod.annotations.note":                        "line one\nline two",
+	}
+	for k, v := range want {
+		if values[k] != v {
+			t.Errorf("%s = %q, want %q", k, values[k], v)
+		}
+	}
+	if len(values) != len(want) {
+		t.Errorf("got %d keys, want %d: %v", len(values), len(want), values)
+	}
+}
+
+func TestDownwardValues_NotInPod(t *testing.T) {
+	for _, e := range downwardEnv {
+		t.Setenv(e[0], "")
+		os.Unsetenv(e[0])
+	}
+	if _, err := downwardValues(t.TempDir()); err == nil || !strings.Contains(err.Error(), "no downward API volume") {
+		t.Errorf("got error %v", err)
+	}
+	bad := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bad, "labels"), []byte("app=web\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := downwardValues(bad); err == nil || !strings.Contains(err.Error(), "labels: line 1") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// countReplaced counts, per key, the placeholders of in that rendering for t
+// substitutes: keys with a value or a default, source calls such as
+// env("HOME") under the call, and with -missing-placeholder keys without a
+// value too.
+func countReplaced(in []byte, t renderTarget) (map[string]int, error) {
+	refs, err := scanPlaceholders(string(in), string(t.open), string(t.close), t.keyMap, t.opts)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, ref := range refs {
+		if ref.Source != nil {
+			counts[ref.Source.String()]++
+			continue
+		}
+		_, set := t.keyMap[ref.Key]
+		_, marked := t.opts.missingMarker(ref.Key)
+		if set || ref.HasDefault || marked {
+			counts[ref.Key]++
+		}
+	}
+	return counts, nil
+}
+
+// writeDryRunReport writes one line per result of a -dry-run: the file, its
+// destination, whether it would change and how many placeholders of each key
+// would be replaced.
+func writeDryRunReport(w io.Writer, results []fileResult) error {
+	sorted := append([]fileResult(nil), results...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Path != sorted[j].Path {
+			return sorted[i].Path < sorted[j].Path
+		}
+		return sorted[i].Profile < sorted[j].Profile
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tDEST\tRESULT\tREPLACEMENTS")
+	changed := 0
+	for _, r := range sorted {
+		dest := r.Dest
+		if dest == "" || dest == r.Path {
+			dest = "-"
+		}
+		if r.Profile != "" {
+			dest += " (" + r.Profile + ")"
+		}
+		result := "unchanged"
+		if r.Changed {
+			result = "would change"
+			changed++
+		}
+		keys := make([]string, 0, len(r.Replaced))
+		for k := range r.Replaced {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			keys[i] = fmt.Sprintf("%s×%d", k, r.Replaced[k])
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Path, dest, result, strings.Join(keys, " "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d of %d file(s) would change, nothing was written\n", changed, len(sorted))
+	return err
+}
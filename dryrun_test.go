@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessTree_DryRun(t *testing.T) {
+	src := t.TempDir()
+	manifest := filepath.Join(t.TempDir(), "run.json")
+	files := map[string]string{
+		"app.yaml":  "host: <::HOST::>\nurl: https://<::HOST::>:<::PORT | default \"443\"::>/\n",
+		"done.yaml": "host: example.com\n",
+	}
+	for name, txt := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:    "<::",
+		CloseDelim:   "::>",
+		TargetDir:    src,
+		Workers:      2,
+		KeyMap:       map[string]string{"HOST": "example.com"},
+		FileFilter:   ff,
+		ManifestPath: manifest,
+		RunLock:      "fail",
+		DryRun:       true,
+	}
+	results, err := processTree(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, txt := range files {
+		if got, _ := os.ReadFile(filepath.Join(src, name)); string(got) != txt {
+			t.Errorf("%s was rewritten: %q", name, got)
+		}
+	}
+	for _, p := range []string{manifest, filepath.Join(src, runLockName)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("dry run wrote %s", p)
+		}
+	}
+
+	var sb strings.Builder
+	if err := writeDryRunReport(&sb, results); err != nil {
+		t.Fatal(err)
+	}
+	app, done := filepath.Join(src, "app.yaml"), filepath.Join(src, "done.yaml")
+	for _, want := range []string{
+		app + "   -     would change  HOST×2 PORT×1\n",
+		done + "  -     unchanged     \n",
+		"1 of 2 file(s) would change, nothing was written\n",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, sb.String())
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// editorLockFiles returns, for the base name of a file, the lock files
+// editors keep next to it while it is open, with the editor's name. Only
+// names editors are known to use are listed: a plain base+".lock" is as often
+// a package manager's lockfile (Gemfile.lock, package.lock) as an editor's.
+func editorLockFiles(base string) [][2]string {
+	return [][2]string{
+		{"." + base + ".swp", "vim or nano"},
+		{"." + base + ".swo", "vim"},
+		{".#" + base, "emacs"},
+		{".~lock." + base + "#", "LibreOffice"},
+		{"." + base + ".kate-swp", "kate"},
+		{"~$" + base, "Microsoft Office"},
+	}
+}
+
+// editorLock returns what holds path open for editing: a lock file next to
+// it, or an advisory lock on the file itself. It returns "" when path is
+// free.
+func editorLock(path string) string {
+	dir, base := filepath.Split(path)
+	for _, lf := range editorLockFiles(base) {
+		// Emacs locks are dangling symlinks, so they are not followed.
+		if _, err := os.Lstat(longPath(filepath.Join(dir, lf[0]))); err == nil {
+			return fmt.Sprintf("%s (%s)", lf[1], lf[0])
+		}
+	}
+	if advisoryLocked(path) {
+		return "an advisory lock"
+	}
+	return ""
+}
+
+// skipLocked applies the -editor-locks policy of t to dest, the file about to
+// be written. It reports whether the write must be skipped.
+func (t renderTarget) skipLocked(dest string) (bool, error) {
+	if t.editorLocks == "ignore" {
+		return false, nil
+	}
+	lock := editorLock(dest)
+	if lock == "" {
+		return false, nil
+	}
+	if t.editorLocks == "fail" {
+		return false, fmt.Errorf("%q is locked by %s, not overwriting it", dest, lock)
+	}
+	// Printed rather than logged: without -log a skipped file would otherwise
+	// leave no trace of why it was not updated.
+	fmt.Fprintf(os.Stderr, "WARNING: skipping %s, it is locked by %s\n", dest, lock)
+	return true, nil
+}
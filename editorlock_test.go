@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessTree_EditorLocks(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{"open.yaml": "v: <::V::>\n", "free.yaml": "v: <::V::>\n"}
+	for name, txt := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, ".open.yaml.swp"), []byte("vim"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:   "<::",
+		CloseDelim:  "::>",
+		TargetDir:   src,
+		Workers:     1,
+		KeyMap:      map[string]string{"V": "1"},
+		FileFilter:  ff,
+		EditorLocks: "fail",
+	}
+
+	_, err := processTree(cfg)
+	if err == nil || !strings.Contains(err.Error(), "locked by vim") {
+		t.Errorf("fail: got %v, want a lock error", err)
+	}
+
+	cfg.EditorLocks = "skip"
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("skip: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(src, "open.yaml")); string(got) != files["open.yaml"] {
+		t.Errorf("open.yaml = %q, want it untouched", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(src, "free.yaml")); string(got) != "v: 1\n" {
+		t.Errorf("free.yaml = %q, want it rendered", got)
+	}
+
+	cfg.EditorLocks = "ignore"
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("ignore: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(src, "open.yaml")); string(got) != "v: 1\n" {
+		t.Errorf("open.yaml = %q, want it rendered despite the lock", got)
+	}
+}
+
+func TestEditorLock_Patterns(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Gemfile", "Gemfile.lock", "package", "package.lock", "app.yaml", ".app.yaml.kate-swp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"Gemfile", "package"} {
+		if lock := editorLock(filepath.Join(dir, name)); lock != "" {
+			t.Errorf("%s: locked by %s, want a package lockfile not to count", name, lock)
+		}
+	}
+	if lock := editorLock(filepath.Join(dir, "app.yaml")); !strings.Contains(lock, "kate") {
+		t.Errorf("app.yaml: lock = %q, want kate", lock)
+	}
+}
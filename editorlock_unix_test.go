@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestEditorLock_Advisory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	if err := os.WriteFile(path, []byte("v: 1\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	if lock := editorLock(path); lock != "" {
+		t.Fatalf("unlocked file reported as locked by %s", lock)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Skipf("flock: %v", err)
+	}
+	if lock := editorLock(path); lock != "an advisory lock" {
+		t.Errorf("editorLock = %q, want an advisory lock", lock)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"unicode/utf8"
+)
+
+// Templates are handled as bytes throughout: text outside placeholders is
+// copied unchanged whatever its encoding, and only substituted values and
+// filters see strings. The -invalid-utf8 policy decides what happens to a
+// file that is not valid UTF-8 before any of that.
+
+// invalidUTF8At returns the line, column and value of the first byte of data
+// that is not part of a valid UTF-8 sequence, or ok false when there is none.
+func invalidUTF8At(data []byte) (line, col int, b byte, ok bool) {
+	if utf8.Valid(data) {
+		return 0, 0, 0, false
+	}
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			line = bytes.Count(data[:i], []byte("\n")) + 1
+			col = i - bytes.LastIndexByte(data[:i], '\n')
+			return line, col, data[i], true
+		}
+		i += size
+	}
+	return 0, 0, 0, false
+}
+
+// decode applies the -invalid-utf8 policy to data, the contents of path:
+// pass returns it byte for byte, replace substitutes U+FFFD for every invalid
+// sequence and fail returns an error locating the first one. Files the skip
+// policy leaves alone never get here, see skipContent.
+func (c config) decode(path string, data []byte) ([]byte, error) {
+	if c.InvalidUTF8 == "" || c.InvalidUTF8 == "pass" || c.InvalidUTF8 == "skip" {
+		return data, nil
+	}
+	line, col, b, ok := invalidUTF8At(data)
+	if !ok {
+		return data, nil
+	}
+	if c.InvalidUTF8 == "fail" {
+		return nil, fmt.Errorf("%s:%d:%d: invalid UTF-8 byte 0x%02x", path, line, col, b)
+	}
+	slog.Warn("replacing invalid UTF-8", slog.String("path", path), slog.Int("line", line), slog.Int("column", col))
+	return bytes.ToValidUTF8(data, []byte("�")), nil
+}
+
+// skipContent reports whether a run leaves data alone: the content filters
+// skip it or, with -invalid-utf8 skip, it is not valid UTF-8.
+func (c config) skipContent(data []byte) bool {
+	if c.InvalidUTF8 == "skip" && !utf8.Valid(data) {
+		return true
+	}
+	return c.FileFilter.skipContent(data)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessTree_InvalidUTF8(t *testing.T) {
+	// Latin-1 text and a stray continuation byte around a placeholder.
+	in := []byte("caf\xe9: <::V::>\n\x80 <::V::> \xff\xfe\n")
+	tests := []struct {
+		policy, want, err string
+	}{
+		{"pass", "caf\xe9: é\n\x80 é \xff\xfe\n", ""},
+		{"replace", "caf�: é\n� é �\n", ""},
+		{"skip", string(in), ""},
+		{"fail", string(in), ":1:4: invalid UTF-8 byte 0xe9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			src := t.TempDir()
+			path := filepath.Join(src, "app.yaml")
+			if err := os.WriteFile(path, in, 0o644); err != nil {
+				t.Fatalf("write temp file: %v", err)
+			}
+			ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+			cfg := config{
+				OpenDelim:   "<::",
+				CloseDelim:  "::>",
+				TargetDir:   src,
+				Workers:     1,
+				KeyMap:      map[string]string{"V": "é"},
+				FileFilter:  ff,
+				InvalidUTF8: tt.policy,
+			}
+			_, err := processTree(cfg)
+			if tt.err == "" && err != nil {
+				t.Fatalf("processTree: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			if got, _ := os.ReadFile(path); !bytes.Equal(got, []byte(tt.want)) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
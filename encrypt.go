@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// encrypter encrypts rendered output before it is written in -out mode. The
+// extension is appended to every output file name.
+type encrypter struct {
+	ext     string
+	encrypt func(plain []byte) ([]byte, error)
+}
+
+// newEncrypter parses an -encrypt spec: age:RECIPIENT[,RECIPIENT...] or
+// gpg:KEY-ID. age is built in; gpg runs the gpg binary found on PATH.
+func newEncrypter(spec string) (*encrypter, error) {
+	scheme, arg, ok := strings.Cut(spec, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("invalid -encrypt %q, expected age:RECIPIENT or gpg:KEY-ID", spec)
+	}
+
+	switch scheme {
+	case "age":
+		var recipients []age.Recipient
+		for _, r := range strings.Split(arg, ",") {
+			rcpt, err := age.ParseX25519Recipient(strings.TrimSpace(r))
+			if err != nil {
+				return nil, fmt.Errorf("invalid age recipient %q: %w", r, err)
+			}
+			recipients = append(recipients, rcpt)
+		}
+		return &encrypter{ext: ".age", encrypt: func(plain []byte) ([]byte, error) {
+			var buf bytes.Buffer
+			w, err := age.Encrypt(&buf, recipients...)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := w.Write(plain); err != nil {
+				return nil, err
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}}, nil
+	case "gpg":
+		if _, err := exec.LookPath("gpg"); err != nil {
+			return nil, fmt.Errorf("-encrypt gpg: %w", err)
+		}
+		return &encrypter{ext: ".gpg", encrypt: func(plain []byte) ([]byte, error) {
+			var stdout, stderr bytes.Buffer
+			cmd := exec.Command("gpg", "--batch", "--yes", "--trust-model", "always",
+				"--encrypt", "--recipient", arg, "--output", "-")
+			cmd.Stdin = bytes.NewReader(plain)
+			cmd.Stdout, cmd.Stderr = &stdout, &stderr
+			if err := cmd.Run(); err != nil {
+				return nil, fmt.Errorf("gpg: %w: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			return stdout.Bytes(), nil
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unknown -encrypt scheme %q, must be one of: age, gpg", scheme)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestProcessFiles_EncryptAge(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	enc, err := newEncrypter("age:" + id.Recipient().String())
+	if err != nil {
+		t.Fatalf("newEncrypter: %v", err)
+	}
+
+	src, out := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "secret.yaml"), []byte("password: <::PASSWORD::>\n"), 0o600); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		Workers:    1,
+		KeyMap:     map[string]string{"PASSWORD": "hunter2"},
+		FileFilter: ff,
+		Encrypter:  enc,
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(out, "secret.yaml")); !os.IsNotExist(err) {
+		t.Errorf("plaintext output written")
+	}
+	ciphertext, err := os.ReadFile(filepath.Join(out, "secret.yaml.age"))
+	if err != nil {
+		t.Fatalf("read encrypted output: %v", err)
+	}
+	if bytes.Contains(ciphertext, []byte("hunter2")) {
+		t.Errorf("encrypted output contains plaintext value")
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), id)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	plain, _ := io.ReadAll(r)
+	if want := "password: hunter2\n"; string(plain) != want {
+		t.Errorf("decrypted %q, want %q", plain, want)
+	}
+}
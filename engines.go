@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// engine substitutes every plain placeholder whose key is in values and leaves
+// any other placeholder in place for the filter pass, which resolves pipelines
+// and reports missing keys.
+type engine func(txt, open, close string, values map[string]string) string
+
+// engines are the interchangeable substitution implementations. They produce
+// the same output and differ only in speed for a given key count and file size.
+var engines = map[string]engine{
+	"strings":    stringsEngine,
+	"loop":       loopEngine,
+	"regex":      regexEngine,
+	"replaceall": replaceAllEngine,
+	"aho":        ahoEngine,
+}
+
+const defaultEngine = "strings"
+
+// resolveEngine validates -engine. auto picks the engine of the last bench
+// run, and its worker count unless -workers was given, falling back to
+// defaultEngine when there is no bench result.
+func resolveEngine(name string, workers int) (string, int, error) {
+	if name != "auto" {
+		if engines[name] == nil {
+			return "", 0, fmt.Errorf("invalid -engine %q, must be strings, loop, regex, replaceall, aho or auto", name)
+		}
+		return name, workers, nil
+	}
+
+	res, err := loadBenchResult()
+	if err != nil || engines[res.Engine] == nil {
+		slog.Debug("no usable bench result, using the default engine", slog.Any("error", err))
+		return defaultEngine, workers, nil
+	}
+	workersSet := false
+	flag.Visit(func(f *flag.Flag) { workersSet = workersSet || f.Name == "workers" })
+	if !workersSet && res.Workers > 0 {
+		workers = res.Workers
+	}
+	return res.Engine, workers, nil
+}
+
+// stringsEngine builds a strings.Replacer over the keys the text uses,
+// cached across files.
+func stringsEngine(txt, open, close string, values map[string]string) string {
+	keys := usedKeys(txt, open, close, values)
+	if len(keys) == 0 {
+		return txt
+	}
+	// Writing into a presized builder avoids Replace's repeated growth.
+	var sb strings.Builder
+	sb.Grow(len(txt) + len(txt)/8)
+	cachedReplacer(open, close, keys, values).WriteString(&sb, txt)
+	return sb.String()
+}
+
+// loopEngine walks the text once, looking each placeholder up as it goes.
+func loopEngine(txt, open, close string, values map[string]string) string {
+	var sb strings.Builder
+	sb.Grow(len(txt))
+	for {
+		idx := strings.Index(txt, open)
+		if idx == -1 {
+			break
+		}
+		start := idx + len(open)
+		end := strings.Index(txt[start:], close)
+		if end == -1 {
+			break
+		}
+		v, ok := values[txt[start:start+end]]
+		if !ok {
+			// A placeholder may still start after or inside open.
+			sb.WriteString(txt[:idx+1])
+			txt = txt[idx+1:]
+			continue
+		}
+		sb.WriteString(txt[:idx])
+		sb.WriteString(v)
+		txt = txt[start+end+len(close):]
+	}
+	sb.WriteString(txt)
+	return sb.String()
+}
+
+var ahoCache keySetCache[*ahoCompiled]
+
+var placeholderRegexps sync.Map // [2]string{open, close} -> *regexp.Regexp
+
+// regexEngine matches open(.*?)close with a regexp compiled once per pair of
+// delimiters.
+func regexEngine(txt, open, close string, values map[string]string) string {
+	if !utf8.ValidString(open) || !utf8.ValidString(close) {
+		// Patterns must be valid UTF-8.
+		return loopEngine(txt, open, close, values)
+	}
+	re, ok := placeholderRegexps.Load([2]string{open, close})
+	if !ok {
+		re, _ = placeholderRegexps.LoadOrStore([2]string{open, close},
+			regexp.MustCompile(`(?s)`+regexp.QuoteMeta(open)+`(.*?)`+regexp.QuoteMeta(close)))
+	}
+	return re.(*regexp.Regexp).ReplaceAllStringFunc(txt, func(m string) string {
+		// The match starts at the leftmost open, so in "<::<::KEY::>" the
+		// placeholder follows the last open inside it.
+		body := m[:len(m)-len(close)]
+		i := strings.LastIndex(body, open)
+		if v, ok := values[body[i+len(open):]]; ok {
+			return m[:i] + v
+		}
+		return m
+	})
+}
+
+// replaceAllEngine looks for every key of values in the text and replaces
+// the ones present in a single pass, with a strings.Replacer built for the
+// call. Replacing key by key would substitute placeholders inside values
+// already inserted, depending on map order.
+func replaceAllEngine(txt, open, close string, values map[string]string) string {
+	var pairs []string
+	for _, k := range slices.Sorted(maps.Keys(values)) {
+		if token := open + k + close; strings.Contains(txt, token) {
+			pairs = append(pairs, token, values[k])
+		}
+	}
+	if len(pairs) == 0 {
+		return txt
+	}
+	return strings.NewReplacer(pairs...).Replace(txt)
+}
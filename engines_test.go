@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestEngines_SameOutput(t *testing.T) {
+	values := map[string]string{"A": "alpha", "B": "beta"}
+	const in = "<::A::> <::<::B::> <::MISSING::> <::A | upper::> <::B::><::A::> <::"
+	const want = "alpha <::beta <::MISSING::> <::A | upper::> betaalpha <::"
+	for name, eng := range engines {
+		if got := eng(in, "<::", "::>", values); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestEngines_SinglePass(t *testing.T) {
+	// A value holding a placeholder is inserted as is, never rendered again.
+	values := map[string]string{"A": "<::B::>", "B": "x", "C": "<::A::>"}
+	const want = "<::B::> x <::A::>"
+	for i := 0; i < 10; i++ {
+		for name, eng := range engines {
+			if got := eng("<::A::> <::B::> <::C::>", "<::", "::>", values); got != want {
+				t.Fatalf("%s: got %q, want %q", name, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// errorStream writes one JSON object per error as soon as it occurs, see
+// -errors-format ndjson. A nil stream discards everything.
+type errorStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// errorRecord is one line of the stream. Line, Column and Key are set when
+// the error concerns a placeholder.
+type errorRecord struct {
+	Time   string `json:"time"`
+	Path   string `json:"path,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Error  string `json:"error"`
+}
+
+func newErrorStream(w io.Writer) *errorStream {
+	return &errorStream{enc: json.NewEncoder(w)}
+}
+
+// lazyFile appends to the file at path, opening it on the first write, so
+// that -errors-file is only created by a run that has errors to report.
+// Writes are serialized by the errorStream.
+type lazyFile struct {
+	path string
+	f    *os.File
+}
+
+func (l *lazyFile) Write(p []byte) (int, error) {
+	if l.f == nil {
+		f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open errors file %q: %w", l.path, err)
+		}
+		l.f = f
+	}
+	return l.f.Write(p)
+}
+
+// Close closes the file if it was opened.
+func (l *lazyFile) Close() error {
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// report writes err, which concerns path, splitting joined errors such as
+// the ones of several profiles into one record each.
+func (s *errorStream) report(path string, err error) {
+	if s == nil || err == nil {
+		return
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			s.report(path, e)
+		}
+		return
+	}
+
+	rec := errorRecord{Time: time.Now().UTC().Format(time.RFC3339Nano), Path: path, Error: err.Error()}
+	var pe *placeholderError
+	if errors.As(err, &pe) {
+		rec.Line, rec.Column = pe.line, pe.col
+		if pe.file != "" {
+			rec.Path = pe.file
+		}
+	}
+	var mk *missingKeyError
+	if errors.As(err, &mk) {
+		rec.Key = mk.key
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(rec); err != nil {
+		slog.Warn("cannot write error stream", slog.Any("error", err))
+	}
+}
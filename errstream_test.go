@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorStream(t *testing.T) {
+	src := t.TempDir()
+	for name, data := range map[string]string{
+		"ok.yaml":  "v: <::V::>\n",
+		"bad.yaml": "v: <::V::>\nw: <::MISSING::>\n",
+	} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     t.TempDir(),
+		Workers:    2,
+		KeyMap:     map[string]string{"V": "1"},
+		FileFilter: ff,
+		Errors:     newErrorStream(&buf),
+	}
+	if _, err := processTree(cfg); err == nil {
+		t.Fatal("processTree succeeded with a missing key")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d record(s), want 1:\n%s", len(lines), buf.String())
+	}
+	var rec errorRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("record %q: %v", lines[0], err)
+	}
+	if filepath.Base(rec.Path) != "bad.yaml" || rec.Line != 2 || rec.Column != 4 || rec.Key != "MISSING" || rec.Time == "" {
+		t.Errorf("record = %+v, want bad.yaml line 2 column 4 key MISSING", rec)
+	}
+}
+
+func TestLazyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.ndjson")
+	f := &lazyFile{path: path}
+	newErrorStream(f).report("a.yaml", nil)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("errors file created without errors: %v", err)
+	}
+
+	f = &lazyFile{path: path}
+	newErrorStream(f).report("a.yaml", errors.New("boom"))
+	f.Close()
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), `"error":"boom"`) {
+		t.Errorf("errors file holds %q, %v", data, err)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// filterFunc transforms a placeholder value. args are the literal arguments
+// written after the filter name, e.g. `<::KEY | pad 8 "0"::>` passes ["8", "0"].
+type filterFunc func(val string, args []string) (string, error)
+
+type filterMap map[string]filterFunc
+
+type filterCall struct {
+	name string
+	args []string
+}
+
+// pipeline is a parsed placeholder expression: a key followed by zero or more
+// filters separated by '|'.
+type pipeline struct {
+	key string
+	// source is set when the key is a function call, see sourceCall.
+	source *sourceCall
+	calls  []filterCall
+	// indent is the whitespace equivalent of the text before the placeholder
+	// on its line, used by autoindent.
+	indent string
+}
+
+func parsePipeline(expr string) (pipeline, error) {
+	var src *sourceCall
+	if c, rest, ok := cutCall(expr); ok {
+		src, expr = &c, rest
+	}
+	fields, err := splitFields(expr)
+	if err != nil {
+		return pipeline{}, err
+	}
+
+	segments := [][]string{{}}
+	for _, f := range fields {
+		if f == "|" {
+			segments = append(segments, []string{})
+			continue
+		}
+		segments[len(segments)-1] = append(segments[len(segments)-1], f)
+	}
+
+	if src != nil && len(segments[0]) == 0 {
+		segments[0] = []string{src.String()}
+	}
+	if len(segments[0]) != 1 {
+		return pipeline{}, fmt.Errorf("expected a single key before the first '|' in %q", expr)
+	}
+	p := pipeline{key: segments[0][0], source: src}
+	for _, seg := range segments[1:] {
+		if len(seg) == 0 {
+			return pipeline{}, fmt.Errorf("empty filter in %q", expr)
+		}
+		p.calls = append(p.calls, filterCall{name: seg[0], args: seg[1:]})
+	}
+	return p, nil
+}
+
+// splitFields tokenizes a placeholder expression on whitespace, keeping
+// double-quoted strings intact and emitting '|' as its own field.
+func splitFields(s string) ([]string, error) {
+	var fields []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '|':
+			fields = append(fields, "|")
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string in %q", s)
+			}
+			unq, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s: %w", s[i:end+1], err)
+			}
+			fields = append(fields, unq)
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t|\"", rune(s[end])) {
+				end++
+			}
+			fields = append(fields, s[i:end])
+			i = end
+		}
+	}
+	return fields, nil
+}
+
+func (p pipeline) eval(values map[string]string, filters filterMap) (string, error) {
+	val, ok := values[p.key]
+	if !ok && (len(p.calls) == 0 || p.calls[0].name != "default") {
+		return "", &missingKeyError{key: p.key}
+	}
+
+	// split turns the value into a list; filters applied to a list map over its
+	// elements until join turns it back into a string.
+	var list []string
+	for _, c := range p.calls {
+		var err error
+		switch c.name {
+		case "split":
+			if len(c.args) != 1 {
+				return "", fmt.Errorf("filter %q on %q: expected 1 argument, got %d", c.name, p.key, len(c.args))
+			}
+			list = strings.Split(val, c.args[0])
+			continue
+		case "join":
+			if len(c.args) != 1 {
+				return "", fmt.Errorf("filter %q on %q: expected 1 argument, got %d", c.name, p.key, len(c.args))
+			}
+			if list == nil {
+				return "", fmt.Errorf("filter %q on %q: join without split", c.name, p.key)
+			}
+			val, list = strings.Join(list, c.args[0]), nil
+			continue
+		case "autoindent":
+			if len(c.args) != 0 {
+				return "", fmt.Errorf("filter %q on %q: expected 0 arguments, got %d", c.name, p.key, len(c.args))
+			}
+			if list != nil {
+				return "", fmt.Errorf("filter %q on %q: not applicable to a split value", c.name, p.key)
+			}
+			val = reindent(val, p.indent)
+			continue
+		case "raw":
+			if len(c.args) != 0 {
+				return "", fmt.Errorf("filter %q on %q: expected 0 arguments, got %d", c.name, p.key, len(c.args))
+			}
+			continue
+		}
+
+		fn, ok := filters[c.name]
+		if !ok {
+			fn, ok = builtinFilters[c.name]
+		}
+		if !ok {
+			return "", fmt.Errorf("unknown filter %q", c.name)
+		}
+		if list == nil {
+			val, err = fn(val, c.args)
+		} else {
+			for i := range list {
+				if list[i], err = fn(list[i], c.args); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("filter %q on %q: %w", c.name, p.key, err)
+		}
+	}
+	if list != nil {
+		return "", fmt.Errorf("%q: split without join", p.key)
+	}
+	return val, nil
+}
+
+// raw reports whether the pipeline ends in the raw filter, which writes the
+// value byte for byte: it is not escaped for -syntax, and like every filtered
+// value it is not scanned for placeholders again.
+func (p pipeline) raw() bool {
+	return len(p.calls) > 0 && p.calls[len(p.calls)-1].name == "raw"
+}
+
+// autoindents reports whether the pipeline calls the autoindent filter, and so
+// needs the indent of its placeholder.
+func (p pipeline) autoindents() bool {
+	for _, c := range p.calls {
+		if c.name == "autoindent" {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinFilters are always available; filters loaded with -filters take
+// precedence over a builtin of the same name. split, join, autoindent and raw
+// are handled by pipeline.eval itself.
+var builtinFilters = filterMap{
+	"default": withArgs(1, func(v string, a []string) (string, error) {
+		if v == "" {
+			return a[0], nil
+		}
+		return v, nil
+	}),
+	"add": withArgs(1, func(v string, a []string) (string, error) {
+		return arith(v, a[0], func(x, y int64) int64 { return x + y }, func(x, y float64) float64 { return x + y })
+	}),
+	"mul": withArgs(1, func(v string, a []string) (string, error) {
+		return arith(v, a[0], func(x, y int64) int64 { return x * y }, func(x, y float64) float64 { return x * y })
+	}),
+	"replace": withArgs(2, func(v string, a []string) (string, error) {
+		return strings.ReplaceAll(v, a[0], a[1]), nil
+	}),
+	"trimPrefix": withArgs(1, func(v string, a []string) (string, error) {
+		return strings.TrimPrefix(v, a[0]), nil
+	}),
+	"trimSuffix": withArgs(1, func(v string, a []string) (string, error) {
+		return strings.TrimSuffix(v, a[0]), nil
+	}),
+	"upper": withArgs(0, func(v string, _ []string) (string, error) {
+		return strings.ToUpper(v), nil
+	}),
+	"lower": withArgs(0, func(v string, _ []string) (string, error) {
+		return strings.ToLower(v), nil
+	}),
+	// printf follows text/template: the piped value is the last operand.
+	"printf": func(v string, a []string) (string, error) {
+		if len(a) == 0 {
+			return "", fmt.Errorf("expected a format argument")
+		}
+		operands := make([]any, 0, len(a))
+		for _, arg := range a[1:] {
+			operands = append(operands, arg)
+		}
+		return fmt.Sprintf(a[0], append(operands, v)...), nil
+	},
+}
+
+func withArgs(n int, fn filterFunc) filterFunc {
+	return func(v string, a []string) (string, error) {
+		if len(a) != n {
+			return "", fmt.Errorf("expected %d argument(s), got %d", n, len(a))
+		}
+		return fn(v, a)
+	}
+}
+
+// reindent prefixes every non-empty line of v after the first with indent, so
+// a multi-line value inserted at a column stays inside an indented YAML block.
+func reindent(v, indent string) string {
+	if indent == "" || !strings.Contains(v, "\n") {
+		return v
+	}
+	lines := strings.Split(v, "\n")
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimRight(lines[i], "\r") != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// columnIndent returns whitespace as wide as prefix, the text before a
+// placeholder on its line. Tabs are kept and every other character becomes a
+// space.
+func columnIndent(prefix string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, prefix)
+}
+
+// arith applies an integer operation when both operands are integers and a
+// floating point one otherwise.
+func arith(a, b string, intOp func(x, y int64) int64, floatOp func(x, y float64) float64) (string, error) {
+	x, errX := strconv.ParseInt(a, 10, 64)
+	y, errY := strconv.ParseInt(b, 10, 64)
+	if errX == nil && errY == nil {
+		return strconv.FormatInt(intOp(x, y), 10), nil
+	}
+
+	fx, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a number", a)
+	}
+	fy, err := strconv.ParseFloat(b, 64)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a number", b)
+	}
+	return strconv.FormatFloat(floatOp(fx, fy), 'f', -1, 64), nil
+}
+
+// expandPipelines evaluates every placeholder left in txt after plain key
+// substitution, function-call placeholders included. Other placeholders
+// without filters at this point are unresolved keys. escape, if not nil, is
+// applied to each result. It also returns how many placeholders it replaced.
+func expandPipelines(txt, open, close string, values map[string]string, opts replacerOptions, escape func(string) string) (string, int, error) {
+	idx := strings.Index(txt, open)
+	if idx == -1 {
+		return txt, 0, nil
+	}
+	n := 0
+	orig := txt
+	fail := func(expr string, err error) (string, int, error) {
+		ph := open + expr + close
+		return "", 0, &placeholderError{expr: ph, nth: strings.Count(orig[:len(orig)-len(txt)+idx], ph), err: err}
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(txt))
+	for idx != -1 {
+		start := idx + len(open)
+		end := strings.Index(txt[start:], close)
+		if end == -1 {
+			break
+		}
+		expr := txt[start : start+end]
+		if _, _, ok := cutCall(expr); !ok && !strings.Contains(expr, "|") && opts.MissingMarker == "" {
+			return fail(expr, &missingKeyError{key: expr})
+		}
+
+		p, err := parsePipeline(expr)
+		if err != nil {
+			return fail(expr, err)
+		}
+		if p.autoindents() {
+			line := txt[:idx]
+			if nl := strings.LastIndexByte(line, '\n'); nl != -1 {
+				line = line[nl+1:]
+			} else {
+				out := sb.String()
+				line = out[strings.LastIndexByte(out, '\n')+1:] + line
+			}
+			p.indent = columnIndent(line)
+		}
+		vals := values
+		if p.source != nil {
+			// A source without a value falls back to a leading default.
+			v, err := p.source.value(opts)
+			switch {
+			case err == nil:
+				vals = map[string]string{p.key: v}
+			case len(p.calls) > 0 && p.calls[0].name == "default":
+				vals = nil
+			default:
+				return fail(expr, err)
+			}
+		}
+		val, err := p.eval(vals, opts.Filters)
+		var mk *missingKeyError
+		if errors.As(err, &mk) {
+			if marker, ok := opts.missingMarker(mk.key); ok {
+				val, err = marker, nil
+			}
+		}
+		if err != nil {
+			return fail(expr, err)
+		}
+		if escape != nil && !p.raw() {
+			val = escape(val)
+		}
+
+		sb.WriteString(txt[:idx])
+		sb.WriteString(val)
+		txt = txt[start+end+len(close):]
+		idx = strings.Index(txt, open)
+		n++
+	}
+	sb.WriteString(txt)
+	return sb.String(), n, nil
+}
+
+// missingMarker returns the -missing-placeholder marker standing in for key,
+// or ok false when a key without a value is an error. Keys left out by
+// -allow-keys are always an error.
+func (o replacerOptions) missingMarker(key string) (string, bool) {
+	if o.MissingMarker == "" || o.Denied[key] {
+		return "", false
+	}
+	return strings.ReplaceAll(o.MissingMarker, "{{key}}", key), true
+}
+
+// loadStarlarkFilters executes each Starlark file and registers every
+// top-level function as a filter. Functions are called with the placeholder
+// value followed by the filter arguments, all as strings, and must return a
+// string.
+func loadStarlarkFilters(paths []string) (filterMap, error) {
+	filters := make(filterMap)
+	for _, path := range paths {
+		thread := &starlark.Thread{Name: path}
+		globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, path, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %q: %w", path, err)
+		}
+		globals.Freeze()
+
+		for name, v := range globals {
+			fn, ok := v.(*starlark.Function)
+			if !ok {
+				continue
+			}
+			filters[name] = starlarkFilter(fn)
+		}
+	}
+	return filters, nil
+}
+
+func starlarkFilter(fn *starlark.Function) filterFunc {
+	return func(val string, args []string) (string, error) {
+		callArgs := make(starlark.Tuple, 0, len(args)+1)
+		callArgs = append(callArgs, starlark.String(val))
+		for _, a := range args {
+			callArgs = append(callArgs, starlark.String(a))
+		}
+
+		// Threads are cheap and not safe for concurrent use, so each call gets its own.
+		thread := &starlark.Thread{Name: fn.Name()}
+		res, err := starlark.Call(thread, fn, callArgs, nil)
+		if err != nil {
+			return "", err
+		}
+		s, ok := starlark.AsString(res)
+		if !ok {
+			return "", fmt.Errorf("returned %s, want string", res.Type())
+		}
+		return s, nil
+	}
+}
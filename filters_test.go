@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStarlarkFilters(t *testing.T) {
+	star := filepath.Join(t.TempDir(), "filters.star")
+	const src = `
+def encode_id(v, prefix):
+    return prefix + "-" + v.upper()
+`
+	if err := os.WriteFile(star, []byte(src), 0o644); err != nil {
+		t.Fatalf("write filters: %v", err)
+	}
+
+	filters, err := loadStarlarkFilters([]string{star})
+	if err != nil {
+		t.Fatalf("loadStarlarkFilters: %v", err)
+	}
+
+	r := buildNewReplacer([]byte("<::"), []byte("::>"), map[string]string{"ID": "abc"}, replacerOptions{Filters: filters})
+	out, changed, err := r([]byte(`id: <::ID | encode_id "usr"::>, raw: <::ID::>`))
+	if err != nil {
+		t.Fatalf("replacer: %v", err)
+	}
+	if want := "id: usr-ABC, raw: abc"; string(out) != want || !changed {
+		t.Errorf("got %q (changed=%v), want %q", out, changed, want)
+	}
+
+	if _, _, err := r([]byte(`<::ID | nope::>`)); err == nil {
+		t.Errorf("expected error for unknown filter")
+	}
+}
+
+func TestBuiltinFilters(t *testing.T) {
+	values := map[string]string{"PORT": "8080", "REPLICAS": "2", "HOSTS": "a,b", "IMAGE": "app.tpl", "EMPTY": ""}
+	tests := map[string]string{
+		`<::PORT | add 1000::>`:                                      "9080",
+		`<::REPLICAS | mul 1.5::>`:                                   "3",
+		`<::UNSET | default "x"::>`:                                  "x",
+		`<::EMPTY | default "y"::>`:                                  "y",
+		`<::IMAGE | trimSuffix ".tpl" | replace "app" "web"::>`:      "web",
+		`<::HOSTS | split "," | printf "%s:%s" "http" | join ";"::>`: "http:a;http:b",
+		`<::HOSTS | trimPrefix "a," | upper::>`:                      "B",
+	}
+
+	r := buildNewReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{})
+	for in, want := range tests {
+		out, _, err := r([]byte(in))
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+			continue
+		}
+		if string(out) != want {
+			t.Errorf("%s = %q, want %q", in, out, want)
+		}
+	}
+
+	for _, bad := range []string{`<::PORT | add "x"::>`, `<::HOSTS | split ","::>`, `<::UNSET | upper::>`} {
+		if _, _, err := r([]byte(bad)); err == nil {
+			t.Errorf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestAutoindentFilter(t *testing.T) {
+	values := map[string]string{"CERT": "-----BEGIN-----\nabc\n\n-----END-----", "HOST": "example.com"}
+	r := buildNewReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{})
+
+	const in = `tls:
+  host: <::HOST::>
+  cert: |
+    <::CERT | autoindent::>
+  inline: <::CERT | autoindent::>
+`
+	const want = `tls:
+  host: example.com
+  cert: |
+    -----BEGIN-----
+    abc
+
+    -----END-----
+  inline: -----BEGIN-----
+          abc
+
+          -----END-----
+`
+	out, _, err := r([]byte(in))
+	if err != nil {
+		t.Fatalf("replacer: %v", err)
+	}
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestPipelineAutoindents(t *testing.T) {
+	tests := map[string]bool{
+		`CERT | autoindent`:                   true,
+		`CERT | trim | autoindent`:            true,
+		`AUTOINDENT`:                          false,
+		`CERT | default "autoindent"`:         false,
+		`file("autoindent.pem") | default ""`: false,
+	}
+	for expr, want := range tests {
+		p, err := parsePipeline(expr)
+		if err != nil {
+			t.Fatalf("parsePipeline(%q): %v", expr, err)
+		}
+		if got := p.autoindents(); got != want {
+			t.Errorf("%q: autoindents = %v, want %v", expr, got, want)
+		}
+	}
+}
+
+func TestRawFilter(t *testing.T) {
+	values := map[string]string{"BIN": "\x00<a&b>\xff"}
+	r := buildNewReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{Syntax: "xml"})
+
+	out, _, err := r([]byte("<v><::BIN::></v><w><::BIN | raw::></w>"))
+	if err != nil {
+		t.Fatalf("replacer: %v", err)
+	}
+	if want := "<v>\x00&lt;a&amp;b&gt;\xff</v><w>\x00<a&b>\xff</w>"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestMissingMarker(t *testing.T) {
+	values := map[string]string{"HOST": "example.com"}
+	opts := replacerOptions{MissingMarker: "TODO_SET_{{key}}", Denied: map[string]bool{"SECRET": true}}
+	r := buildNewReplacer([]byte("<::"), []byte("::>"), values, opts)
+
+	out, _, err := r([]byte("host: <::HOST::>\nport: <::PORT::>\nname: <::NAME | upper::>\nenv: <::ENV | default \"dev\"::>\n"))
+	if err != nil {
+		t.Fatalf("replacer: %v", err)
+	}
+	if want := "host: example.com\nport: TODO_SET_PORT\nname: TODO_SET_NAME\nenv: dev\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	// Keys -allow-keys leaves out are not papered over.
+	if _, _, err := r([]byte("<::SECRET::>")); err == nil {
+		t.Error("expected an error for a denied key")
+	}
+}
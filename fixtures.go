@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fixtureSpec describes the tree gen-fixtures writes, see -size, -keys,
+// -seed and -files.
+type fixtureSpec struct {
+	Size  int // bytes per file, approximately
+	Keys  int
+	Seed  int64
+	Files int
+}
+
+// genFixturesCmd writes a reproducible template tree and its values to -out,
+// to compare engines and worker counts with the bench command on any machine.
+func genFixturesCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("gen-fixtures: unexpected arguments %v", args)
+	}
+	if cfg.OutDir == "" {
+		return fmt.Errorf("gen-fixtures: -out must be set")
+	}
+	if err := genFixtures(cfg.OutDir, cfg.OpenDelim, cfg.CloseDelim, cfg.Fixtures); err != nil {
+		return err
+	}
+	fmt.Printf("%d file(s) of %d bytes with %d key(s) written to %s\n", cfg.Fixtures.Files, cfg.Fixtures.Size, cfg.Fixtures.Keys, cfg.OutDir)
+	fmt.Printf("bench them with: charmap bench -dir %s -mode flag -values %s\n", cfg.OutDir, filepath.Join(cfg.OutDir, "values.env"))
+	return nil
+}
+
+// genFixtures writes spec.Files templates named fixture-NNN.yaml and a
+// values.env holding every key to dir. The same spec always produces the
+// same bytes.
+func genFixtures(dir, open, close string, spec fixtureSpec) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	var values map[string]string
+	for i := 0; i < spec.Files; i++ {
+		var txt []byte
+		txt, values = genFixture(spec.Size, spec.Keys, spec.Seed+int64(i), open, close)
+		name := filepath.Join(dir, fmt.Sprintf("fixture-%03d.yaml", i))
+		if err := os.WriteFile(name, txt, 0o644); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var env strings.Builder
+	for _, k := range keys {
+		env.WriteString(k + "=" + values[k] + "\n")
+	}
+	return os.WriteFile(filepath.Join(dir, "values.env"), []byte(env.String()), 0o644)
+}
+
+// genFixture returns about size bytes of random words in which roughly one
+// token in twelve is a placeholder for one of keys keys K0..Kn, and the values
+// V0..Vn of those keys. The output depends only on the arguments.
+func genFixture(size, keys int, seed int64, open, close string) (txt []byte, vals map[string]string) {
+	if keys <= 0 {
+		panic("keys must be >0")
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	vals = make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		vals["K"+strconv.Itoa(i)] = "V" + strconv.Itoa(i)
+	}
+
+	var b bytes.Buffer
+	for b.Len() < size {
+		if rng.Float64() < 0.08 { // 8 % chance emit a placeholder token
+			b.WriteString(open)
+			b.WriteString("K" + strconv.Itoa(rng.Intn(keys)))
+			b.WriteString(close)
+		} else {
+			b.WriteString(randomWord(rng))
+		}
+		b.WriteByte(' ')
+	}
+	return b.Bytes(), vals
+}
+
+func randomWord(rng *rand.Rand) string {
+	n := rng.Intn(7) + 4
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		sb.WriteByte(byte('a' + rng.Intn(26)))
+	}
+	return sb.String()
+}
+
+// parseByteSize parses a byte count such as 4096, 64K, 16M or 1G, the
+// suffixes being powers of 1024.
+func parseByteSize(s string) (int, error) {
+	num, mult := s, 1
+	switch strings.ToUpper(s[len(s)-min(len(s), 1):]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		num = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, want a byte count such as 4096 or 64K", s)
+	}
+	return n * mult, nil
+}
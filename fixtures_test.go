@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenFixtures_Reproducible(t *testing.T) {
+	spec := fixtureSpec{Size: 4 << 10, Keys: 10, Seed: 7, Files: 3}
+	a, b := t.TempDir(), t.TempDir()
+	for _, dir := range []string{a, b} {
+		if err := genFixtures(dir, "<::", "::>", spec); err != nil {
+			t.Fatalf("genFixtures: %v", err)
+		}
+	}
+
+	names := []string{"fixture-000.yaml", "fixture-001.yaml", "fixture-002.yaml", "values.env"}
+	for _, name := range names {
+		x, err := os.ReadFile(filepath.Join(a, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		y, _ := os.ReadFile(filepath.Join(b, name))
+		if !bytes.Equal(x, y) {
+			t.Errorf("%s differs between runs with the same seed", name)
+		}
+		if name != "values.env" && (len(x) < spec.Size || !strings.Contains(string(x), "<::K")) {
+			t.Errorf("%s: %d bytes, want at least %d with placeholders", name, len(x), spec.Size)
+		}
+	}
+	if bytes.Equal(mustRead(t, filepath.Join(a, names[0])), mustRead(t, filepath.Join(a, names[1]))) {
+		t.Errorf("files of one run are identical, want one seed per file")
+	}
+	if env := mustRead(t, filepath.Join(a, "values.env")); !strings.HasPrefix(string(env), "K0=V0\nK1=V1\n") {
+		t.Errorf("values.env = %q", env)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseByteSize(t *testing.T) {
+	for in, want := range map[string]int{"4096": 4096, "64K": 64 << 10, "1m": 1 << 20, "2G": 2 << 30} {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "K", "-1K", "64KB", "x"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) succeeded, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// validDelims reports whether open and close are a usable pair for the fuzz
+// targets: non-empty, and neither contains the other.
+func validDelims(open, close string) bool {
+	return open != "" && close != "" && !strings.Contains(open, close) && !strings.Contains(close, open)
+}
+
+// FuzzEngines renders with two keys, the value of other being the
+// placeholder of key, so that an engine rendering values again or depending
+// on map order disagrees with the others.
+func FuzzEngines(f *testing.F) {
+	f.Add("<::", "::>", "KEY", "value", "a: <::KEY::>\nb: <::<::KEY::>::> <::REF::>\n", "REF")
+	f.Add("{{", "}}", "K", "{{K}}", "{{K}} {{ K }} {{K}}}} {{R}}{{K}}", "R")
+	f.Add("[[", "]]", "A", "\xff\xfe", "[[A]][[A]\xff[[ [[B]]", "B")
+	f.Add("<", ">", "X", "<X>", "<<X>> <Y><X>", "Y")
+	f.Fuzz(func(t *testing.T, open, close, key, value, txt, other string) {
+		if !validDelims(open, close) || key == "" || other == "" || key == other {
+			t.Skip()
+		}
+		for _, k := range []string{key, other} {
+			if strings.Contains(k, open) || strings.Contains(k, close) {
+				t.Skip()
+			}
+		}
+		values := map[string]string{key: value, other: open + key + close}
+		want := engines[defaultEngine](txt, open, close, values)
+		for name, eng := range engines {
+			if got := eng(txt, open, close, values); got != want {
+				t.Errorf("%s: got %q, %s: %q", name, got, defaultEngine, want)
+			}
+		}
+	})
+}
+
+func FuzzReplacer(f *testing.F) {
+	f.Add("<::", "::>", "KEY", "value", "a: <::KEY::>\nb: <::KEY | upper::>\n")
+	f.Add("{{", "}}", "K", "x\ny", "<::#if has(K)::>{{K}}<::#end::>\n")
+	f.Add("<::", "::>", "K", "<::K::>", "\xff<::K::>\xfe\n<::K | default \"d\"::>")
+	f.Fuzz(func(t *testing.T, open, close, key, value, txt string) {
+		if !validDelims(open, close) || key == "" {
+			t.Skip()
+		}
+		values := map[string]string{key: value}
+		r := buildNewReplacer([]byte(open), []byte(close), values, replacerOptions{})
+		out, changed, err := r([]byte(txt))
+		if err != nil {
+			return
+		}
+		if !strings.Contains(txt, open) && (string(out) != txt || changed) {
+			t.Errorf("text without %q changed: %q -> %q", open, txt, out)
+		}
+		if changed != (string(out) != txt) {
+			t.Errorf("changed = %v for %q -> %q", changed, txt, out)
+		}
+
+		// Rendering in chunks must not change the result.
+		chunked := buildNewReplacer([]byte(open), []byte(close), values, replacerOptions{ChunkSize: 8})
+		if cout, _, err := chunked([]byte(txt)); err != nil || string(cout) != string(out) {
+			t.Errorf("chunked: got %q, %v; whole: %q", cout, err, out)
+		}
+	})
+}
+
+func FuzzRenameKeyRoundTrip(f *testing.F) {
+	f.Add("a: <::OLD::> <::OLD | upper::>\n<::#if eq(OLD, \"x\")::>y<::#end::>\n", "OLD", "NEW")
+	f.Add("<::<::OLD::>::> <::OLDER::>", "OLD", "N")
+	f.Fuzz(func(t *testing.T, txt, old, new string) {
+		if old == "" || new == "" || strings.Contains(txt, new) || strings.Contains(old, new) || strings.Contains(new, old) {
+			t.Skip()
+		}
+		// Keys are identifiers; anything else would not survive parsing.
+		for _, k := range []string{old, new} {
+			for i := 0; i < len(k); i++ {
+				if !isIdentByte(k[i]) {
+					t.Skip()
+				}
+			}
+		}
+		renamed := renameKey(txt, "<::", "::>", old, new)
+		if back := renameKey(renamed, "<::", "::>", new, old); back != txt {
+			t.Errorf("%q -> %q -> %q", txt, renamed, back)
+		}
+	})
+}
@@ -1,3 +1,13 @@
 module github.com/ashtonian/charmap
 
 go 1.24.2
+
+require (
+	filippo.io/age v1.2.1
+	go.starlark.net v0.0.0-20250417143717-f57e51f710eb
+)
+
+require (
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// depGraph links every template to the keys it references and every key to
+// the sources its value comes from. Values are never included.
+type depGraph struct {
+	Templates map[string][]string `json:"templates"`
+	// Sources of each key, several with -profile; empty for unset keys.
+	Keys map[string][]string `json:"keys"`
+}
+
+// graphCmd writes the dependency graph of the templates under -dir to stdout,
+// in the -graph-format, to show which values feed which files.
+func graphCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("graph: unexpected arguments %v", args)
+	}
+	g, err := buildGraph(cfg)
+	if err != nil {
+		return err
+	}
+	switch cfg.GraphFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(g)
+	default:
+		return writeDOT(os.Stdout, g)
+	}
+}
+
+func buildGraph(cfg config) (depGraph, error) {
+	origins := []map[string]string{cfg.Origins}
+	for _, p := range cfg.Profiles {
+		origins = append(origins, p.Origins)
+	}
+
+	g := depGraph{Templates: make(map[string][]string), Keys: make(map[string][]string)}
+	err := walkFiles(cfg, func(path string) error {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if cfg.skipContent(in) {
+			return nil
+		}
+		keys, err := templateKeys(path, string(in), cfg, cfg.KeyMap)
+		if err != nil {
+			return fmt.Errorf("failed to scan %q: %w", path, err)
+		}
+		g.Templates[path] = keys
+		for _, k := range keys {
+			if _, ok := g.Keys[k]; ok {
+				continue
+			}
+			sources := []string{}
+			for _, o := range origins {
+				if src, ok := o[k]; ok && !slices.Contains(sources, src) {
+					sources = append(sources, src)
+				}
+			}
+			g.Keys[k] = sources
+		}
+		return nil
+	})
+	return g, err
+}
+
+// writeDOT writes g for Graphviz: templates as boxes on the left, keys in the
+// middle and sources on the right. Unset keys are dashed.
+func writeDOT(w io.Writer, g depGraph) error {
+	q := strconv.Quote
+	paths := make([]string, 0, len(g.Templates))
+	for p := range g.Templates {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	keys := make([]string, 0, len(g.Keys))
+	for k := range g.Keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(w, "digraph charmap {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	for _, p := range paths {
+		fmt.Fprintf(w, "  %s [shape=box, label=%s];\n", q("template:"+p), q(p))
+		for _, k := range g.Templates[p] {
+			fmt.Fprintf(w, "  %s -> %s;\n", q("template:"+p), q("key:"+k))
+		}
+	}
+	sources := make(map[string]bool)
+	for _, k := range keys {
+		style := ""
+		if len(g.Keys[k]) == 0 {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(w, "  %s [shape=ellipse, label=%s%s];\n", q("key:"+k), q(k), style)
+		for _, src := range g.Keys[k] {
+			sources[src] = true
+			fmt.Fprintf(w, "  %s -> %s;\n", q("key:"+k), q("source:"+src))
+		}
+	}
+	srcs := make([]string, 0, len(sources))
+	for s := range sources {
+		srcs = append(srcs, s)
+	}
+	sort.Strings(srcs)
+	for _, s := range srcs {
+		fmt.Fprintf(w, "  %s [shape=cylinder, label=%s];\n", q("source:"+s), q(s))
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
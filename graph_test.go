@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBuildGraph(t *testing.T) {
+	src := t.TempDir()
+	app := filepath.Join(src, "app.yaml")
+	tpl := "<::#if eq(ENV, \"prod\")::>\nhost: <::HOST::>\n<::#end::>\ntoken: <::TOKEN | b64enc::>\nx: <::UNSET::>\n"
+	if err := os.WriteFile(app, []byte(tpl), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		FileFilter: ff,
+		KeyMap:     map[string]string{"ENV": "prod", "HOST": "h", "TOKEN": "s3cret"},
+		Origins:    map[string]string{"ENV": "env", "HOST": "values:values.env", "TOKEN": "set"},
+	}
+	g, err := buildGraph(cfg)
+	if err != nil {
+		t.Fatalf("buildGraph: %v", err)
+	}
+	if want := []string{"HOST", "TOKEN", "UNSET", "ENV"}; !reflect.DeepEqual(g.Templates[app], want) {
+		t.Errorf("keys of app.yaml = %v, want %v", g.Templates[app], want)
+	}
+	if got := g.Keys["HOST"]; len(got) != 1 || got[0] != "values:values.env" {
+		t.Errorf("sources of HOST = %v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := writeDOT(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	for _, want := range []string{
+		strconv.Quote("template:"+app) + ` -> "key:TOKEN";`,
+		`"key:TOKEN" -> "source:set";`,
+		`"key:UNSET" [shape=ellipse, label="UNSET", style=dashed];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT lacks %s:\n%s", want, dot)
+		}
+	}
+	if strings.Contains(dot, "s3cret") {
+		t.Error("DOT contains a value")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// readHead reads the first n bytes of path from src for -head-bytes, cut back
+// to the last newline in them so that a placeholder on the boundary line is
+// not split. rest reads the remainder of the file; it is nil when the head is
+// the whole file.
+func readHead(src inputSource, path string, n int) (head []byte, rest func() ([]byte, error), err error) {
+	f, err := src.open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	// One extra byte tells a file of exactly n bytes from a longer one.
+	head = make([]byte, n+1)
+	m, err := io.ReadFull(f, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return head[:m], nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	head = head[:n]
+	if i := bytes.LastIndexByte(head, '\n'); i >= 0 {
+		head = head[:i+1]
+	}
+	off := int64(len(head))
+	rest = func() ([]byte, error) {
+		f, err := src.open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if s, ok := f.(io.Seeker); ok {
+			_, err = s.Seek(off, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, f, off)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(f)
+	}
+	return head, rest, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessTree_HeadBytes(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	body := strings.Repeat("payload <::V::>\n", 64)
+	files := map[string]string{
+		// The head line is cut back to its start, so its placeholder stays whole.
+		"head.txt":  "version: <::V::>\n" + body,
+		"plain.txt": "no placeholders\n" + body,
+		"short.txt": "v: <::V::>\n",
+	}
+	for name, txt := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.txt$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		Workers:    2,
+		KeyMap:     map[string]string{"V": "1.2"},
+		FileFilter: ff,
+		HeadBytes:  24,
+	}
+
+	// In place: only heads render, and files without a change are not written.
+	if _, err := processTree(cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"head.txt":  "version: 1.2\n" + body,
+		"plain.txt": files["plain.txt"],
+		"short.txt": "v: 1.2\n",
+	}
+	for name, w := range want {
+		if got, _ := os.ReadFile(filepath.Join(src, name)); string(got) != w {
+			t.Errorf("in place %s: got %q, want %q", name, got, w)
+		}
+	}
+
+	// To -out every file is written whole.
+	cfg.OutDir = out
+	if _, err := processTree(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for name, w := range want {
+		if got, _ := os.ReadFile(filepath.Join(out, name)); string(got) != w {
+			t.Errorf("-out %s: got %q, want %q", name, got, w)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// headerText starts the comment -header injects into rendered files.
+const headerText = "Generated by charmap"
+
+// commentSyntax maps file extensions to the line comment delimiters used for
+// the -header comment. Formats without comments, such as JSON, get no header.
+var commentSyntax = map[string][2]string{
+	".yaml": {"# ", ""}, ".yml": {"# ", ""}, ".toml": {"# ", ""}, ".conf": {"# ", ""},
+	".env": {"# ", ""}, ".sh": {"# ", ""}, ".py": {"# ", ""}, ".properties": {"# ", ""},
+	".tf": {"# ", ""}, ".hcl": {"# ", ""}, ".ini": {"; ", ""},
+	".xml": {"<!-- ", " -->"}, ".html": {"<!-- ", " -->"}, ".md": {"<!-- ", " -->"},
+	".go": {"// ", ""}, ".js": {"// ", ""}, ".ts": {"// ", ""}, ".java": {"// ", ""},
+	".c": {"// ", ""}, ".h": {"// ", ""}, ".rs": {"// ", ""}, ".css": {"/* ", " */"},
+	".sql": {"-- ", ""}, ".lua": {"-- ", ""},
+}
+
+// injectHeader returns out with a comment naming template and the render time
+// as its first line, or after a leading #! or <?xml line. It returns out
+// unchanged when the comment syntax of dest is unknown.
+func injectHeader(out []byte, dest, template string, now time.Time) []byte {
+	base := filepath.Base(dest)
+	ext := strings.ToLower(filepath.Ext(base))
+	if base == "Dockerfile" || base == "Makefile" {
+		ext = ".sh"
+	}
+	syntax, ok := commentSyntax[ext]
+	if !ok {
+		return out
+	}
+	header := syntax[0] + headerText + " at " + now.UTC().Format(time.RFC3339) + " from " +
+		filepath.ToSlash(template) + " - do not edit" + syntax[1] + "\n"
+
+	at := 0
+	if bytes.HasPrefix(out, []byte("#!")) || bytes.HasPrefix(out, []byte("<?xml")) {
+		if nl := bytes.IndexByte(out, '\n'); nl != -1 {
+			at = nl + 1
+		} else {
+			return out
+		}
+	}
+	res := make([]byte, 0, len(out)+len(header))
+	res = append(res, out[:at]...)
+	res = append(res, header...)
+	return append(res, out[at:]...)
+}
+
+// withoutHeader returns data without a header added by injectHeader, so that
+// a render is only considered changed when more than its timestamp differs.
+func withoutHeader(data []byte) []byte {
+	for i, start := 0, 0; i < 2 && start < len(data); i++ {
+		end := bytes.IndexByte(data[start:], '\n')
+		if end == -1 {
+			return data
+		}
+		end += start + 1
+		if bytes.Contains(data[start:end], []byte(headerText+" at ")) {
+			res := make([]byte, 0, len(data)-(end-start))
+			return append(append(res, data[:start]...), data[end:]...)
+		}
+		start = end
+	}
+	return data
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInjectHeader(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		dest, in, want string
+	}{
+		{"out/app.yaml", "a: 1\n", "# Generated by charmap at 2024-05-01T12:00:00Z from tpl/app.yaml - do not edit\na: 1\n"},
+		{"out/run.sh", "#!/bin/sh\necho\n", "#!/bin/sh\n# Generated by charmap at 2024-05-01T12:00:00Z from tpl/app.yaml - do not edit\necho\n"},
+		{"out/pom.xml", "<?xml version=\"1.0\"?>\n<a/>\n", "<?xml version=\"1.0\"?>\n<!-- Generated by charmap at 2024-05-01T12:00:00Z from tpl/app.yaml - do not edit -->\n<a/>\n"},
+		{"out/data.json", "{}\n", "{}\n"},
+	}
+	for _, tt := range tests {
+		got := injectHeader([]byte(tt.in), tt.dest, "tpl/app.yaml", now)
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.dest, got, tt.want)
+		}
+		if back := withoutHeader(got); string(back) != tt.in {
+			t.Errorf("%s: withoutHeader = %q, want %q", tt.dest, back, tt.in)
+		}
+	}
+}
+
+func TestProcessFiles_HeaderIgnoredForChanges(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		Workers:    1,
+		KeyMap:     map[string]string{"V": "1"},
+		FileFilter: ff,
+		Header:     true,
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+	dest := filepath.Join(out, "app.yaml")
+	first, _ := os.ReadFile(dest)
+	if string(withoutHeader(first)) != "v: 1\n" || string(first) == "v: 1\n" {
+		t.Fatalf("rendered %q, want a header before v: 1", first)
+	}
+
+	// Back-date the header; a later run must not count it as a change.
+	stale := []byte("# Generated by charmap at 2000-01-01T00:00:00Z from x - do not edit\nv: 1\n")
+	if err := os.WriteFile(dest, stale, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := processTree(cfg)
+	if err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+	if anyWritten(results) {
+		t.Errorf("output differing only in its header was rewritten")
+	}
+	if got, _ := os.ReadFile(dest); string(got) != string(stale) {
+		t.Errorf("dest = %q, want it untouched", got)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// historyEntry is one line of the -history log.
+type historyEntry struct {
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"`
+	Out  string    `json:"out,omitempty"`
+	// Config is the configHash of the run.
+	Config  string `json:"config"`
+	Files   int    `json:"files"`
+	Changed int    `json:"changed"`
+	// Report is the -manifest the run wrote, if any.
+	Report string `json:"report,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// configHash returns a short digest of the flags set for the run and the
+// values it resolved, which tells runs with the same configuration apart from
+// others without revealing any value.
+func configHash(cfg config) string {
+	var set []string
+	flag.Visit(func(fl *flag.Flag) {
+		set = append(set, "-"+fl.Name+"="+fl.Value.String())
+	})
+	sort.Strings(set)
+	h := sha256.New()
+	for _, s := range set {
+		h.Write([]byte(s + "\x00"))
+	}
+	keys := make([]string, 0, len(cfg.KeyMap))
+	for k := range cfg.KeyMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte(k + "\x00" + cfg.KeyMap[k] + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// appendHistory adds the run that produced results, or failed with runErr, to
+// the -history log.
+func appendHistory(cfg config, results []fileResult, runErr error) error {
+	e := historyEntry{
+		Time:   time.Now().UTC(),
+		Dir:    cfg.TargetDir,
+		Out:    cfg.OutDir,
+		Config: configHash(cfg),
+		Report: cfg.ManifestPath,
+	}
+	files := make(map[string]bool)
+	for _, r := range results {
+		files[r.Path] = true
+		if r.Written {
+			e.Changed++
+		}
+	}
+	e.Files = len(files)
+	if runErr != nil {
+		e.Error = runErr.Error()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(cfg.HistoryPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// historyCmd prints the -history log, oldest run first.
+func historyCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("history: unexpected arguments %v", args)
+	}
+	if cfg.HistoryPath == "" {
+		return fmt.Errorf("history: -history must be set")
+	}
+	f, err := os.Open(cfg.HistoryPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeHistory(f, os.Stdout)
+}
+
+// writeHistory renders the history log r as a table.
+func writeHistory(r io.Reader, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tDIR\tCONFIG\tFILES\tCHANGED\tREPORT\tRESULT")
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e historyEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("history line %d: %w", line, err)
+		}
+		dir, report, result := e.Dir, e.Report, "ok"
+		if e.Out != "" {
+			dir += " -> " + e.Out
+		}
+		if report == "" {
+			report = "-"
+		}
+		if e.Error != "" {
+			result = "failed: " + e.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", e.Time.Local().Format(time.DateTime), dir, e.Config, e.Files, e.Changed, report, result)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".charmap-history")
+	cfg := config{
+		TargetDir:    "manifests",
+		OutDir:       "rendered",
+		KeyMap:       map[string]string{"A": "1"},
+		ManifestPath: "manifest.json",
+		HistoryPath:  path,
+	}
+	results := []fileResult{
+		{Path: "manifests/a.yaml", Written: true},
+		{Path: "manifests/a.yaml", Profile: "prod"},
+		{Path: "manifests/b.yaml"},
+	}
+	if err := appendHistory(cfg, results, nil); err != nil {
+		t.Fatal(err)
+	}
+	cfg.KeyMap = map[string]string{"A": "2"}
+	if err := appendHistory(cfg, nil, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var sb strings.Builder
+	if err := writeHistory(f, &sb); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines:\n%s", len(lines), sb.String())
+	}
+	if !strings.Contains(lines[1], "manifests -> rendered") || !strings.Contains(lines[1], "  2  ") || !strings.Contains(lines[1], "  1  ") ||
+		!strings.Contains(lines[1], "manifest.json") || !strings.HasSuffix(lines[1], "ok") {
+		t.Errorf("first run: %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "failed: boom") {
+		t.Errorf("second run: %q", lines[2])
+	}
+	// The values changed between the runs, so the config hashes differ.
+	if h1, h2 := strings.Fields(lines[1])[5], strings.Fields(lines[2])[5]; h1 == h2 {
+		t.Errorf("config hash %s did not change with the values", h1)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth bounds nested #include directives, which also ends include
+// cycles.
+const maxIncludeDepth = 16
+
+// expandIncludes replaces every <::#include "NAME"::> directive in txt, the
+// contents of path, with the partial template it names, whose own includes
+// are expanded in turn. One trailing newline of the partial is dropped, so a
+// directive on a line of its own adds no blank line. See resolveInclude for
+// where NAME is looked up.
+func expandIncludes(inc includeLookup, path string, txt []byte, open, close string) ([]byte, error) {
+	out, _, err := expandIncludesMapped(inc, path, txt, open, close)
+	return out, err
+}
+
+// expandIncludesMapped is expandIncludes, also returning where each part of
+// the expanded text was read from.
+func expandIncludesMapped(inc includeLookup, path string, txt []byte, open, close string) ([]byte, []includeSpan, error) {
+	return expandIncludesFrom(inc, []string{path}, txt, open, close)
+}
+
+// includeSpan maps the expanded text from start up to the next span back to
+// src, the contents of path, from offset at.
+type includeSpan struct {
+	start int
+	path  string
+	src   []byte
+	at    int
+}
+
+// includeLookup is where #include partials are found: the input source the
+// including templates come from and the -template-path roots in it. Unless
+// -allow-outside is set, partials must lie inside the tree or a root.
+type includeLookup struct {
+	src          inputSource
+	roots        []string
+	allowOutside bool
+}
+
+func expandIncludesFrom(inc includeLookup, chain []string, txt []byte, open, close string) ([]byte, []includeSpan, error) {
+	path := chain[len(chain)-1]
+	spans := []includeSpan{{path: path, src: txt}}
+	if !bytes.Contains(txt, []byte("#include")) {
+		return txt, spans, nil
+	}
+
+	var out bytes.Buffer
+	pos := 0
+	for {
+		idx := bytes.Index(txt[pos:], []byte(open))
+		if idx == -1 {
+			break
+		}
+		idx += pos
+		start := idx + len(open)
+		end := bytes.Index(txt[start:], []byte(close))
+		if end == -1 {
+			break
+		}
+		end += start
+		expr := strings.TrimSpace(string(txt[start:end]))
+		arg, ok := strings.CutPrefix(expr, "#include")
+		if !ok || (arg != "" && arg[0] != ' ' && arg[0] != '\t') {
+			out.Write(txt[pos : end+len(close)])
+			pos = end + len(close)
+			continue
+		}
+
+		line := bytes.Count(txt[:idx], []byte("\n")) + 1
+		name, err := strconv.Unquote(strings.TrimSpace(arg))
+		if err != nil || name == "" {
+			return nil, nil, fmt.Errorf("%s:%d: expected #include \"NAME\", got %s", path, line, expr)
+		}
+		if len(chain) > maxIncludeDepth {
+			return nil, nil, fmt.Errorf("%s:%d: includes nested more than %d deep: %s", path, line, maxIncludeDepth, strings.Join(chain, " -> "))
+		}
+		partial, err := resolveInclude(inc, name, filepath.Dir(path))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		data, err := inc.src.readFile(partial)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		data, partSpans, err := expandIncludesFrom(inc, append(chain[:len(chain):len(chain)], partial), data, open, close)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		out.Write(txt[pos:idx])
+		base := out.Len()
+		out.Write(bytes.TrimSuffix(data, []byte("\n")))
+		for _, s := range partSpans {
+			if s.start += base; s.start < out.Len() {
+				spans = append(spans, s)
+			}
+		}
+		pos = end + len(close)
+		spans = append(spans, includeSpan{start: out.Len(), path: path, src: txt, at: pos})
+	}
+	if pos == 0 {
+		return txt, spans, nil
+	}
+	out.Write(txt[pos:])
+	return out.Bytes(), spans, nil
+}
+
+// resolveInclude returns the file of inc.src an #include of name refers to.
+// A relative name is looked up in dir, the directory of the including
+// template, and then in every -template-path root in order; the first that
+// has it wins. For an archive or git tree, the roots are directories of the
+// tree. Absolute names and names with .. elements need -allow-outside, and
+// so does a partial that resolves, through symlinks, outside the tree and
+// the roots.
+func resolveInclude(inc includeLookup, name, dir string) (string, error) {
+	name = filepath.FromSlash(name)
+	if escapesName(name) && !inc.allowOutside {
+		return "", fmt.Errorf("#include %q: absolute names and .. elements need -allow-outside, use -template-path for shared partials", name)
+	}
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	searched := append([]string{dir}, inc.roots...)
+	for _, d := range searched {
+		p := filepath.Join(d, name)
+		fi, err := inc.src.stat(p)
+		if err == nil && !fi.IsDir() {
+			if !inc.allowOutside {
+				if err := inc.src.contains(p, inc.roots); err != nil {
+					return "", fmt.Errorf("#include %q: %w", name, err)
+				}
+			}
+			return p, nil
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("#include %q: %w", name, err)
+		}
+	}
+	return "", fmt.Errorf("#include %q: not found in %s", name, strings.Join(searched, ", "))
+}
+
+// withIncludes returns in, the contents of path, with its includes expanded
+// as a run with c would.
+func (c config) withIncludes(path string, in []byte) ([]byte, error) {
+	open, close := c.delims(path)
+	return expandIncludes(c.includeLookup(), path, in, open, close)
+}
+
+// includeLookup returns where a run with c finds #include partials.
+func (c config) includeLookup() includeLookup {
+	return includeLookup{src: c.input(), roots: c.TemplatePath, allowOutside: c.AllowOutside}
+}
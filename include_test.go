@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProcessTree_Include(t *testing.T) {
+	src, lib, out := t.TempDir(), t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(src, "app.yaml"):               "metadata:\n<::#include \"labels.yaml\"::>\nspec: <::#include \"partials/spec.yaml\"::>\n",
+		filepath.Join(src, "labels.yaml"):            "  labels: {app: <::APP::>}\n",
+		filepath.Join(lib, "labels.yaml"):            "  labels: shadowed\n",
+		filepath.Join(lib, "partials/spec.yaml"):     "<::#include \"replicas.yaml\"::>\n",
+		filepath.Join(lib, "partials/replicas.yaml"): "{replicas: <::N::>}\n",
+	}
+	for name, txt := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`app\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:    "<::",
+		CloseDelim:   "::>",
+		TargetDir:    src,
+		OutDir:       out,
+		Workers:      1,
+		KeyMap:       map[string]string{"APP": "web", "N": "3"},
+		FileFilter:   ff,
+		TemplatePath: []string{t.TempDir(), lib},
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+	want := "metadata:\n  labels: {app: web}\nspec: {replicas: 3}\n"
+	if got, _ := os.ReadFile(filepath.Join(out, "app.yaml")); string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExpandIncludes_Errors(t *testing.T) {
+	dir := t.TempDir()
+	loop := filepath.Join(dir, "loop.yaml")
+	if err := os.WriteFile(loop, []byte("<::#include \"loop.yaml\"::>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"a\n<::#include \"missing.yaml\"::>": `app.yaml:2: #include "missing.yaml": not found in ` + dir + ", /lib",
+		"<::#include missing.yaml::>":        `expected #include "NAME"`,
+		"<::#include \"loop.yaml\"::>":       "nested more than 16 deep",
+	}
+	for in, want := range tests {
+		_, err := expandIncludes(includeLookup{src: dirSource{root: dir}, roots: []string{"/lib"}}, filepath.Join(dir, "app.yaml"), []byte(in), "<::", "::>")
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", in, err, want)
+		}
+	}
+
+	// Other directives and look-alikes are left alone.
+	const plain = "<::#if has(A)::><::#includes::><::#end::>"
+	if got, err := expandIncludes(includeLookup{src: dirSource{root: dir}}, filepath.Join(dir, "app.yaml"), []byte(plain), "<::", "::>"); err != nil || string(got) != plain {
+		t.Errorf("got %q, %v, want it unchanged", got, err)
+	}
+}
+
+func TestProcessTree_IncludeOutside(t *testing.T) {
+	root := t.TempDir()
+	src, lib, out := filepath.Join(root, "tree"), filepath.Join(root, "lib"), t.TempDir()
+	secret := filepath.Join(root, "secret.txt")
+	for _, dir := range []string{src, lib} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(secret, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// A -template-path root may hold partials, but not links out of it.
+	if err := os.Symlink(secret, filepath.Join(lib, "link.txt")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`app\.yaml$`}, nil)
+	for _, name := range []string{"../secret.txt", secret, "link.txt"} {
+		tmpl := "<::#include " + strconv.Quote(name) + "::>\n"
+		if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte(tmpl), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := config{
+			OpenDelim:    "<::",
+			CloseDelim:   "::>",
+			TargetDir:    src,
+			OutDir:       out,
+			Workers:      1,
+			FileFilter:   ff,
+			TemplatePath: []string{lib},
+		}
+		if _, err := processTree(cfg); err == nil || !strings.Contains(err.Error(), "-allow-outside") {
+			t.Errorf("#include %q: got %v, want an -allow-outside error", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(out, "app.yaml")); err == nil {
+			t.Fatalf("#include %q: output written", name)
+		}
+
+		cfg.AllowOutside = true
+		if _, err := processTree(cfg); err != nil {
+			t.Errorf("#include %q with -allow-outside: %v", name, err)
+		}
+		os.Remove(filepath.Join(out, "app.yaml"))
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// inputSource is where a run reads its templates from, along with their
+// sidecars and scoped values files: the disk under -dir, or with
+// -from-archive or -git-ref a read-only tree. Paths are those the walk hands
+// out, so the rest of the run does not care which one it reads.
+type inputSource interface {
+	// walk walks the tree like filepath.WalkDir, calling fn for every
+	// directory and regular file; walkFiles decides which are rendered.
+	walk(cfg config, fn func(path string, d fs.DirEntry) error) error
+	stat(path string) (fs.FileInfo, error)
+	open(path string) (io.ReadCloser, error)
+	readFile(path string) ([]byte, error)
+	// contains fails unless path lies inside the tree or one of roots.
+	contains(path string, roots []string) error
+}
+
+// input returns the source c reads its templates from.
+func (c config) input() inputSource {
+	if c.Input == nil {
+		return dirSource{root: c.TargetDir}
+	}
+	return c.Input
+}
+
+// dirSource reads templates from the disk under root, -dir.
+type dirSource struct {
+	root string
+}
+
+// walk skips output directories inside -dir and, unless -allow-outside is
+// set, fails on symlinks resolving outside it and on directories mounted
+// from another device.
+func (dirSource) walk(cfg config, fn func(path string, d fs.DirEntry) error) error {
+	scope, err := newReadScope(cfg.TargetDir, cfg.AllowOutside)
+	if err != nil {
+		return err
+	}
+	skip := make(map[string]bool)
+	for _, t := range renderTargets(cfg) {
+		if t.outDir != "" {
+			skip[pathKey(t.outDir, cfg.FoldCase)] = true
+		}
+	}
+	return filepath.WalkDir(cfg.TargetDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := scope.check(p, d); err != nil {
+			return err
+		}
+		if d.IsDir() && skip[pathKey(p, cfg.FoldCase)] {
+			return filepath.SkipDir
+		}
+		return fn(p, d)
+	})
+}
+
+func (dirSource) stat(path string) (fs.FileInfo, error) { return os.Stat(longPath(path)) }
+
+func (dirSource) open(path string) (io.ReadCloser, error) { return os.Open(longPath(path)) }
+
+func (dirSource) readFile(path string) ([]byte, error) { return os.ReadFile(longPath(path)) }
+
+// contains resolves symlinks in path and in the directories it may be in.
+func (s dirSource) contains(path string, roots []string) error {
+	return checkReadScope(path, append([]string{s.root}, roots...))
+}
+
+// fsSource reads templates from a read-only tree, such as a -from-archive
+// archive or -git-ref tree, whose slash-separated names stand in for paths
+// relative to -dir.
+type fsSource struct {
+	fsys fs.FS
+}
+
+// walk leaves out links and other entries that are neither directories nor
+// regular files.
+func (s fsSource) walk(cfg config, fn func(path string, d fs.DirEntry) error) error {
+	return fs.WalkDir(s.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		return fn(filepath.FromSlash(name), d)
+	})
+}
+
+// name returns the name path has in the tree.
+func (s fsSource) name(path string) string {
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+func (s fsSource) stat(path string) (fs.FileInfo, error) { return fs.Stat(s.fsys, s.name(path)) }
+
+func (s fsSource) open(path string) (io.ReadCloser, error) { return s.fsys.Open(s.name(path)) }
+
+func (s fsSource) readFile(path string) ([]byte, error) { return fs.ReadFile(s.fsys, s.name(path)) }
+
+// contains holds for every path, since an fs.FS has no names outside it.
+func (s fsSource) contains(path string, roots []string) error { return nil }
+
+// pathKey identifies path for comparisons: absolute and, with fold, lower
+// case.
+func pathKey(path string, fold bool) string {
+	abs, _ := filepath.Abs(path)
+	if fold {
+		return strings.ToLower(abs)
+	}
+	return abs
+}
+
+// maxUnpackedArchive is the most bytes a gzip-compressed -from-archive may
+// decompress to. It is unpacked into memory, and a small archive can expand
+// into far more than any template tree.
+var maxUnpackedArchive int64 = 256 << 20
+
+// openArchive opens the zip or tar archive at path, the latter optionally
+// gzip-compressed, as a tree. Zip and plain tar archives are read in place
+// and stay open for the rest of the process; a compressed tar is unpacked
+// into memory, up to maxUnpackedArchive bytes.
+func openArchive(path string) (fs.FS, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := openArchiveFile(f)
+	if err != nil {
+		f.Close()
+	}
+	return fsys, err
+}
+
+func openArchiveFile(f *os.File) (fs.FS, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	if _, err := f.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return zip.NewReader(f, fi.Size())
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(gz, maxUnpackedArchive+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > maxUnpackedArchive {
+			return nil, fmt.Errorf("%s decompresses to more than %d MiB, unpack it and use -dir instead", f.Name(), maxUnpackedArchive>>20)
+		}
+		f.Close()
+		return tarFS(bytes.NewReader(data), int64(len(data)))
+	}
+	return tarFS(f, fi.Size())
+}
+
+// gitTreeFS reads the tree of a commit, tag or tree object as an input
+// source, without a checkout. spec is a ref of the repository in the current
+// directory, or REPO#REF for another one; REF may name a subdirectory as in
+// v1.2.3:deploy. The tree is exported by git archive, so files excluded with
+// export-ignore attributes are left out.
+func gitTreeFS(spec string) (fs.FS, error) {
+	repo, ref := ".", spec
+	if i := strings.LastIndex(spec, "#"); i >= 0 {
+		repo, ref = spec[:i], spec[i+1:]
+	}
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid ref %q", ref)
+	}
+	out, err := exec.Command("git", "-C", repo, "archive", "--format=tar", ref).Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+			return nil, fmt.Errorf("git archive %s: %s", ref, strings.TrimSpace(string(exit.Stderr)))
+		}
+		return nil, err
+	}
+	return tarFS(bytes.NewReader(out), int64(len(out)))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProcessTree_FromArchive(t *testing.T) {
+	entries := []struct{ name, body string }{
+		{"./app.yaml", "v: <::V::> <::REGION::>\n"},
+		{"./values.yaml", "REGION: eu\n"},
+		{"./db/db.yaml", "host: <::HOST::>\n"},
+		{"./db/db.yaml" + sidecarSuffix, "HOST=db-archive\n"},
+		{"./notes.txt", "<::V::>\n"},
+	}
+	var plain, tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(io.MultiWriter(&plain, gz))
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for _, e := range entries {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: e.name, Mode: 0o600, Size: int64(len(e.body))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.body))
+		w, _ := zw.Create(e.name[2:])
+		w.Write([]byte(e.body))
+	}
+	tw.Close()
+	gz.Close()
+	zw.Close()
+
+	archives := map[string][]byte{"templates.tar": plain.Bytes(), "templates.tar.gz": tgz.Bytes(), "templates.zip": zipped.Bytes()}
+	for name, data := range archives {
+		t.Run(name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(archive, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			input, err := openArchive(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := fsSource{input}
+			out := t.TempDir()
+			ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+			cfg := config{
+				OpenDelim:    "<::",
+				CloseDelim:   "::>",
+				TargetDir:    ".",
+				OutDir:       out,
+				Workers:      2,
+				KeyMap:       map[string]string{"V": "1", "HOST": "db1"},
+				FileFilter:   ff,
+				Input:        src,
+				ScopedValues: "values.yaml",
+			}
+			results, err := processTree(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 2 {
+				t.Errorf("rendered %d files, want 2", len(results))
+			}
+			for name, want := range map[string]string{"app.yaml": "v: 1 eu\n", "db/db.yaml": "host: db-archive\n"} {
+				if got, _ := os.ReadFile(filepath.Join(out, name)); string(got) != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGitTreeFS(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name, body string) {
+		t.Helper()
+		p := filepath.Join(repo, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	write("deploy/app.yaml", "v: <::V::>\n<::#include \"parts/labels.txt\"::>\n")
+	write("deploy/parts/labels.txt", "app: <::APP::>\n")
+	git("add", ".")
+	git("commit", "-qm", "one")
+	git("tag", "v1")
+	// Later commits and the working tree do not leak into the tagged tree.
+	write("deploy/app.yaml", "changed: <::V::>\n")
+	write("deploy/new.yaml", "new\n")
+	git("add", ".")
+	git("commit", "-qm", "two")
+	write("deploy/app.yaml", "dirty\n")
+	write("deploy/parts/labels.txt", "dirty\n")
+
+	input, err := gitTreeFS(repo + "#v1:deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  ".",
+		OutDir:     out,
+		Workers:    1,
+		KeyMap:     map[string]string{"V": "1", "APP": "web"},
+		FileFilter: ff,
+		Input:      fsSource{input},
+	}
+	results, err := processTree(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Errorf("rendered %d files, want 1", len(results))
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "app.yaml")); string(got) != "v: 1\napp: web\n" {
+		t.Errorf("app.yaml: got %q", got)
+	}
+
+	for _, spec := range []string{repo + "#nope", repo + "#--output=x", repo + "#"} {
+		if _, err := gitTreeFS(spec); err == nil {
+			t.Errorf("gitTreeFS(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestProcessTree_FromArchiveIncludes(t *testing.T) {
+	input := fstest.MapFS{
+		"app.yaml":         {Data: []byte("<::#include \"parts/head.txt\"::>\n<::#include \"labels.txt\"::>\n")},
+		"parts/head.txt":   {Data: []byte("v: <::V::>\n")},
+		"lib/labels.txt":   {Data: []byte("app: <::APP::>\n")},
+		"other/labels.txt": {Data: []byte("shadowed\n")},
+	}
+	out := t.TempDir()
+	ff, _ := newFileFilter([]string{`app\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:    "<::",
+		CloseDelim:   "::>",
+		TargetDir:    ".",
+		OutDir:       out,
+		Workers:      1,
+		KeyMap:       map[string]string{"V": "1", "APP": "web"},
+		FileFilter:   ff,
+		Input:        fsSource{input},
+		TemplatePath: []string{"lib", "other"},
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "app.yaml")); string(got) != "v: 1\napp: web\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestOpenArchive_GzipLimit(t *testing.T) {
+	defer func(n int64) { maxUnpackedArchive = n }(maxUnpackedArchive)
+	maxUnpackedArchive = 1 << 20
+
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	body := make([]byte, 2<<20)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "big.yaml", Mode: 0o600, Size: int64(len(body))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(body)
+	tw.Close()
+	gz.Close()
+	archive := filepath.Join(t.TempDir(), "big.tar.gz")
+	if err := os.WriteFile(archive, tgz.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openArchive(archive); err == nil || !strings.Contains(err.Error(), "decompresses to more than 1 MiB") {
+		t.Errorf("got %v, want the archive refused", err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// valueLimits bounds the values substituted into a template, see
+// -max-value-bytes and -max-value-lines. Zero fields are unlimited.
+type valueLimits struct {
+	MaxBytes int
+	MaxLines int
+	// Warn logs values over a limit instead of failing the file.
+	Warn bool
+}
+
+// oversized returns why each value of values breaks the limits, keyed by key.
+func (l valueLimits) oversized(values map[string]string) map[string]string {
+	if l.MaxBytes == 0 && l.MaxLines == 0 {
+		return nil
+	}
+	var over map[string]string
+	for k, v := range values {
+		var reason string
+		if l.MaxBytes > 0 && len(v) > l.MaxBytes {
+			reason = fmt.Sprintf("%d bytes, over the -max-value-bytes limit of %d", len(v), l.MaxBytes)
+		} else if lines := strings.Count(v, "\n") + 1; l.MaxLines > 0 && lines > l.MaxLines {
+			reason = fmt.Sprintf("%d lines, over the -max-value-lines limit of %d", lines, l.MaxLines)
+		}
+		if reason == "" {
+			continue
+		}
+		if over == nil {
+			over = make(map[string]string)
+		}
+		over[k] = reason
+	}
+	return over
+}
+
+// checkValueLimits fails on the first placeholder in txt, plain or filtered,
+// whose key is in over, or only logs it with warn.
+func checkValueLimits(txt, open, close string, over map[string]string, warn bool) error {
+	rest := txt
+	for {
+		idx := strings.Index(rest, open)
+		if idx == -1 {
+			return nil
+		}
+		start := idx + len(open)
+		end := strings.Index(rest[start:], close)
+		if end == -1 {
+			return nil
+		}
+		key, _, _ := strings.Cut(strings.TrimSpace(rest[start:start+end]), " ")
+		key, _, _ = strings.Cut(key, "|")
+		if reason, ok := over[key]; ok {
+			line := strings.Count(txt[:len(txt)-len(rest)+idx], "\n") + 1
+			if !warn {
+				return fmt.Errorf("line %d: value of %q is %s", line, key, reason)
+			}
+			slog.Warn("value over limit", slog.String("key", key), slog.Int("line", line), slog.String("reason", reason))
+		}
+		rest = rest[start:]
+	}
+}
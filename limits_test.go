@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValueLimits(t *testing.T) {
+	values := map[string]string{
+		"SMALL": "ok",
+		"BLOB":  strings.Repeat("x", 64),
+		"CERT":  "a\nb\nc",
+	}
+	const in = "s: <::SMALL::>\n<::#if has(NONE)::>\nb: <::BLOB::>\n<::#end::>\nc: <::CERT | upper::>\n"
+
+	tests := []struct {
+		name    string
+		limits  valueLimits
+		wantErr string
+	}{
+		{name: "unlimited"},
+		{name: "bytes in dropped branch", limits: valueLimits{MaxBytes: 16}},
+		{name: "lines", limits: valueLimits{MaxLines: 2}, wantErr: `line 5: value of "CERT" is 3 lines`},
+		{name: "warn", limits: valueLimits{MaxLines: 2, Warn: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := buildNewReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{Limits: tt.limits})
+			out, _, err := r([]byte(in))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "s: ok\nc: A\nB\nC\n"; string(out) != want {
+				t.Errorf("got %q, want %q", out, want)
+			}
+		})
+	}
+}
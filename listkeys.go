@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// docComment matches an "@doc KEY: description" line in any comment syntax,
+// such as "# @doc PUBLIC_DOMAIN: public DNS name". A trailing "-->" or "*/"
+// is not part of the description.
+var docComment = regexp.MustCompile(`(?m)@doc\s+([^\s:]+)\s*:[ \t]*(.*?)[ \t]*(?:-->|\*/)?[ \t]*$`)
+
+// keyDoc is one entry of the variables reference written by list-keys -docs.
+type keyDoc struct {
+	Key         string   `json:"key"`
+	Description string   `json:"description,omitempty"`
+	Files       []string `json:"files"`
+}
+
+// listKeysCmd prints every key the templates under -dir reference, one per
+// line. With -docs it writes a variables reference instead, in -docs-format,
+// describing each key by the @doc comments of the templates.
+func listKeysCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("list-keys: unexpected arguments %v", args)
+	}
+	docs, err := collectKeyDocs(cfg)
+	if err != nil {
+		return err
+	}
+	if !cfg.KeyDocs {
+		for _, d := range docs {
+			fmt.Println(d.Key)
+		}
+		return nil
+	}
+	if cfg.DocsFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(docs)
+	}
+	return writeKeyDocs(os.Stdout, docs)
+}
+
+// collectKeyDocs returns every key referenced or documented under -dir,
+// sorted, with the files referencing it. Different descriptions of one key
+// are joined in the order found.
+func collectKeyDocs(cfg config) ([]keyDoc, error) {
+	byKey := make(map[string]*keyDoc)
+	get := func(k string) *keyDoc {
+		d := byKey[k]
+		if d == nil {
+			d = &keyDoc{Key: k, Files: []string{}}
+			byKey[k] = d
+		}
+		return d
+	}
+	err := walkFiles(cfg, func(path string) error {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if cfg.skipContent(in) {
+			return nil
+		}
+		keys, err := templateKeys(path, string(in), cfg, cfg.KeyMap)
+		if err != nil {
+			return fmt.Errorf("failed to scan %q: %w", path, err)
+		}
+		for _, k := range keys {
+			d := get(k)
+			d.Files = append(d.Files, path)
+		}
+		for _, m := range docComment.FindAllStringSubmatch(string(in), -1) {
+			d := get(m[1])
+			switch {
+			case m[2] == "" || slices.Contains(strings.Split(d.Description, "; "), m[2]):
+			case d.Description == "":
+				d.Description = m[2]
+			default:
+				d.Description += "; " + m[2]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]keyDoc, 0, len(byKey))
+	for _, d := range byKey {
+		docs = append(docs, *d)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Key < docs[j].Key })
+	return docs, nil
+}
+
+// writeKeyDocs writes docs as a Markdown table. Keys documented but never
+// referenced are marked so.
+func writeKeyDocs(w io.Writer, docs []keyDoc) error {
+	fmt.Fprintln(w, "| Key | Description | Used in |")
+	fmt.Fprintln(w, "| --- | --- | --- |")
+	for _, d := range docs {
+		used := "not referenced"
+		if len(d.Files) > 0 {
+			used = "`" + strings.Join(d.Files, "`, `") + "`"
+		}
+		desc := strings.ReplaceAll(d.Description, "|", `\|`)
+		if desc == "" {
+			desc = "_undocumented_"
+		}
+		if _, err := fmt.Fprintf(w, "| `%s` | %s | %s |\n", d.Key, desc, used); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollectKeyDocs(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"ingress.yaml": "# @doc PUBLIC_DOMAIN: public DNS name\n# @doc TLS_SECRET: name of the | TLS secret\nhost: <::PUBLIC_DOMAIN::>\n<::#if TLS::>tls: <::TLS_SECRET::><::#end::>\n",
+		"README.md":    "<!-- @doc PUBLIC_DOMAIN: public DNS name -->\n<!-- @doc PUBLIC_DOMAIN: without scheme -->\n<!-- @doc OLD_KEY: no longer used -->\nSee https://<::PUBLIC_DOMAIN::>/\n",
+	}
+	for name, txt := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.(ya?ml|md)$`}, nil)
+	cfg := config{OpenDelim: "<::", CloseDelim: "::>", TargetDir: src, FileFilter: ff, KeyMap: map[string]string{"TLS": "true"}}
+	docs, err := collectKeyDocs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := writeKeyDocs(&sb, docs); err != nil {
+		t.Fatal(err)
+	}
+	md, ing := filepath.Join(src, "README.md"), filepath.Join(src, "ingress.yaml")
+	want := "| Key | Description | Used in |\n| --- | --- | --- |\n" +
+		"| `OLD_KEY` | no longer used | not referenced |\n" +
+		"| `PUBLIC_DOMAIN` | public DNS name; without scheme | `" + md + "`, `" + ing + "` |\n" +
+		"| `TLS` | _undocumented_ | `" + ing + "` |\n" +
+		"| `TLS_SECRET` | name of the \\| TLS secret | `" + ing + "` |\n"
+	if sb.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
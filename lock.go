@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// lockFile pins the value of every key the templates reference by its
+// HMAC-SHA-256, keyed with the random Salt of the lock, see the lock command
+// and -frozen. Values themselves are not stored, and the salt keeps equal
+// values from hashing alike across locks.
+type lockFile struct {
+	Salt string `json:"salt"`
+	lockSet
+	Profiles map[string]lockSet `json:"profiles,omitempty"`
+}
+
+// lockSet holds the pins of one set of values: the keys of the value sources
+// in Keys, and per template, by its path relative to -dir, the keys whose
+// value comes from its sidecar or scoped values files in Files.
+type lockSet struct {
+	Keys  map[string]string            `json:"keys"`
+	Files map[string]map[string]string `json:"files,omitempty"`
+}
+
+// lockCmd writes the -lock file for the current values of every key
+// referenced under -dir, per profile with -profile.
+func lockCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("lock: unexpected arguments %v", args)
+	}
+	lock, err := buildLock(cfg)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(cfg.LockPath, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	n := len(lock.Keys)
+	for _, keys := range lock.Files {
+		n += len(keys)
+	}
+	fmt.Printf("%d key(s) pinned in %s\n", n, cfg.LockPath)
+	return nil
+}
+
+func buildLock(cfg config) (lockFile, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return lockFile{}, err
+	}
+	lock := lockFile{Salt: hex.EncodeToString(salt)}
+	pinned := func(keyMap map[string]string) (lockSet, error) {
+		set, err := pinValues(cfg, keyMap)
+		for k, v := range set.Keys {
+			set.Keys[k] = pinHash(salt, v)
+		}
+		for _, values := range set.Files {
+			for k, v := range values {
+				values[k] = pinHash(salt, v)
+			}
+		}
+		return set, err
+	}
+
+	var err error
+	if lock.lockSet, err = pinned(cfg.KeyMap); err != nil {
+		return lock, err
+	}
+	for _, p := range cfg.Profiles {
+		if lock.Profiles == nil {
+			lock.Profiles = make(map[string]lockSet)
+		}
+		if lock.Profiles[p.Name], err = pinned(p.KeyMap); err != nil {
+			return lock, err
+		}
+	}
+	return lock, nil
+}
+
+// pinValues returns the values a render with keyMap substitutes for the keys
+// the templates reference, including keys only used in #if conditions. Keys
+// without a value are left out, as in snapshotValues.
+func pinValues(cfg config, keyMap map[string]string) (lockSet, error) {
+	set := lockSet{Keys: make(map[string]string)}
+	err := walkFiles(cfg, func(path string) error {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if cfg.skipContent(in) {
+			return nil
+		}
+		local, values, err := localValues(cfg, path, keyMap)
+		if err != nil {
+			return err
+		}
+		keys, err := templateKeys(path, string(in), cfg, values)
+		if err != nil {
+			return fmt.Errorf("failed to scan %q: %w", path, err)
+		}
+		rel, err := filepath.Rel(cfg.TargetDir, path)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			v, ok := values[k]
+			switch {
+			case !ok:
+			case local[k]:
+				if set.Files == nil {
+					set.Files = make(map[string]map[string]string)
+				}
+				if set.Files[filepath.ToSlash(rel)] == nil {
+					set.Files[filepath.ToSlash(rel)] = make(map[string]string)
+				}
+				set.Files[filepath.ToSlash(rel)][k] = v
+			default:
+				set.Keys[k] = v
+			}
+		}
+		return nil
+	})
+	return set, err
+}
+
+// localValues returns the values path renders with: keyMap overlaid with its
+// sidecar and scoped values files, whose keys are set in local.
+func localValues(cfg config, path string, keyMap map[string]string) (local map[string]bool, values map[string]string, err error) {
+	side, _, err := loadLocalValues(path, cfg)
+	if err != nil || side == nil {
+		return nil, keyMap, err
+	}
+	if values, _, err = withSidecar(side, keyMap, cfg.replacerOptions()); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	local = make(map[string]bool, len(side))
+	for k := range side {
+		local[k] = true
+	}
+	return local, values, nil
+}
+
+// pinHash returns the hex HMAC-SHA-256 of v keyed with salt.
+func pinHash(salt []byte, v string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(v))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkFrozen fails when the value of a key pinned in the -lock file differs
+// from its current value, or is no longer set, naming every such key but
+// never a value. Keys pinned for a template are named after it.
+func checkFrozen(cfg config) error {
+	data, err := os.ReadFile(cfg.LockPath)
+	if err != nil {
+		return fmt.Errorf("-frozen: %w", err)
+	}
+	var lock lockFile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return fmt.Errorf("-frozen: parse %s: %w", cfg.LockPath, err)
+	}
+	salt, err := hex.DecodeString(lock.Salt)
+	if err != nil || len(salt) == 0 {
+		return fmt.Errorf("-frozen: %s has no valid salt, run charmap lock again", cfg.LockPath)
+	}
+
+	var changed []string
+	diff := func(prefix string, pinned lockSet, keyMap map[string]string) error {
+		for k, sum := range pinned.Keys {
+			if v, ok := keyMap[k]; !ok || !hmac.Equal([]byte(pinHash(salt, v)), []byte(sum)) {
+				changed = append(changed, prefix+k)
+			}
+		}
+		for rel, keys := range pinned.Files {
+			_, values, err := localValues(cfg, filepath.Join(cfg.TargetDir, filepath.FromSlash(rel)), keyMap)
+			if err != nil {
+				return err
+			}
+			for k, sum := range keys {
+				if v, ok := values[k]; !ok || !hmac.Equal([]byte(pinHash(salt, v)), []byte(sum)) {
+					changed = append(changed, prefix+rel+":"+k)
+				}
+			}
+		}
+		return nil
+	}
+	if err := diff("", lock.lockSet, cfg.KeyMap); err != nil {
+		return fmt.Errorf("-frozen: %w", err)
+	}
+	for _, p := range cfg.Profiles {
+		if err := diff(p.Name+"/", lock.Profiles[p.Name], p.KeyMap); err != nil {
+			return fmt.Errorf("-frozen: %w", err)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+	return fmt.Errorf("-frozen: %d value(s) differ from %s: %s", len(changed), cfg.LockPath, strings.Join(changed, ", "))
+}
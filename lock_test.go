@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFrozen(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("token: <::TOKEN::>\nv: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		Workers:    1,
+		FileFilter: ff,
+		KeyMap:     map[string]string{"TOKEN": "s3cret", "V": "1", "UNUSED": "x"},
+		LockPath:   filepath.Join(t.TempDir(), "charmap.lock"),
+	}
+	if err := lockCmd(cfg, nil); err != nil {
+		t.Fatalf("lockCmd: %v", err)
+	}
+	data, _ := os.ReadFile(cfg.LockPath)
+	if strings.Contains(string(data), "s3cret") || strings.Contains(string(data), "UNUSED") {
+		t.Errorf("lock file holds a value or an unreferenced key:\n%s", data)
+	}
+	if strings.Contains(string(data), sha256Hex([]byte("s3cret"))) {
+		t.Errorf("lock file holds the unsalted hash of a value:\n%s", data)
+	}
+	var first, second lockFile
+	_ = json.Unmarshal(data, &first)
+	if err := lockCmd(cfg, nil); err != nil {
+		t.Fatalf("lockCmd: %v", err)
+	}
+	data, _ = os.ReadFile(cfg.LockPath)
+	_ = json.Unmarshal(data, &second)
+	if first.Salt == "" || first.Salt == second.Salt || first.Keys["TOKEN"] == second.Keys["TOKEN"] {
+		t.Errorf("two locks share a salt or a hash: %+v, %+v", first, second)
+	}
+
+	cfg.Frozen = true
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("processTree with unchanged values: %v", err)
+	}
+
+	cfg.KeyMap = map[string]string{"TOKEN": "rotated", "V": "1"}
+	_, err := processTree(cfg)
+	if err == nil || !strings.Contains(err.Error(), "1 value(s) differ") || !strings.Contains(err.Error(), "TOKEN") {
+		t.Errorf("got %v, want TOKEN reported as changed", err)
+	}
+	if strings.Contains(err.Error(), "rotated") {
+		t.Errorf("error reveals the value: %v", err)
+	}
+}
+
+func TestFrozen_LocalValues(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "db"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"app.yaml":                 "v: <::V::>\n",
+		"app.yaml" + sidecarSuffix: "V=1\n",
+		"db/db.yaml":               "host: <::HOST::>\n",
+		"db/values.env":            "HOST=db1\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:    "<::",
+		CloseDelim:   "::>",
+		TargetDir:    src,
+		OutDir:       out,
+		Workers:      1,
+		FileFilter:   ff,
+		ScopedValues: "values.env",
+		KeyMap:       map[string]string{"V": "global"},
+		LockPath:     filepath.Join(t.TempDir(), "charmap.lock"),
+		Frozen:       true,
+	}
+	if err := lockCmd(cfg, nil); err != nil {
+		t.Fatalf("lockCmd: %v", err)
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("processTree with unchanged values: %v", err)
+	}
+
+	for name, data := range map[string]string{"app.yaml" + sidecarSuffix: "V=2\n", "db/values.env": "HOST=db2\n"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := processTree(cfg)
+	if err == nil || !strings.Contains(err.Error(), "2 value(s) differ") || !strings.Contains(err.Error(), "app.yaml:V, db/db.yaml:HOST") {
+		t.Errorf("got %v, want both local values reported as changed", err)
+	}
+}
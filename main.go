@@ -1,117 +1,737 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
-	openDelim            = flag.String("open", "<::", "opening delimiter")
-	closeDelim           = flag.String("close", "::>", "closing delimiter")
-	targetDir            = flag.String("dir", ".", "directory to scan")
-	workers              = flag.Int("workers", runtime.GOMAXPROCS(0), "concurrent file processors")
-	mode                 = flag.String("mode", "both", "value source: env | flag | both")
-	logFile              = flag.String("log", "", "log file (default no logging)")
-	inc                  = sliceFlag{`.*\.ya?ml$`}
-	ign                  = sliceFlag{`^\.git(/|$)`}
-	userKV     StringMap = make(StringMap)
+	configPath                 = flag.String("config", "", "JSON file of flag values, e.g. {\"dir\": \"templates\"}; flags on the command line take precedence")
+	openDelim                  = flag.String("open", "<::", "opening delimiter")
+	closeDelim                 = flag.String("close", "::>", "closing delimiter")
+	targetDir                  = flag.String("dir", ".", "directory to scan")
+	outDir                     = flag.String("out", "", "write rendered files under this directory instead of in place")
+	goldenDir                  = flag.String("golden", "", "directory of expected outputs (test command)")
+	updateGolden               = flag.Bool("update", false, "rewrite golden outputs instead of comparing (test command)")
+	workers                    = flag.Int("workers", runtime.GOMAXPROCS(0), "concurrent file processors")
+	queueDepth                 = flag.Int("queue-depth", 10000, "files the walk may queue ahead of the workers; also the window sorted largest first")
+	engineName                 = flag.String("engine", defaultEngine, "substitution engine: strings | loop | regex | replaceall | aho | auto (fastest per the last bench run)")
+	stage                      = flag.Int("stage", 0, "render only placeholders tagged with this stage, e.g. <::1:KEY::>, and untagged ones; 0 renders every stage")
+	syntaxName                 = flag.String("syntax", "plain", "file format placeholders are rendered in: plain | xml (text and attribute values, escaped) | ini (values only)")
+	strictCount                = flag.Bool("strict-count", false, "log placeholders found and replacements made per file, failing files where they differ")
+	chunkSize                  = flag.Int("chunk-size", 16<<20, "render files larger than this many bytes in parallel chunks (0 disables)")
+	mode                       = flag.String("mode", "both", "value source: env | flag | both")
+	logFile                    = flag.String("log", "", "log file (default no logging)")
+	errorsFormat               = flag.String("errors-format", "text", "text (one joined error at the end) | ndjson (also one JSON object per error as it occurs)")
+	errorsFile                 = flag.String("errors-file", "", "file the ndjson error stream is appended to (default stderr)")
+	logLevel                   = flag.String("log-level", "info", "log level: debug | info | warn | error")
+	cpuProfile                 = flag.String("cpuprofile", "", "write a CPU profile of the run to this file")
+	inc                        = sliceFlag{`.*\.ya?ml$`}
+	ign                        = sliceFlag{`^\.git(/|$)`}
+	ignContent                 = sliceFlag{}
+	filterFiles                = sliceFlag{}
+	skipVendored               = flag.Bool("skip-vendored", false, "skip vendor/, node_modules/ and similar directories, and files with a \"Code generated ... DO NOT EDIT\" or @generated header")
+	opaqueSpecs                = sliceFlag{}
+	extDelimSpecs              = sliceFlag{}
+	orderSpecs                 = sliceFlag{}
+	sourceSpecs                = sliceFlag{}
+	valueFiles                 = sliceFlag{}
+	watchDirs                  = sliceFlag{}
+	watchInterval              = flag.Duration("watch-interval", 2*time.Second, "sidecar: how often the -watch directories are checked for changes")
+	signalPID                  = flag.Int("signal-pid", 0, "sidecar: process sent SIGHUP after a render writes any file (0 disables)")
+	metricsFile                = flag.String("metrics-file", "", "sidecar: after every render, write per-key counts of replaced and missing placeholders to this file in the Prometheus text format, for node_exporter's textfile collector")
+	maxValueBytes              = flag.Int("max-value-bytes", 0, "largest value in bytes a placeholder may substitute (0 disables)")
+	maxValueLines              = flag.Int("max-value-lines", 0, "most lines a substituted value may span (0 disables)")
+	valueLimitPolicy           = flag.String("value-limit-policy", "error", "what a value over -max-value-bytes/-max-value-lines does: error | warn")
+	maxFiles                   = flag.Int("max-files", 0, "abort before rendering if more files than this match (0 disables)")
+	maxTotalBytes              = flag.Int64("max-total-bytes", 0, "abort before rendering if the matching files add up to more bytes than this (0 disables)")
+	allowKeysFile              = flag.String("allow-keys", "", "file listing the only keys that may be substituted, one per line")
+	profileSpecs               = sliceFlag{}
+	missingMarker              = flag.String("missing-placeholder", "", "replace placeholders whose key has no value with this marker instead of failing, {{key}} standing for the key, e.g. TODO_SET_{{key}}")
+	secretsDir                 = flag.String("secrets-dir", "/run/secrets", "directory secret(\"NAME\") placeholders read the file NAME from")
+	templatePath               = flag.String("template-path", "", "directories #include looks for partials in after the including template's own, separated by "+string(filepath.ListSeparator))
+	outTar                     = flag.String("out-tar", "", "write the files rendered below -out or -out-template into this tar archive instead, - for stdout")
+	outTemplate                = flag.String("out-template", "", "output directory per -profile, {env} is replaced by the profile name")
+	outPathSpec                = flag.String("out-path", "", "destination path template below -out, e.g. '{{dir}}/{{base | trimSuffix \".tpl\"}}'")
+	encryptSpec                = flag.String("encrypt", "", "encrypt files written to -out: age:RECIPIENT[,RECIPIENT...] or gpg:KEY-ID")
+	applyCmd                   = flag.String("apply-cmd", "", "shell command each rendered file is piped into instead of being written")
+	allowOutside               = flag.Bool("allow-outside", false, "allow symlinks, mounts and output paths that escape -dir/-out")
+	readableSecrets            = flag.Bool("readable-secrets", false, "allow secret() values in files readable by group or others")
+	fsCase                     = flag.String("fs-case", "sensitive", "path case handling for -include/-ignore and the walk: sensitive | insensitive | auto")
+	changedKeys                = flag.String("changed-keys", "", "comma-separated keys whose values changed: render only the files the -manifest of an earlier run lists as referencing them, and new files")
+	historyPath                = flag.String("history", "", "append a line describing each run (time, config hash, files changed, -manifest) to this file, e.g. .charmap-history; the history command prints it")
+	manifestPath               = flag.String("manifest", "", "write a JSON manifest of input, value source and output hashes; the verify command reads it instead")
+	header                     = flag.Bool("header", false, "start files written to -out with a comment naming their template and render time")
+	keySummary                 = flag.Bool("summary", false, "check: also print how many files and placeholders use each key")
+	rewriteFrom                = flag.String("from", "", "rewrite: text to replace, or a placeholder whose key is renamed")
+	rewriteTo                  = flag.String("to", "", "rewrite: replacement text, or the placeholder with the new key")
+	dryRun                     = flag.Bool("dry-run", false, "render in memory and report per file which keys would be replaced, writing nothing; rewrite: print a diff of the changes instead of writing them")
+	renderIn                   = flag.String("i", "", "render: template to render, - for stdin")
+	renderOut                  = flag.String("o", "", "render: file to write instead of stdout; snapshot-values: file to write the snapshot to")
+	onMutation                 = flag.String("on-mutation", "fail", "when a file changes while it is rendered in place: fail | skip (with a warning) | retry")
+	downwardDir                = flag.String("downward-dir", "/etc/podinfo", "-source k8s-downward: directory the downward API volume is mounted at")
+	useBuiltins                = flag.Bool("builtins", false, "provide the keys charmap.hostname, charmap.os, charmap.arch, charmap.time and charmap.runid")
+	expandJSON                 = flag.Bool("expand-json-env", false, "flatten environment variables holding a JSON object into KEY.field.sub keys, arrays by index")
+	invalidUTF8                = flag.String("invalid-utf8", "pass", "files that are not valid UTF-8: pass (byte for byte) | replace (with U+FFFD) | skip (leave the file alone) | fail")
+	fromArchive                = flag.String("from-archive", "", "read templates from this tar (optionally gzip-compressed) or zip archive instead of -dir; requires -out, -out-template or -apply-cmd")
+	gitRef                     = flag.String("git-ref", "", "read templates from this commit, tag or tree of the git repository in the current directory, or REPO#REF, instead of -dir; requires git, and -out, -out-template or -apply-cmd")
+	scopedValues               = flag.String("scoped-values", "", "name of per-directory values files, e.g. values.yaml: one applies to the templates in its directory and below, over those of parent directories")
+	normalize                  = flag.String("normalize", "", "comma-separated formats to reformat after rendering so output is stable across runs: json (sorted keys, two-space indent)")
+	headBytes                  = flag.String("head-bytes", "", "only render placeholders within the first N bytes of each file, e.g. 4096 or 64K; the rest is copied unread when nothing changes")
+	force                      = flag.Bool("force", false, "render even with delimiters that make -mode env or both dangerous, such as single characters or {{ }}")
+	runLock                    = flag.String("run-lock", "off", "lock -dir/"+runLockName+" for the run so overlapping runs do not race: wait | fail | off")
+	editorLocks                = flag.String("editor-locks", "skip", "files open in an editor (vim .swp, emacs .#, LibreOffice, kate and Office lock files, advisory locks): skip (with a warning) | fail | ignore")
+	hardLinks                  = flag.String("hard-links", "once", "files with several hard links rewritten in place: once (render the shared file for the first path) | break (render every path into its own file)")
+	fixtureSize                = flag.String("size", "64K", "gen-fixtures: approximate size of each file, e.g. 4096, 64K or 1M")
+	fixtureKeys                = flag.Int("keys", 100, "gen-fixtures: number of distinct keys")
+	fixtureSeed                = flag.Int64("seed", 42, "gen-fixtures: random seed; the same seed writes the same tree")
+	fixtureFiles               = flag.Int("files", 1, "gen-fixtures: number of files to write")
+	keyDocs                    = flag.Bool("docs", false, "list-keys: write a variables reference from the @doc comments of the templates")
+	resolvedConfig             = flag.Bool("resolved", false, "config print: list every setting, defaults included, and every key with its source")
+	showValues                 = flag.Bool("show-values", false, "config print -resolved: also print the value of every key; values that look like secrets by name or content stay redacted")
+	docsFormat                 = flag.String("docs-format", "markdown", "list-keys -docs: output format, markdown | json")
+	graphFormat                = flag.String("graph-format", "dot", "graph: output format, dot | json")
+	tracePath                  = flag.String("trace-file", "", "print every delimiter found in this file, the key parsed, the source of its value and whether it is replaced")
+	recordPath                 = flag.String("record", "", "archive the templates, rendering flags and redacted values of this run as a tar for -replay")
+	replayPath                 = flag.String("replay", "", "render the tree of a -record archive in a temporary directory, with its flags and redacted values")
+	lockPath                   = flag.String("lock", "charmap.lock", "file the lock command pins value hashes in, and -frozen checks against")
+	frozen                     = flag.Bool("frozen", false, "fail before rendering if any value differs from its hash in the -lock file")
+	valuesLock                 = flag.String("values-lock", "", "render with only the values of this snapshot-values file, ignoring the environment")
+	checksumsPath              = flag.String("checksums", "", "write a sha256sum file of every rendered file; the check command verifies it instead")
+	changedExitCode            = flag.Int("changed-exit-code", 0, "exit with this code instead of 0 when any file was written (0 disables)")
+	signSpec                   = flag.String("sign", "", "sign the -manifest: minisign:SECRET-KEY or cosign:KEY (cosign: alone for keyless)")
+	userKV           StringMap = make(StringMap)
 )
 
 func init() {
 	flag.Var(&inc, "include", "regex for files to process (default: .*\\.ya?ml$)")
 	flag.Var(&ign, "ignore", "regex for files/dirs to skip (default: ^\\.git(/|$))")
+	flag.Var(&ignContent, "ignore-content", "regex for file contents to skip, e.g. '(?m)^# charmap: ignore-file$' (may be repeated)")
 	flag.Var(&userKV, "set", "override in KEY=value form (may be repeated)")
+	flag.Var(&valueFiles, "values", "JSON, flat YAML or KEY=value file of values (may be repeated)")
+	flag.Var(&profileSpecs, "profile", "NAME=FILE values file rendered into -out-template (may be repeated)")
+	flag.Var(&extDelimSpecs, "ext-delims", "\".EXT=OPEN CLOSE\" delimiters for files with that extension, e.g. '.md={{ }}' (may be repeated)")
+	flag.Var(&opaqueSpecs, "opaque", "\"OPEN CLOSE\" regions left untouched, e.g. '{{ }}' for Helm (may be repeated)")
+	flag.Var(&sourceSpecs, "source", "extra value source: k8s-downward reads the pod's downward API into pod.* keys (may be repeated)")
+	flag.Var(&orderSpecs, "order", "regex of files rendered, and piped to -apply-cmd, before files matching later patterns or none (may be repeated)")
+	flag.Var(&watchDirs, "watch", "sidecar: directory, e.g. a mounted ConfigMap, whose changes trigger a new render (may be repeated)")
+	flag.Var(&filterFiles, "filters", "Starlark file defining placeholder filters (may be repeated)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), `
 charmap scans every regular file under -dir (default ".") and replaces
 instances of %sKEY%s. Anchors are configurable with -open and -close flags.
+With -out, rendered files are written to the same relative paths under that
+directory and the templates are left untouched. With -apply-cmd, each rendered
+file is piped into a shell command instead and nothing is written.
 
 charmap can read key values from environment variables, command line flags,
 or both. Use -mode to select the source:
   -mode env   : read from environment variables only (will read all env vars)
   -mode flag  : read from command line flags only (faster)
   -mode both  : read from both environment variables and command line flags
+Files given with -values ("-" for stdin) are read in every mode; they override
+environment variables and are overridden by -set.
+
+Placeholders may pipe their value through filters, e.g. %sKEY | upper%s.
+Filters are top-level functions loaded from Starlark files with -filters.
+
+Blocks can be kept or dropped with #if/#elif/#else/#end directives:
+  %s#if eq(ENV, "prod") && has(REPLICAS)%s ... %s#else%s ... %s#end%s
+Conditions support eq, ne, has, empty, contains, !, && and ||.
+
+A "# charmap delims: [[ ]]" line switches delimiters for the rest of a file.
+Regions enclosed by an -opaque pair, e.g. -opaque '{{ }}' for Helm charts, are
+never substituted nor reported as missing.
+
+Commands:
+  charmap [flags]              rewrite matching files under -dir in place
+  charmap render [flags] FILE  render a single file to stdout, writing nothing
+  charmap render -i IN -o OUT  render IN ("-" for stdin) to OUT, without walking -dir
+  charmap diff -out DIR        compare fresh renders with files previously written to DIR
+  charmap test -golden DIR     compare renders with golden outputs (-update rewrites them)
+  charmap bench [flags]        time every engine and worker count on -dir, writing nothing
+  charmap check [flags]        list every placeholder without a value, with its line
+                               (-summary adds the files and placeholders per key)
+  charmap rewrite [flags]      replace -from by -to in the templates, or rename the key
+                               when both are placeholders (-dry-run prints a diff)
+  charmap doctor [flags]       check tools, output permissions and delimiters on a
+                               sample of -dir, printing a hint for every problem
+  charmap snapshot-values -o F write the values of every key referenced under -dir to F,
+                               for later runs with -values-lock F
+  charmap lock [flags]         pin the SHA-256 of every referenced value in -lock, which
+                               -frozen runs check before rendering
+  charmap sidecar -watch DIR    render, then render again whenever DIR (a mounted ConfigMap
+                               or Secret) changes, sending SIGHUP to -signal-pid
+  charmap graph [flags]        print which keys every template uses and where their values
+                               come from, as DOT or JSON (-graph-format)
+  charmap list-keys [flags]    print every key the templates reference; -docs writes a
+                               variables reference from their @doc comments instead
+  charmap verify -out DIR      render in memory and report files under DIR that drifted from
+                               their sources since they were written (-manifest tells
+                               files edited after rendering apart)
+  charmap secrets [flags]      list keys whose values look like credentials and would be
+                               written into a git, hg or svn checkout (advisory)
+  charmap history -history F   print the runs recorded in F: when, with which config hash,
+                               how many files changed and the -manifest written
+  charmap gen-fixtures -out DIR write -files templates of -size bytes using -keys keys,
+                               and their values.env, the same for the same -seed
+  charmap config validate      load the flags and -config file, compile every pattern
+                               and walk -dir, reporting problems without writing
+  charmap config print         print every flag that was set and where: command line,
+                               -config file or -replay (-resolved adds defaults and keys,
+                               -show-values the values of keys)
 
 Example:
   preprocess -set PUBLIC_DOMAIN=example.com -mode=both
 
 Flags:
-`, *openDelim, *closeDelim)
+`, *openDelim, *closeDelim, *openDelim, *closeDelim,
+			*openDelim, *closeDelim, *openDelim, *closeDelim, *openDelim, *closeDelim)
 		flag.PrintDefaults()
 	}
 }
 
 type config struct {
-	OpenDelim  string
-	CloseDelim string
-	TargetDir  string
-	Workers    int
-	Mode       string
-	LogFile    string
-	CloseLog   func()
-	FileFilter *fileFilter
-	KeyMap     StringMap
+	OpenDelim     string
+	CloseDelim    string
+	TargetDir     string
+	OutDir        string
+	GoldenDir     string
+	Update        bool
+	Workers       int
+	Mode          string
+	Force         bool
+	LogFile       string
+	CloseLog      func()
+	Errors        *errorStream
+	WatchDirs     []string
+	WatchInterval time.Duration
+	SignalPID     int
+	MetricsFile   string
+	// LoadValues reads the value sources again and returns the new KeyMap
+	// and the Profiles built over it.
+	LoadValues      func() (StringMap, []profile, error)
+	FileFilter      *fileFilter
+	KeyMap          StringMap
+	Filters         filterMap
+	Opaque          [][2]string
+	ExtDelims       extDelims
+	TemplatePath    []string
+	SecretsDir      string
+	MissingMarker   string
+	Encrypter       *encrypter
+	ApplyCmd        string
+	AllowOutside    bool
+	ReadableSecrets bool
+	ManifestPath    string
+	HistoryPath     string
+	ChangedKeys     []string
+	ChecksumsPath   string
+	Header          bool
+	Normalize       []string
+	KeySummary      bool
+	RewriteFrom     string
+	RewriteTo       string
+	DryRun          bool
+	RenderIn        string
+	RenderOut       string
+	SignSpec        string
+	Sources         []valueSource
+	Denied          map[string]bool
+	FoldCase        bool
+	ChunkSize       int
+	ChangedExitCode int
+	QueueDepth      int
+	Order           orderGroups
+	Engine          string
+	Stage           int
+	Limits          valueLimits
+	Budget          runBudget
+	LockPath        string
+	GraphFormat     string
+	KeyDocs         bool
+	ResolvedConfig  bool
+	ShowValues      bool
+	DocsFormat      string
+	Fixtures        fixtureSpec
+	RecordPath      string
+	TracePath       string
+	OnMutation      string
+	EditorLocks     string
+	RunLock         string
+	HardLinks       string
+	Frozen          bool
+	Syntax          string
+	StrictCount     bool
+	OutPath         *outPathTemplate
+	Allowed         map[string]bool
+	// FlagOrigins maps each flag that was set to where it was set: the
+	// command line, the -config file or the -replay recording.
+	FlagOrigins map[string]string
+	// Origins maps each key of KeyMap to its source, see buildKeyMapWithOrigins.
+	Origins  map[string]string
+	Profiles []profile
+	OutTmpl  string
+	// OutTar is the -out-tar archive files are written to instead of -out.
+	OutTar string
+	// ValuesLock is the -values-lock snapshot, the only source of values.
+	ValuesLock string
+	// InvalidUTF8 is the -invalid-utf8 policy, see decode.
+	InvalidUTF8 string
+	// HeadBytes limits rendering to the first bytes of each file, see
+	// readHead. Zero renders whole files.
+	HeadBytes int
+	// ScopedValues is the name of the -scoped-values files, empty for none.
+	ScopedValues string
+	// Scopes caches the scoped values files for one run. processTree sets
+	// it afresh every run, so a watch sees edits to them.
+	Scopes *valueScopes
+	// Input is the -from-archive archive or -git-ref tree the templates are
+	// read from, nil for the disk, see inputSource.
+	Input inputSource
+}
+
+func (c config) replacerOptions() replacerOptions {
+	return replacerOptions{Filters: c.Filters, Opaque: c.Opaque, Denied: c.Denied, Allowed: c.Allowed, ChunkSize: c.ChunkSize, Engine: c.Engine, Stage: c.Stage, Limits: c.Limits, Syntax: c.Syntax, StrictCount: c.StrictCount, SecretsDir: c.SecretsDir, MissingMarker: c.MissingMarker, ReadRoots: append([]string{c.TargetDir}, c.TemplatePath...), AllowOutside: c.AllowOutside, EnvOff: c.envOff()}
+}
+
+// envOff names the setting of c that keeps env() placeholders from reading
+// the environment, or returns "".
+func (c config) envOff() string {
+	switch {
+	case c.ValuesLock != "":
+		return "-values-lock"
+	case c.Mode == "flag":
+		return "-mode flag"
+	}
+	return ""
 }
 
-func parseConfig() (config, error) {
-	flag.Parse()
+// profile is a named key set rendered into its own output directory, see
+// -profile and -out-template.
+type profile struct {
+	Name    string
+	KeyMap  StringMap
+	Origins map[string]string
+}
 
-	values := make(map[string]string)
+func parseConfig(args []string) (config, error) {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return config{}, err
+	}
+	flagOrigins := make(map[string]string)
+	noteFlagOrigins(flag.CommandLine, flagOrigins, "command line")
+	if *configPath != "" {
+		if err := loadConfigFile(flag.CommandLine, *configPath); err != nil {
+			return config{}, fmt.Errorf("config: %w", err)
+		}
+		noteFlagOrigins(flag.CommandLine, flagOrigins, "config file "+*configPath)
+	}
+	if *replayPath != "" {
+		if *recordPath != "" {
+			return config{}, fmt.Errorf("-record and -replay are mutually exclusive")
+		}
+		if err := applyRecording(*replayPath); err != nil {
+			return config{}, fmt.Errorf("replay: %w", err)
+		}
+		noteFlagOrigins(flag.CommandLine, flagOrigins, "replay "+*replayPath)
+	}
+
+	var useEnv, useFlags bool
 	switch *mode {
 	case "env":
-		for _, kv := range os.Environ() {
-			if idx := strings.IndexByte(kv, '='); idx != -1 {
-				values[kv[:idx]] = kv[idx+1:]
+		useEnv = true
+	case "flag":
+		useFlags = true
+	case "both":
+		useEnv, useFlags = true, true
+	default:
+		return config{}, fmt.Errorf("invalid mode %q, must be one of: env, flag, both", *mode)
+	}
+
+	// A -values-lock snapshot is the only source of values: the environment
+	// and -set are ignored so that renders are reproducible.
+	files := valueFiles
+	if *valuesLock != "" {
+		if len(valueFiles) > 0 || len(userKV) > 0 {
+			return config{}, fmt.Errorf("-values-lock cannot be combined with -values or -set")
+		}
+		if len(profileSpecs) > 0 {
+			return config{}, fmt.Errorf("-values-lock cannot be combined with -profile, a snapshot holds one set of values")
+		}
+		files, useEnv, useFlags = nil, false, false
+	}
+
+	var builtins map[string]string
+	if *useBuiltins {
+		b, err := builtinValues()
+		if err != nil {
+			return config{}, err
+		}
+		builtins = b
+	}
+	var downward bool
+	for _, s := range sourceSpecs {
+		if s != "k8s-downward" {
+			return config{}, fmt.Errorf("invalid -source %q, must be k8s-downward", s)
+		}
+		downward = true
+	}
+	buildValues := func(files []string) (map[string]string, map[string]string, error) {
+		var values, origins map[string]string
+		var err error
+		if *valuesLock != "" {
+			values, origins, err = loadValuesLock(*valuesLock)
+		} else {
+			values, origins, err = buildKeyMapWithOrigins(useEnv, useFlags, files, userKV)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if *expandJSON {
+			expandJSONEnv(values, origins)
+		}
+		if downward {
+			pod, err := downwardValues(*downwardDir)
+			if err != nil {
+				return nil, nil, err
 			}
+			addFallback(values, origins, pod, "k8s-downward")
 		}
-	case "flag":
-		for k, v := range userKV {
-			values[k] = v
+		addFallback(values, origins, builtins, "builtin")
+		return values, origins, nil
+	}
+	values, origins, err := buildValues(files)
+	if err != nil {
+		return config{}, err
+	}
+
+	var allowed, denied map[string]bool
+	if *allowKeysFile != "" {
+		if allowed, err = loadAllowList(*allowKeysFile); err != nil {
+			return config{}, fmt.Errorf("failed to load allow-list %q: %w", *allowKeysFile, err)
 		}
-	case "both":
-		for _, kv := range os.Environ() {
-			if idx := strings.IndexByte(kv, '='); idx != -1 {
-				values[kv[:idx]] = kv[idx+1:]
+		denied = make(map[string]bool)
+		restrictKeys(values, allowed, denied)
+	}
+
+	var profileFiles []string
+	for _, p := range profileSpecs {
+		name, file, ok := strings.Cut(p, "=")
+		if !ok || name == "" || file == "" {
+			return config{}, fmt.Errorf("invalid profile %q, expected format NAME=FILE", p)
+		}
+		if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return config{}, fmt.Errorf("invalid profile name %q, must not be a path", name)
+		}
+		profileFiles = append(profileFiles, file)
+	}
+	buildProfiles := func() ([]profile, error) {
+		var profiles []profile
+		for i, p := range profileSpecs {
+			name, file := strings.SplitN(p, "=", 2)[0], profileFiles[i]
+			// Profile values layer over -values files but stay below -set.
+			pv, po, err := buildValues(append(files[:len(files):len(files)], file))
+			if err != nil {
+				return nil, fmt.Errorf("profile %q: %w", name, err)
+			}
+			for k, o := range po {
+				if o == "values:"+file {
+					po[k] = "profile:" + file
+				}
+			}
+			if allowed != nil {
+				restrictKeys(pv, allowed, denied)
 			}
+			profiles = append(profiles, profile{Name: name, KeyMap: pv, Origins: po})
 		}
-		for k, v := range userKV {
-			values[k] = v
+		return profiles, nil
+	}
+	profiles, err := buildProfiles()
+	if err != nil {
+		return config{}, err
+	}
+
+	loadValues := func() (StringMap, []profile, error) {
+		v, _, err := buildValues(files)
+		if err != nil {
+			return nil, nil, err
 		}
-	default:
-		return config{}, fmt.Errorf("invalid mode %q, must be one of: env, flag, both", *mode)
+		if allowed != nil {
+			restrictKeys(v, allowed, denied)
+		}
+		p, err := buildProfiles()
+		return v, p, err
+	}
+	if len(profiles) > 0 && !strings.Contains(*outTemplate, "{env}") {
+		return config{}, fmt.Errorf("-profile requires -out-template containing {env}")
+	}
+	if len(profiles) == 0 && *outTemplate != "" {
+		return config{}, fmt.Errorf("-out-template requires at least one -profile")
+	}
+	if *outTemplate != "" && *outDir != "" {
+		return config{}, fmt.Errorf("-out and -out-template are mutually exclusive")
 	}
 
 	if len(*openDelim) == 0 || len(*closeDelim) == 0 {
 		return config{}, fmt.Errorf("delimiters must not be empty")
 	}
+	engine, nWorkers, err := resolveEngine(*engineName, *workers)
+	if err != nil {
+		return config{}, err
+	}
+	var outPath *outPathTemplate
+	if *outPathSpec != "" {
+		if *outDir == "" && *outTemplate == "" {
+			return config{}, fmt.Errorf("-out-path requires -out or -out-template")
+		}
+		if outPath, err = parseOutPath(*outPathSpec); err != nil {
+			return config{}, err
+		}
+	}
+	if *changedExitCode < 0 || *changedExitCode == 1 || *changedExitCode > 125 {
+		return config{}, fmt.Errorf("changed-exit-code must be 0 (off) or between 2 and 125, got %d", *changedExitCode)
+	}
+	if *queueDepth <= 0 {
+		return config{}, fmt.Errorf("queue-depth must be greater than 0, got %d", *queueDepth)
+	}
+	if *maxValueBytes < 0 || *maxValueLines < 0 {
+		return config{}, fmt.Errorf("max-value-bytes and max-value-lines must not be negative")
+	}
+	if *runLock != "wait" && *runLock != "fail" && *runLock != "off" {
+		return config{}, fmt.Errorf("invalid -run-lock %q, must be wait, fail or off", *runLock)
+	}
+	if *editorLocks != "skip" && *editorLocks != "fail" && *editorLocks != "ignore" {
+		return config{}, fmt.Errorf("invalid -editor-locks %q, must be skip, fail or ignore", *editorLocks)
+	}
+	if *onMutation != "fail" && *onMutation != "skip" && *onMutation != "retry" {
+		return config{}, fmt.Errorf("invalid -on-mutation %q, must be fail, skip or retry", *onMutation)
+	}
+	if *hardLinks != "once" && *hardLinks != "break" {
+		return config{}, fmt.Errorf("invalid -hard-links %q, must be once or break", *hardLinks)
+	}
+	if *docsFormat != "markdown" && *docsFormat != "json" {
+		return config{}, fmt.Errorf("invalid -docs-format %q, must be markdown or json", *docsFormat)
+	}
+	if *graphFormat != "dot" && *graphFormat != "json" {
+		return config{}, fmt.Errorf("invalid -graph-format %q, must be dot or json", *graphFormat)
+	}
+	size, err := parseByteSize(*fixtureSize)
+	if err != nil {
+		return config{}, err
+	}
+	fixtures := fixtureSpec{Size: size, Keys: *fixtureKeys, Seed: *fixtureSeed, Files: *fixtureFiles}
+	if fixtures.Keys <= 0 || fixtures.Files <= 0 {
+		return config{}, fmt.Errorf("keys and files must be greater than 0")
+	}
+	if *watchInterval <= 0 {
+		return config{}, fmt.Errorf("watch-interval must be positive, got %v", *watchInterval)
+	}
+	if *maxFiles < 0 || *maxTotalBytes < 0 {
+		return config{}, fmt.Errorf("max-files and max-total-bytes must not be negative")
+	}
+	if *valueLimitPolicy != "error" && *valueLimitPolicy != "warn" {
+		return config{}, fmt.Errorf("invalid -value-limit-policy %q, must be error or warn", *valueLimitPolicy)
+	}
+	if _, ok := syntaxModes[*syntaxName]; !ok {
+		return config{}, fmt.Errorf("invalid -syntax %q, must be plain, xml or ini", *syntaxName)
+	}
+	if *stage < 0 {
+		return config{}, fmt.Errorf("stage must not be negative, got %d", *stage)
+	}
+	if *chunkSize < 0 {
+		return config{}, fmt.Errorf("chunk-size must not be negative, got %d", *chunkSize)
+	}
 	if *workers <= 0 {
 		return config{}, fmt.Errorf("workers must be greater than 0, got %d", *workers)
 	}
 	if *targetDir == "" {
 		return config{}, fmt.Errorf("target directory must not be empty")
 	}
-	if _, err := os.Stat(*targetDir); os.IsNotExist(err) {
+	if fi, err := os.Stat(*targetDir); os.IsNotExist(err) {
 		return config{}, fmt.Errorf("target directory %q does not exist", *targetDir)
-	}
-	if fi, err := os.Stat(*targetDir); err != nil || !fi.IsDir() {
+	} else if err != nil || !fi.IsDir() {
 		return config{}, fmt.Errorf("target %q is not a directory", *targetDir)
 	}
 
-	fileFilter, err := newFileFilter(inc, ign)
+	foldCase, err := caseInsensitiveFS(*fsCase)
+	if err != nil {
+		return config{}, err
+	}
+	incPats, ignPats := []string(inc), []string(ign)
+	if foldCase {
+		incPats, ignPats = foldPatterns(incPats), foldPatterns(ignPats)
+	}
+	fileFilter, err := newFileFilter(incPats, ignPats)
 	if err != nil {
 		return config{}, fmt.Errorf("failed to create file filter: %w", err)
 	}
+	if fileFilter.contents, err = compileAll(ignContent); err != nil {
+		return config{}, fmt.Errorf("failed to create file filter: ignore-content: %w", err)
+	}
+	fileFilter.vendored = *skipVendored
+	if *invalidUTF8 != "pass" && *invalidUTF8 != "replace" && *invalidUTF8 != "skip" && *invalidUTF8 != "fail" {
+		return config{}, fmt.Errorf("invalid -invalid-utf8 %q, must be pass, replace, skip or fail", *invalidUTF8)
+	}
+	var input inputSource
+	if *fromArchive != "" || *gitRef != "" {
+		source := "-from-archive"
+		if *gitRef != "" {
+			source = "-git-ref"
+		}
+		_, dirSet := flagOrigins["dir"]
+		switch {
+		case *fromArchive != "" && *gitRef != "":
+			return config{}, fmt.Errorf("-from-archive and -git-ref are mutually exclusive")
+		case dirSet:
+			return config{}, fmt.Errorf("%s and -dir are mutually exclusive", source)
+		case *outDir == "" && *outTemplate == "" && *applyCmd == "":
+			return config{}, fmt.Errorf("%s requires -out, -out-template or -apply-cmd, its templates cannot be rendered in place", source)
+		case *runLock != "off":
+			return config{}, fmt.Errorf("%s cannot be combined with -run-lock, which locks -dir", source)
+		}
+		var fsys fs.FS
+		if *gitRef != "" {
+			fsys, err = gitTreeFS(*gitRef)
+		} else {
+			fsys, err = openArchive(*fromArchive)
+		}
+		if err != nil {
+			return config{}, fmt.Errorf("%s: %w", source, err)
+		}
+		input = fsSource{fsys}
+	}
+	if *scopedValues != "" && filepath.Base(*scopedValues) != *scopedValues {
+		return config{}, fmt.Errorf("invalid -scoped-values %q, must be a file name without a directory", *scopedValues)
+	}
+	var headLimit int
+	if *headBytes != "" {
+		if headLimit, err = parseByteSize(*headBytes); err != nil {
+			return config{}, fmt.Errorf("invalid -head-bytes: %w", err)
+		}
+	}
+	order, err := compileAll(orderSpecs)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid -order: %w", err)
+	}
+
+	if *applyCmd != "" && (*outDir != "" || *outTemplate != "" || *encryptSpec != "") {
+		return config{}, fmt.Errorf("-apply-cmd cannot be combined with -out, -out-template or -encrypt")
+	}
+	if *dryRun && *recordPath != "" {
+		return config{}, fmt.Errorf("-dry-run cannot be combined with -record, which writes an archive")
+	}
+	if *dryRun && *errorsFile != "" {
+		return config{}, fmt.Errorf("-dry-run cannot be combined with -errors-file, its errors go to stderr")
+	}
+
+	var formats []string
+	for _, f := range strings.Split(*normalize, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		if _, ok := normalizers[f]; !ok {
+			return config{}, fmt.Errorf("invalid -normalize format %q, must be json", f)
+		}
+		formats = append(formats, f)
+	}
+
+	var changed []string
+	if *changedKeys != "" {
+		for _, k := range strings.Split(*changedKeys, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				changed = append(changed, k)
+			}
+		}
+		switch {
+		case *manifestPath == "":
+			return config{}, fmt.Errorf("-changed-keys requires -manifest, whose file index it reads and updates")
+		case *outDir == "" && *outTemplate == "" && *applyCmd == "":
+			return config{}, fmt.Errorf("-changed-keys requires -out, -out-template or -apply-cmd, files rendered in place have no placeholders left")
+		case *checksumsPath != "":
+			return config{}, fmt.Errorf("-changed-keys cannot be combined with -checksums, which would list the rendered files only")
+		}
+	}
+	if *signSpec != "" && *manifestPath == "" {
+		return config{}, fmt.Errorf("-sign requires -manifest")
+	}
+	if tool, _, _ := strings.Cut(*signSpec, ":"); *signSpec != "" && tool != "minisign" && tool != "cosign" {
+		return config{}, fmt.Errorf("invalid -sign %q, must be minisign:KEY or cosign[:KEY]", *signSpec)
+	}
+	var sources []valueSource
+	if *manifestPath != "" {
+		if sources, err = describeSources(useEnv, useFlags && len(userKV) > 0, files, profileFiles, filterFiles); err != nil {
+			return config{}, err
+		}
+	}
+
+	if *renderIn == "-" && slices.Contains(valueFiles, "-") {
+		return config{}, fmt.Errorf("-i - and -values - cannot both read standard input")
+	}
+	if *header && *outDir == "" && *outTemplate == "" {
+		return config{}, fmt.Errorf("-header requires -out or -out-template")
+	}
+	if *outTar != "" {
+		switch {
+		case *outDir == "" && *outTemplate == "":
+			return config{}, fmt.Errorf("-out-tar requires -out or -out-template, which name the files in the archive")
+		case *changedKeys != "":
+			return config{}, fmt.Errorf("-out-tar cannot be combined with -changed-keys, the archive would hold the re-rendered files only")
+		}
+	}
+
+	var enc *encrypter
+	if *encryptSpec != "" {
+		if *outDir == "" && *outTemplate == "" {
+			return config{}, fmt.Errorf("-encrypt requires -out or -out-template")
+		}
+		if enc, err = newEncrypter(*encryptSpec); err != nil {
+			return config{}, err
+		}
+	}
+
+	var opaque [][2]string
+	for _, spec := range opaqueSpecs {
+		pair := strings.Fields(spec)
+		if len(pair) != 2 {
+			return config{}, fmt.Errorf("invalid -opaque %q, expected format \"OPEN CLOSE\"", spec)
+		}
+		if pair[0] == *openDelim || pair[1] == *closeDelim {
+			return config{}, fmt.Errorf("-opaque %q must differ from the -open/-close delimiters", spec)
+		}
+		opaque = append(opaque, [2]string{pair[0], pair[1]})
+	}
+
+	extDelims, err := parseExtDelims(extDelimSpecs)
+	if err != nil {
+		return config{}, err
+	}
+
+	filters, err := loadStarlarkFilters(filterFiles)
+	if err != nil {
+		return config{}, fmt.Errorf("failed to load filters: %w", err)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return config{}, fmt.Errorf("invalid -log-level %q, must be debug, info, warn or error", *logLevel)
+	}
 
 	closer := func() {}
 	slog.SetDefault(slog.New(discardHandler{}))
@@ -126,26 +746,133 @@ func parseConfig() (config, error) {
 		}
 
 		slog.SetDefault(slog.New(slog.NewTextHandler(f, &slog.HandlerOptions{
-			Level: slog.LevelInfo,
+			Level: level,
 		})))
 	}
 
+	var errStream *errorStream
+	switch *errorsFormat {
+	case "text":
+	case "ndjson":
+		w := io.Writer(os.Stderr)
+		if *errorsFile != "" {
+			f := &lazyFile{path: *errorsFile}
+			prev := closer
+			closer = func() {
+				prev()
+				f.Close()
+			}
+			w = f
+		}
+		errStream = newErrorStream(w)
+	default:
+		return config{}, fmt.Errorf("invalid -errors-format %q, must be text or ndjson", *errorsFormat)
+	}
+
 	cfg := config{
-		OpenDelim:  *openDelim,
-		CloseDelim: *closeDelim,
-		TargetDir:  *targetDir,
-		Workers:    *workers,
-		Mode:       *mode,
-		LogFile:    *logFile,
-		CloseLog:   closer,
-		FileFilter: fileFilter,
-		KeyMap:     values,
+		OpenDelim:       *openDelim,
+		CloseDelim:      *closeDelim,
+		TargetDir:       *targetDir,
+		OutDir:          *outDir,
+		GoldenDir:       *goldenDir,
+		Update:          *updateGolden,
+		Workers:         nWorkers,
+		Mode:            *mode,
+		Force:           *force,
+		LogFile:         *logFile,
+		CloseLog:        closer,
+		Errors:          errStream,
+		WatchDirs:       watchDirs,
+		WatchInterval:   *watchInterval,
+		SignalPID:       *signalPID,
+		MetricsFile:     *metricsFile,
+		LoadValues:      loadValues,
+		FileFilter:      fileFilter,
+		KeyMap:          values,
+		Filters:         filters,
+		Opaque:          opaque,
+		ExtDelims:       extDelims,
+		TemplatePath:    filepath.SplitList(*templatePath),
+		SecretsDir:      *secretsDir,
+		MissingMarker:   *missingMarker,
+		Encrypter:       enc,
+		ApplyCmd:        *applyCmd,
+		AllowOutside:    *allowOutside,
+		ReadableSecrets: *readableSecrets,
+		ManifestPath:    *manifestPath,
+		HistoryPath:     *historyPath,
+		ChangedKeys:     changed,
+		ChecksumsPath:   *checksumsPath,
+		Header:          *header,
+		Normalize:       formats,
+		KeySummary:      *keySummary,
+		RewriteFrom:     *rewriteFrom,
+		RewriteTo:       *rewriteTo,
+		DryRun:          *dryRun,
+		RenderIn:        *renderIn,
+		RenderOut:       *renderOut,
+		SignSpec:        *signSpec,
+		Sources:         sources,
+		Denied:          denied,
+		FoldCase:        foldCase,
+		ChunkSize:       *chunkSize,
+		ChangedExitCode: *changedExitCode,
+		QueueDepth:      *queueDepth,
+		Order:           order,
+		Engine:          engine,
+		Stage:           *stage,
+		Syntax:          *syntaxName,
+		StrictCount:     *strictCount,
+		Limits:          valueLimits{MaxBytes: *maxValueBytes, MaxLines: *maxValueLines, Warn: *valueLimitPolicy == "warn"},
+		Budget:          runBudget{MaxFiles: *maxFiles, MaxBytes: *maxTotalBytes},
+		LockPath:        *lockPath,
+		GraphFormat:     *graphFormat,
+		KeyDocs:         *keyDocs,
+		ResolvedConfig:  *resolvedConfig,
+		ShowValues:      *showValues,
+		DocsFormat:      *docsFormat,
+		Fixtures:        fixtures,
+		RecordPath:      *recordPath,
+		TracePath:       *tracePath,
+		OnMutation:      *onMutation,
+		EditorLocks:     *editorLocks,
+		RunLock:         *runLock,
+		HardLinks:       *hardLinks,
+		Frozen:          *frozen,
+		OutPath:         outPath,
+		Allowed:         allowed,
+		Origins:         origins,
+		FlagOrigins:     flagOrigins,
+		Profiles:        profiles,
+		OutTmpl:         *outTemplate,
+		OutTar:          *outTar,
+		ValuesLock:      *valuesLock,
+		InvalidUTF8:     *invalidUTF8,
+		HeadBytes:       headLimit,
+		ScopedValues:    *scopedValues,
+		Input:           input,
 	}
 	return cfg, nil
 }
 
 func main() {
-	cfg, err := parseConfig()
+	args := os.Args[1:]
+	var cmd command
+	if len(args) > 1 {
+		if c, ok := commands[args[0]+" "+args[1]]; ok {
+			cmd, args = c, args[2:]
+		}
+	}
+	if len(args) > 0 && cmd == nil {
+		if c, ok := commands[args[0]]; ok {
+			cmd, args = c, args[1:]
+		}
+	}
+
+	cfg, err := parseConfig(args)
+	if err == nil && cmd != nil && cfg.Input != nil {
+		err = fmt.Errorf("-from-archive and -git-ref only apply to a run, commands read -dir")
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "ERROR:", err)
 		os.Exit(1)
@@ -154,17 +881,64 @@ func main() {
 
 	slog.Info("charmap started",
 		slog.String("dir", cfg.TargetDir),
+		slog.String("out", cfg.OutDir),
 		slog.Int("workers", cfg.Workers),
 		slog.String("mode", cfg.Mode),
 		slog.String("open", cfg.OpenDelim),
 		slog.String("close", cfg.CloseDelim),
 		slog.String("logfile", cfg.LogFile),
-		slog.String("values", cfg.KeyMap.String()),
+		slog.Int("keys", len(cfg.KeyMap)),
 		slog.String("include", inc.String()),
 		slog.String("ignore", ign.String()),
+		slog.String("filters", filterFiles.String()),
+		slog.String("opaque", opaqueSpecs.String()),
+		slog.String("value_files", valueFiles.String()),
 	)
 
-	err = processFiles(cfg)
+	logKeyOrigins(cfg)
+
+	if cfg.TracePath != "" {
+		if err := traceFile(cfg, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR: failed to trace:", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.RecordPath != "" {
+		if err := writeRecording(cfg.RecordPath, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR: failed to record run:", err)
+			os.Exit(1)
+		}
+	}
+
+	stopProfile := func() {}
+	if *cpuProfile != "" {
+		if stopProfile, err = startCPUProfile(*cpuProfile); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			os.Exit(1)
+		}
+	}
+
+	if cmd != nil {
+		err = cmd(cfg, flag.Args())
+	} else {
+		var results []fileResult
+		results, err = processTree(cfg)
+		if cfg.DryRun {
+			if rerr := writeDryRunReport(os.Stdout, results); rerr != nil && err == nil {
+				err = rerr
+			}
+		} else if cfg.HistoryPath != "" {
+			if herr := appendHistory(cfg, results, err); herr != nil {
+				slog.Warn("failed to append to history", slog.String("path", cfg.HistoryPath), slog.Any("error", herr))
+			}
+		}
+		if err == nil && cfg.ChangedExitCode != 0 && anyWritten(results) {
+			stopProfile()
+			os.Exit(cfg.ChangedExitCode)
+		}
+	}
+	stopProfile()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "ERROR:", err)
 		os.Exit(1)
@@ -172,11 +946,80 @@ func main() {
 }
 
 func processFiles(cfg config) error {
-	files := make(chan string, cfg.Workers*2)
+	_, err := processTree(cfg)
+	return err
+}
+
+// anyWritten reports whether any result wrote or delivered new content.
+func anyWritten(results []fileResult) bool {
+	for _, r := range results {
+		if r.Written {
+			return true
+		}
+	}
+	return false
+}
+
+// processTree renders every matching file for every target and returns one
+// result per file and target. Nothing is rendered when the tree is over
+// cfg.Budget or, with cfg.Frozen, when a value differs from the lock file.
+// Dangerous delimiters are refused without cfg.Force, see checkDelims.
+// With cfg.RunLock the whole run holds the run lock of -dir. With
+// cfg.ChangedKeys only the files the manifest index selects are rendered.
+// With cfg.DryRun nothing is written, not even the manifest or run lock.
+// Scoped values files are read once per run.
+func processTree(cfg config) ([]fileResult, error) {
+	if err := checkDelims(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.ScopedValues != "" {
+		cfg.Scopes = newValueScopes(cfg.input(), cfg.TargetDir, cfg.ScopedValues)
+	}
+	if (cfg.RunLock == "wait" || cfg.RunLock == "fail") && !cfg.DryRun {
+		release, err := acquireRunLock(cfg)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	if err := checkBudget(cfg, cfg.Budget); err != nil {
+		return nil, err
+	}
+	if cfg.Frozen {
+		if err := checkFrozen(cfg); err != nil {
+			return nil, err
+		}
+	}
+	var index *changedKeyIndex
+	if len(cfg.ChangedKeys) > 0 {
+		var err error
+		if index, err = loadChangedKeyIndex(cfg.ManifestPath, cfg.ChangedKeys); err != nil {
+			return nil, err
+		}
+	}
+	depth := cfg.QueueDepth
+	if depth <= 0 {
+		depth = cfg.Workers * 2
+	}
+	files := make(chan walkedFile, depth)
 	errs := []error{}
 	errLock := sync.Mutex{}
+	var results []fileResult
 
-	replacer := buildNewReplacer([]byte(cfg.OpenDelim), []byte(cfg.CloseDelim), cfg.KeyMap)
+	targets := renderTargets(cfg)
+	for i := range targets {
+		targets[i].dryRun = cfg.DryRun
+	}
+	var tarOut *tarSink
+	if cfg.OutTar != "" && !cfg.DryRun {
+		var err error
+		if tarOut, err = newTarSink(cfg.OutTar); err != nil {
+			return nil, err
+		}
+		for i := range targets {
+			targets[i].sink = tarOut
+		}
+	}
 
 	var wg sync.WaitGroup
 
@@ -184,120 +1027,933 @@ func processFiles(cfg config) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for path := range files {
-				err := processFile(path, replacer)
+			for f := range files {
+				path := f.path
+				res, err := processFile(cfg, path, f.info, targets)
+				errLock.Lock()
+				results = append(results, res...)
 				if err != nil {
-					errLock.Lock()
 					errs = append(errs, fmt.Errorf("failed to process %q: %w", path, err))
-					errLock.Unlock()
 					slog.Error("error processing file", slog.String("path", path), slog.Any("error", err))
+					cfg.Errors.report(path, err)
+				}
+				errLock.Unlock()
+				if f.group != nil {
+					f.group.Done()
 				}
 			}
 		}()
 	}
 
 	go func() {
-		err := filepath.WalkDir(cfg.TargetDir, func(p string, d fs.DirEntry, err error) error {
-			if err != nil || d.IsDir() {
-				return err
+		defer close(files)
+		// Paths are handed over in batches of the queue depth, largest first
+		// within each batch. Sending blocks while the queue is full, so the walk
+		// never holds more than two batches however large the tree.
+		paths := make([]string, 0, depth)
+		// Rewriting one hard link in place rewrites all of them, so unless
+		// -hard-links break gives every path its own file, an inode is
+		// rendered for the first path walked only.
+		inPlace := cfg.OutDir == "" && len(cfg.Profiles) == 0 && cfg.ApplyCmd == ""
+		linked := make(map[[2]uint64]string)
+		send := func(paths []string, group *sync.WaitGroup) {
+			for _, f := range largestFirst(paths, cfg.input()) {
+				if id, ok := hardLinkID(f.info); ok && inPlace && cfg.HardLinks != "break" {
+					if first, seen := linked[id]; seen {
+						slog.Info("skipping hard link to a file already rendered", slog.String("path", f.path), slog.String("first", first))
+						continue
+					}
+					linked[id] = f.path
+				}
+				if group != nil {
+					group.Add(1)
+					f.group = group
+				}
+				files <- f
 			}
-
-			if !cfg.FileFilter.match(p) {
-				slog.Debug("skipping file", slog.String("path", p))
+		}
+		// With -order the whole tree is walked first and each group is
+		// finished before the next one starts.
+		groups := make([][]string, len(cfg.Order)+1)
+		err := walkFiles(cfg, func(p string) error {
+			if index != nil && !index.selects(p) {
+				return nil
+			}
+			if len(cfg.Order) > 0 {
+				i := cfg.Order.group(p)
+				groups[i] = append(groups[i], p)
 				return nil
 			}
-			files <- p
+			if paths = append(paths, p); len(paths) == depth {
+				send(paths, nil)
+				paths = paths[:0]
+			}
 			return nil
 		})
 		if err != nil {
 			errLock.Lock()
 			errs = append(errs, fmt.Errorf("failed to walk directory %q: %w", cfg.TargetDir, err))
 			errLock.Unlock()
+			cfg.Errors.report(cfg.TargetDir, err)
+			return
+		}
+		if len(cfg.Order) == 0 {
+			send(paths, nil)
+			return
+		}
+		for i, g := range groups {
+			if len(g) == 0 {
+				continue
+			}
+			slog.Debug("rendering order group", slog.String("group", cfg.Order.name(i)), slog.Int("files", len(g)))
+			var group sync.WaitGroup
+			send(g, &group)
+			group.Wait()
+			errLock.Lock()
+			failed := len(errs) > 0
+			if failed {
+				var skipped int
+				for _, later := range groups[i+1:] {
+					skipped += len(later)
+				}
+				if skipped > 0 {
+					errs = append(errs, fmt.Errorf("%s failed, %d file(s) of later groups were not rendered", cfg.Order.name(i), skipped))
+				}
+			}
+			errLock.Unlock()
+			if failed {
+				return
+			}
 		}
-		close(files)
 	}()
 
 	wg.Wait()
 
+	if tarOut != nil {
+		if err := tarOut.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write %s: %w", cfg.OutTar, err))
+		}
+	}
 	if len(errs) > 0 {
-		return errors.Join(errs...)
+		return results, errors.Join(errs...)
 	}
-	return nil
+
+	if cfg.DryRun {
+		return results, nil
+	}
+	if cfg.ManifestPath != "" {
+		if err := writeManifest(cfg, results); err != nil {
+			return results, fmt.Errorf("failed to write manifest: %w", err)
+		}
+	}
+	if cfg.ChecksumsPath != "" {
+		if err := writeChecksums(cfg.ChecksumsPath, results); err != nil {
+			return results, fmt.Errorf("failed to write checksums: %w", err)
+		}
+	}
+	return results, nil
+}
+
+// walkedFile is a path found by the walk with the result of its stat, which is
+// handed on to processFile so that every file is statted once.
+type walkedFile struct {
+	path string
+	info fs.FileInfo // nil if the stat failed
+	// group is done once the file is processed, with -order.
+	group *sync.WaitGroup
+}
+
+// largestFirst stats paths in src and orders them by descending file size, so
+// that the biggest files start first instead of leaving most workers idle at
+// the end of the run.
+func largestFirst(paths []string, src inputSource) []walkedFile {
+	files := make([]walkedFile, len(paths))
+	for i, p := range paths {
+		files[i].path = p
+		if fi, err := src.stat(p); err == nil {
+			files[i].info = fi
+		}
+	}
+	size := func(f walkedFile) int64 {
+		if f.info == nil {
+			return 0
+		}
+		return f.info.Size()
+	}
+	sort.SliceStable(files, func(i, j int) bool { return size(files[i]) > size(files[j]) })
+	return files
+}
+
+// renderTarget is one rendering of the tree: a replacer and the directory its
+// results are written to (empty for in place).
+type renderTarget struct {
+	name     string
+	outDir   string
+	replacer countingReplacer
+	encrypt  *encrypter
+	applyCmd string
+	// outPath rewrites destinations below outDir, see -out-path.
+	outPath *outPathTemplate
+	// keyMap, open, close and opts rebuild the replacer for templates with
+	// a sidecar values file.
+	keyMap      map[string]string
+	open, close []byte
+	opts        replacerOptions
+	// extDelims and extReplacers replace open, close and replacer for files
+	// of an -ext-delims group, see forPath.
+	extDelims    extDelims
+	extReplacers map[string]countingReplacer
+	// allowOutside disables the check that writes stay inside outDir.
+	allowOutside bool
+	// readableSecrets allows secret() values in files group or others can
+	// read, see -readable-secrets.
+	readableSecrets bool
+	// header injects a provenance comment into files written to outDir.
+	header bool
+	// normalize lists the -normalize formats reformatted after rendering.
+	normalize []string
+	// sink receives the files written instead of the diskSink of output,
+	// for -out-tar.
+	sink outputSink
+	// dryRun renders without writing anything, see -dry-run.
+	dryRun bool
+	// onMutation is what happens to a file modified while it is rendered in
+	// place: fail, skip or retry.
+	onMutation string
+	// editorLocks is what happens to a file about to be written while an
+	// editor holds it open: skip, fail or ignore.
+	editorLocks string
+	// breakLinks writes a hard-linked file as a new file instead of through
+	// the shared inode, see -hard-links.
+	breakLinks bool
+	// hash records SHA-256 sums of inputs and outputs in each fileResult, for
+	// -manifest and -checksums.
+	hash bool
+}
+
+// fileResult describes one rendering of one file.
+type fileResult struct {
+	Path    string
+	Dest    string // empty when piped to -apply-cmd
+	Profile string
+	Changed bool
+	// Written is set when new content reached Dest or the -apply-cmd.
+	Written bool
+	// Member is the name Dest has in the -out-tar archive it was written to.
+	Member string
+	InSum  string
+	OutSum string
+	Keys   []string // keys referenced by the template, recorded with hash
+	// Replaced counts the placeholders per key a -dry-run would substitute.
+	Replaced map[string]int
+}
+
+// renderTargets returns one target per -profile, or a single target using the
+// merged key map when no profiles are configured.
+func renderTargets(cfg config) []renderTarget {
+	open, close := []byte(cfg.OpenDelim), []byte(cfg.CloseDelim)
+	opts := cfg.replacerOptions()
+	var targets []renderTarget
+	if len(cfg.Profiles) == 0 {
+		targets = []renderTarget{{
+			outDir:          cfg.OutDir,
+			replacer:        buildCountingReplacer(open, close, cfg.KeyMap, opts),
+			keyMap:          cfg.KeyMap,
+			open:            open,
+			close:           close,
+			opts:            opts,
+			encrypt:         cfg.Encrypter,
+			applyCmd:        cfg.ApplyCmd,
+			allowOutside:    cfg.AllowOutside,
+			readableSecrets: cfg.ReadableSecrets,
+			header:          cfg.Header,
+			normalize:       cfg.Normalize,
+			onMutation:      cfg.OnMutation,
+			editorLocks:     cfg.EditorLocks,
+			breakLinks:      cfg.HardLinks == "break",
+			outPath:         cfg.OutPath,
+			hash:            cfg.ManifestPath != "" || cfg.ChecksumsPath != "",
+		}}
+	}
+	for _, p := range cfg.Profiles {
+		targets = append(targets, renderTarget{
+			name:            p.Name,
+			outDir:          strings.ReplaceAll(cfg.OutTmpl, "{env}", p.Name),
+			replacer:        buildCountingReplacer(open, close, p.KeyMap, opts),
+			keyMap:          p.KeyMap,
+			open:            open,
+			close:           close,
+			opts:            opts,
+			encrypt:         cfg.Encrypter,
+			applyCmd:        cfg.ApplyCmd,
+			allowOutside:    cfg.AllowOutside,
+			readableSecrets: cfg.ReadableSecrets,
+			header:          cfg.Header,
+			normalize:       cfg.Normalize,
+			onMutation:      cfg.OnMutation,
+			editorLocks:     cfg.EditorLocks,
+			breakLinks:      cfg.HardLinks == "break",
+			outPath:         cfg.OutPath,
+			hash:            cfg.ManifestPath != "" || cfg.ChecksumsPath != "",
+		})
+	}
+	for i := range targets {
+		targets[i].compileExtDelims(cfg.ExtDelims)
+	}
+	return targets
+}
+
+// walkFiles calls fn for every regular file of the input source of cfg, the
+// disk under cfg.TargetDir unless set otherwise, accepted by cfg.FileFilter.
+// Sidecars, scoped values files and the run lock are never rendered, see
+// dirSource.walk for what else the disk walk skips or refuses. With -fs-case
+// insensitive, paths differing only in case are the same file and only the
+// first one walked is processed.
+func walkFiles(cfg config, fn func(path string) error) error {
+	seen := make(map[string]string)
+	return cfg.input().walk(cfg, func(p string, d fs.DirEntry) error {
+		if d.IsDir() {
+			if p != cfg.TargetDir && cfg.FileFilter.skipDir(d.Name()) {
+				slog.Debug("skipping vendored directory", slog.String("path", p))
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if strings.HasSuffix(p, sidecarSuffix) || d.Name() == runLockName || (cfg.ScopedValues != "" && d.Name() == cfg.ScopedValues) {
+			return nil
+		}
+		if skipReservedNames && isReservedName(d.Name()) {
+			slog.Warn("skipping reserved device name", slog.String("path", p))
+			return nil
+		}
+		if !cfg.FileFilter.match(p) {
+			slog.Debug("skipping file", slog.String("path", p))
+			return nil
+		}
+		if kind := specialFileKind(p, d); kind != "" {
+			// On stderr, not the log: a matched file that is never rendered
+			// must not go unnoticed when -log is off.
+			fmt.Fprintf(os.Stderr, "WARNING: skipping %s, it is a %s\n", p, kind)
+			return nil
+		}
+		if cfg.FoldCase {
+			k := pathKey(p, true)
+			if first, ok := seen[k]; ok {
+				slog.Warn("skipping file differing only in case", slog.String("path", p), slog.String("first", first))
+				return nil
+			}
+			seen[k] = p
+		}
+		return fn(p)
+	})
+}
+
+// outputPath returns where the rendered form of path is written: path itself,
+// or the same location relative to root under outDir.
+func outputPath(root, outDir, path string) string {
+	if outDir == "" {
+		return path
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	return filepath.Join(outDir, rel)
+}
+
+// targetPath is outputPath for a target, applying -out-path when set.
+func targetPath(root, path string, t renderTarget) (string, error) {
+	if t.outPath == nil || t.outDir == "" {
+		return outputPath(root, t.outDir, path), nil
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	dest, err := t.outPath.render(rel, t.name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(t.outDir, dest), nil
 }
 
-func processFile(path string, replacer replacer) error {
-	in, err := os.ReadFile(path)
+// processFile reads path, a file of the run cfg, once and writes one
+// rendering per target. fi is the result of an earlier stat of path, or nil
+// to stat it here. Files the content filters skip are left alone. With
+// -on-mutation retry, a file modified while it was rendered is read and
+// rendered again.
+func processFile(cfg config, path string, fi fs.FileInfo, targets []renderTarget) ([]fileResult, error) {
+	for attempt := 1; ; attempt++ {
+		results, err := processFileOnce(cfg, path, fi, targets)
+		if !errors.Is(err, errMutated) {
+			return results, err
+		}
+		if attempt == mutationRetries {
+			return results, fmt.Errorf("%w %d times in a row", errMutated, attempt)
+		}
+		slog.Warn("file changed while rendered, retrying", slog.String("path", path), slog.Int("attempt", attempt))
+		fi = nil
+	}
+}
+
+// mutationRetries is how many times -on-mutation retry renders a file.
+const mutationRetries = 3
+
+func processFileOnce(cfg config, path string, fi fs.FileInfo, targets []renderTarget) ([]fileResult, error) {
+	// A file rewritten in place is compared with its state right before the
+	// read, not with the stat taken when it was queued.
+	for _, t := range targets {
+		if t.outDir == "" && t.applyCmd == "" {
+			fi = nil
+		}
+	}
+	var in []byte
+	var tail func() ([]byte, error)
+	var err error
+	input := cfg.input()
+	if fi == nil {
+		if fi, err = input.stat(path); err != nil {
+			return nil, err
+		}
+	}
+	if isSparse(fi) {
+		slog.Warn("sparse file will be written densely", slog.String("path", path), slog.Int64("size", fi.Size()))
+	}
+	if cfg.HeadBytes > 0 && fi.Size() > int64(cfg.HeadBytes) {
+		if in, tail, err = readHead(input, path, cfg.HeadBytes); err != nil {
+			return nil, err
+		}
+	} else {
+		var release func()
+		if in, release, err = readFilePooled(input, path, fi.Size()); err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	if cfg.skipContent(in) {
+		slog.Debug("skipping file by content", slog.String("path", path))
+		return nil, nil
+	}
+	if in, err = cfg.decode(path, in); err != nil {
+		return nil, err
+	}
+
+	side, _, err := loadLocalValues(path, cfg)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	var errs []error
+	results := make([]fileResult, 0, len(targets))
+	for _, t := range targets {
+		var res fileResult
+		t = t.forPath(path)
+		dest, err := targetPath(cfg.TargetDir, path, t)
+		if err == nil && side != nil {
+			var values map[string]string
+			var opts replacerOptions
+			if values, opts, err = withSidecar(side, t.keyMap, t.opts); err == nil {
+				t.replacer = buildCountingReplacer(t.open, t.close, values, opts)
+				t.keyMap, t.opts = values, opts
+			}
+		}
+		if err == nil {
+			var src []byte
+			var spans []includeSpan
+			if src, spans, err = expandIncludesMapped(cfg.includeLookup(), path, in, string(t.open), string(t.close)); err == nil {
+				err = checkPragmaDelims(cfg, src)
+			}
+			if err == nil {
+				res, err = writeRendered(path, dest, src, tail, fi, t)
+				if pe := (*placeholderError)(nil); errors.As(err, &pe) {
+					pe.relocate(path, spans)
+				}
+			}
+		}
+		if err != nil {
+			if t.name != "" {
+				err = fmt.Errorf("profile %q: %w", t.name, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		results = append(results, res)
 	}
-	fi, _ := os.Stat(path)
+	return results, errors.Join(errs...)
+}
 
-	out, changed, err := replacer(in)
+// writeRendered renders in for t and writes it to dest. fi is the stat of
+// path taken before in was read. With -head-bytes in is only the head of the
+// file and tail reads the rest, which is copied after the rendered head only
+// when something is written; a file rewritten in place whose head has no
+// placeholders is never read past it.
+func writeRendered(path, dest string, in []byte, tail func() ([]byte, error), fi fs.FileInfo, t renderTarget) (fileResult, error) {
+	mode := fi.Mode()
+	res := fileResult{Path: path, Profile: t.name}
+	var st *renderStats
+	if t.opts.StrictCount {
+		st = &renderStats{}
+	}
+	out, changed, err := t.replacer(in, st)
+	if st != nil {
+		slog.Info("placeholder accounting", slog.String("path", path), slog.String("profile", t.name),
+			slog.Int("placeholders", st.Placeholders), slog.Int("replacements", st.Replacements))
+	}
 	if err != nil {
-		return fmt.Errorf("failed to process %q: %w", path, err)
+		return res, fmt.Errorf("failed to process %q: %w", path, err)
+	}
+	if t.hash {
+		res.Keys = referencedKeys(string(in), string(t.open), string(t.close))
+	}
+	if !t.readableSecrets && t.applyCmd == "" && (t.encrypt == nil || dest == path) && runtime.GOOS != "windows" &&
+		mode.Perm()&0o044 != 0 && usesSecret(in, t.open, t.close) {
+		return res, fmt.Errorf("%q uses secret() but its mode %v lets group or others read %q: chmod go-r %q or pass -readable-secrets", path, mode.Perm(), dest, path)
+	}
+	if t.dryRun {
+		if res.Replaced, err = countReplaced(in, t); err != nil {
+			return res, fmt.Errorf("failed to process %q: %w", path, err)
+		}
+	}
+	normalize := normalizerFor(t.normalize, path)
+	if tail != nil && (changed || dest != path || t.applyCmd != "" || t.hash || normalize != nil) {
+		rest, err := tail()
+		if err != nil {
+			return res, fmt.Errorf("failed to read %q past -head-bytes: %w", path, err)
+		}
+		in = append(in[:len(in):len(in)], rest...)
+		out = append(out[:len(out):len(out)], rest...)
+	}
+	if normalize != nil {
+		norm, err := normalize(out)
+		if err != nil {
+			return res, fmt.Errorf("failed to normalize %q: %w", path, err)
+		}
+		changed = changed || !bytes.Equal(norm, out)
+		out = norm
+	}
+	res.Changed = changed
+	if t.hash {
+		res.InSum = sha256Hex(in)
+	}
+	if t.dryRun {
+		// Report what the run would do without doing it.
+		if dest != path && t.applyCmd == "" {
+			res.Changed = t.encrypt != nil || !sameContent(dest, out, t.header)
+		}
+		res.Dest = dest
+		return res, nil
+	}
+
+	if t.applyCmd != "" {
+		slog.Info("applying file", slog.String("path", path), slog.String("profile", t.name),
+			slog.Int("size", len(out)), slog.Bool("changed", changed),
+		)
+		if t.hash {
+			res.OutSum = sha256Hex(out)
+		}
+		res.Written = true
+		return res, runApplyCmd(t.applyCmd, path, t.name, out)
 	}
 
+	if dest != path {
+		if t.header {
+			out = injectHeader(out, dest, path, time.Now())
+		}
+		if t.encrypt != nil {
+			if out, err = t.encrypt.encrypt(out); err != nil {
+				return res, fmt.Errorf("failed to encrypt %q: %w", path, err)
+			}
+			dest += t.encrypt.ext
+		}
+		if t.sink != nil {
+			name, err := sinkName(t, dest)
+			if err != nil {
+				return res, err
+			}
+			slog.Info("rendered file", slog.String("path", path), slog.String("dest", name),
+				slog.Int("size", len(out)), slog.Bool("changed", changed),
+			)
+			res.Dest, res.Member = dest, name
+			if t.hash {
+				res.OutSum = sha256Hex(out)
+			}
+			res.Written = true
+			return res, t.sink.WriteFile(name, path, out, mode)
+		}
+		if !t.allowOutside {
+			if err := checkWriteScope(t.outDir, dest); err != nil {
+				return res, err
+			}
+		}
+		slog.Info("rendered file", slog.String("path", path), slog.String("dest", dest),
+			slog.Int("size", len(out)), slog.Bool("changed", changed),
+		)
+		res.Dest = dest
+		if t.hash {
+			res.OutSum = sha256Hex(out)
+		}
+		// Leave an identical earlier render alone so its mtime does not wake
+		// up watchers downstream. Encrypted output differs on every run.
+		if t.encrypt == nil && sameContent(dest, out, t.header) {
+			if t.header && t.hash {
+				// The file keeps its earlier header, and with it its sum.
+				if cur, err := os.ReadFile(longPath(dest)); err == nil {
+					res.OutSum = sha256Hex(cur)
+				}
+			}
+			slog.Debug("output unchanged, not rewriting", slog.String("dest", dest))
+			return res, nil
+		}
+		if skip, err := t.skipLocked(dest); skip || err != nil {
+			return res, err
+		}
+		// Relative even when -allow-outside lets dest leave outDir.
+		rel, err := filepath.Rel(t.outDir, dest)
+		if err != nil {
+			return res, err
+		}
+		res.Written = true
+		return res, t.output().WriteFile(filepath.ToSlash(rel), path, out, mode)
+	}
+
+	res.Dest = path
+	if t.hash {
+		res.OutSum = sha256Hex(out)
+	}
 	if changed {
+		if modifiedSince(path, fi) {
+			switch t.onMutation {
+			case "skip":
+				slog.Warn("file changed while rendered, skipping", slog.String("path", path))
+				res.Changed = false
+				return res, nil
+			case "retry":
+				return res, errMutated
+			}
+			return res, fmt.Errorf("%q changed while it was rendered, not overwriting it", path)
+		}
+		if skip, err := t.skipLocked(path); skip || err != nil {
+			res.Changed = false
+			return res, err
+		}
 		slog.Info("processed file", slog.String("path", path), slog.Int("size", len(out)),
 			slog.Int("original_size", len(in)), slog.Bool("changed", changed),
 		)
-		return os.WriteFile(path, out, fi.Mode())
+		if _, linked := hardLinkID(fi); linked && t.breakLinks {
+			// A new file leaves the other links with the template.
+			if err := os.Remove(longPath(path)); err != nil {
+				return res, err
+			}
+		}
+		res.Written = true
+		return res, t.output().WriteFile(filepath.ToSlash(path), path, out, mode)
 	}
 
 	slog.Debug("no changes made to file", slog.String("path", path))
-	return nil
+	return res, nil
+}
+
+// errMutated is returned for -on-mutation retry when a file changed between
+// its read and its rewrite.
+var errMutated = errors.New("file changed while it was rendered")
+
+// modifiedSince reports whether path no longer has the size and modification
+// time of fi, or is gone.
+func modifiedSince(path string, fi fs.FileInfo) bool {
+	cur, err := os.Stat(longPath(path))
+	return err != nil || cur.Size() != fi.Size() || !cur.ModTime().Equal(fi.ModTime())
+}
+
+// sameContent reports whether the file at path holds exactly data or, with
+// ignoreHeader, the same apart from its -header comment.
+func sameContent(path string, data []byte, ignoreHeader bool) bool {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() || (!ignoreHeader && fi.Size() != int64(len(data))) {
+		return false
+	}
+	cur, release, err := readFilePooled(dirSource{}, path, fi.Size())
+	if err != nil {
+		return false
+	}
+	defer release()
+	if ignoreHeader {
+		return bytes.Equal(withoutHeader(cur), withoutHeader(data))
+	}
+	return bytes.Equal(cur, data)
 }
 
 type replacer func(txt []byte) ([]byte, bool, error)
 
-func buildNewReplacer(open, close []byte, values map[string]string) replacer {
-	openStr, closeStr := string(open), string(close)
-	pairs := make([]string, 0, len(values)*2)
+// replacerOptions carries the optional parts of the substitution pipeline.
+type replacerOptions struct {
+	Filters filterMap
+	Opaque  [][2]string
+	// Denied holds keys that have a value but are not in the -allow-keys list.
+	Denied map[string]bool
+	// Allowed is the -allow-keys list, nil when every key is allowed.
+	Allowed map[string]bool
+	// ChunkSize is the file size above which text is rendered in parallel
+	// chunks; 0 disables chunking.
+	ChunkSize int
+	// Engine names the entry of engines used for plain keys; empty means
+	// defaultEngine.
+	Engine string
+	// Stage is the -stage pass; 0 renders placeholders of every stage.
+	Stage int
+	// Limits bounds the size of substituted values.
+	Limits valueLimits
+	// Syntax names the entry of syntaxModes files are rendered with; empty
+	// means plain.
+	Syntax string
+	// StrictCount fails a render whose placeholders and replacements do not
+	// reconcile one to one.
+	StrictCount bool
+	// SecretsDir is the -secrets-dir secret() placeholders read from.
+	SecretsDir string
+	// ReadRoots are the directories file() placeholders may read below,
+	// -dir and -template-path, unless AllowOutside lifts the limit.
+	ReadRoots    []string
+	AllowOutside bool
+	// EnvOff names the setting that keeps env() placeholders from reading
+	// the environment, -mode flag or -values-lock; empty when they may.
+	EnvOff string
+	// MissingMarker replaces placeholders whose key has no value, with
+	// {{key}} standing for the key, instead of failing the render.
+	MissingMarker string
+}
+
+// missingKeyError reports a placeholder whose key has no value.
+type missingKeyError struct {
+	key    string
+	denied bool
+}
+
+func (e *missingKeyError) Error() string {
+	if e.denied {
+		return fmt.Sprintf("key %q is not in the allow-list", e.key)
+	}
+	return fmt.Sprintf("env/flag %q not set", e.key)
+}
 
-	for k, v := range values {
-		pairs = append(pairs, openStr+k+closeStr, v)
+func buildNewReplacer(open, close []byte, values map[string]string, opts replacerOptions) replacer {
+	r := buildCountingReplacer(open, close, values, opts)
+	return func(txt []byte) ([]byte, bool, error) {
+		var st *renderStats
+		if opts.StrictCount {
+			st = &renderStats{}
+		}
+		return r(txt, st)
 	}
-	strReplacer := strings.NewReplacer(pairs...)
+}
 
-	fn := func(txt []byte) ([]byte, bool, error) {
-		out := strReplacer.Replace(string(txt))
-		changed := out != string(txt)
+// countingReplacer is a replacer that accounts for the placeholders of txt in
+// st unless st is nil, failing when they do not reconcile.
+type countingReplacer func(txt []byte, st *renderStats) ([]byte, bool, error)
+
+func buildCountingReplacer(open, close []byte, values map[string]string, opts replacerOptions) countingReplacer {
+	eng := engines[opts.Engine]
+	if eng == nil {
+		eng = engines[defaultEngine]
+	}
+	render := newRenderFunc(string(open), string(close), values, eng, opts)
+	chunkSize := opts.ChunkSize
+	if syntaxModes[opts.Syntax].protected != nil {
+		// Markup such as an XML comment may span a chunk boundary.
+		chunkSize = 0
+	}
+
+	// Delimiters switched to by a pragma get their own render func, built on first use.
+	var pragmaMu sync.Mutex
+	pragmaRenders := make(map[[2]string]renderFunc)
+	renderFor := func(open, close string) renderFunc {
+		pragmaMu.Lock()
+		defer pragmaMu.Unlock()
+		rf, ok := pragmaRenders[[2]string{open, close}]
+		if !ok {
+			rf = newRenderFunc(open, close, values, eng, opts)
+			pragmaRenders[[2]string{open, close}] = rf
+		}
+		return rf
+	}
+
+	replace := func(txt []byte, st *renderStats) ([]byte, bool, error) {
+		masked, regionsOpaque := maskOpaque(string(txt), opts.Opaque)
+		// unmaskErr moves a placed error from masked to txt.
+		unmaskErr := func(err error) error {
+			if pe := (*placeholderError)(nil); errors.As(err, &pe) && pe.placed {
+				pe.at = len(unmaskOpaque(masked[:pe.at], regionsOpaque))
+			}
+			return err
+		}
+		regions := splitDelimPragmas(masked)
+		if len(regions) == 1 {
+			out, err := renderChunked(render, regions[0].text, string(open), string(close), chunkSize, st)
+			if err != nil {
+				return nil, false, unmaskErr(err)
+			}
+			out = unmaskOpaque(out, regionsOpaque)
+			return []byte(out), out != string(txt), nil
+		}
 
-		if idx := strings.Index(out, openStr); idx != -1 {
-			start := idx + len(openStr)
-			if end := strings.Index(out[start:], closeStr); end != -1 {
-				missing := out[start : start+end]
-				return nil, false, fmt.Errorf("env/flag %q not set", missing)
+		var sb strings.Builder
+		sb.Grow(len(txt))
+		for _, r := range regions {
+			rf := render
+			if r.open != "" {
+				rf = renderFor(r.open, r.close)
 			}
+			out, err := rf(r.text, st)
+			if err != nil {
+				if pe := (*placeholderError)(nil); errors.As(err, &pe) {
+					// Located in the whole file below, which gives the line.
+					pe.shift(r.offset)
+					return nil, false, unmaskErr(err)
+				}
+				return nil, false, fmt.Errorf("in region starting at line %d: %w", r.line, err)
+			}
+			sb.WriteString(out)
 		}
+		out := unmaskOpaque(sb.String(), regionsOpaque)
+		return []byte(out), out != string(txt), nil
+	}
 
-		return []byte(out), changed, nil
+	fn := func(txt []byte, st *renderStats) ([]byte, bool, error) {
+		out, changed, err := replace(txt, st)
+		var mk *missingKeyError
+		if errors.As(err, &mk) && opts.Denied[mk.key] {
+			mk.denied = true
+		}
+		var pe *placeholderError
+		if errors.As(err, &pe) {
+			pe.locate(string(txt), values)
+		}
+		if err == nil && st != nil {
+			err = st.check()
+		}
+		return out, changed, err
 	}
 	return fn
 }
 
+// renderFunc renders txt. st is nil unless placeholders are being counted.
+type renderFunc func(txt string, st *renderStats) (string, error)
+
+// newRenderFunc returns the substitution pipeline for one pair of delimiters:
+// placeholders of other stages and those -syntax protects, then value limits,
+// then conditional blocks, then plain keys with eng, then filtered
+// placeholders.
+func newRenderFunc(open, close string, values map[string]string, eng engine, opts replacerOptions) renderFunc {
+	over := opts.Limits.oversized(values)
+	syntax := syntaxModes[opts.Syntax]
+	engValues := values
+	if syntax.escape != nil {
+		engValues = make(map[string]string, len(values))
+		for k, v := range values {
+			engValues[k] = syntax.escape(v)
+		}
+	}
+	var marks map[string]string
+	if opts.StrictCount {
+		marks = countValues(values)
+	}
+	return func(txt string, st *renderStats) (string, error) {
+		txt, later, err := resolveStages(txt, open, close, opts.Stage)
+		if err != nil {
+			return "", err
+		}
+		var protected []string
+		if syntax.protected != nil {
+			txt, protected = maskSpans(txt, open, syntaxMarker, syntax.protected(txt, open, close))
+		}
+		if over != nil {
+			// Check with line numbers intact, skipping branches not taken.
+			kept, err := resolveConditionals(txt, open, close, values, true)
+			if err != nil {
+				return "", err
+			}
+			if err := checkValueLimits(kept, open, close, over, opts.Limits.Warn); err != nil {
+				return "", err
+			}
+		}
+		in, err := expandConditionals(txt, open, close, values)
+		if err != nil {
+			return "", err
+		}
+		if st != nil {
+			st.Placeholders += countPlaceholders(in, open, close)
+			st.Replacements += strings.Count(eng(in, open, close, marks), countMarker)
+		}
+		out, n, err := expandPipelines(eng(in, open, close, engValues), open, close, values, opts, syntax.escape)
+		if err != nil {
+			var pe *placeholderError
+			if errors.As(err, &pe) {
+				// Plain keys leave the placeholder itself alone, so its nth
+				// occurrence is the same before they are substituted.
+				pe.place(txt)
+				if pe.placed {
+					pe.at = len(unmaskRegions(unmaskRegions(txt[:pe.at], syntaxMarker, protected), stageMarker, later))
+				}
+			}
+			return "", err
+		}
+		if st != nil {
+			st.Replacements += n
+		}
+		return unmaskRegions(unmaskRegions(out, syntaxMarker, protected), stageMarker, later), nil
+	}
+}
+
 type sliceFlag []string
 
 func (s *sliceFlag) String() string     { return fmt.Sprint([]string(*s)) }
 func (s *sliceFlag) Set(v string) error { *s = append(*s, v); return nil }
 
+// caseInsensitiveFS resolves -fs-case. auto assumes the default filesystems of
+// macOS and Windows, which ignore case.
+func caseInsensitiveFS(mode string) (bool, error) {
+	switch mode {
+	case "sensitive":
+		return false, nil
+	case "insensitive":
+		return true, nil
+	case "auto":
+		return runtime.GOOS == "darwin" || runtime.GOOS == "windows", nil
+	}
+	return false, fmt.Errorf("invalid -fs-case %q, must be sensitive, insensitive or auto", mode)
+}
+
+func foldPatterns(pats []string) []string {
+	out := make([]string, len(pats))
+	for i, p := range pats {
+		out[i] = "(?i)" + p
+	}
+	return out
+}
+
 type fileFilter struct {
 	includes []*regexp.Regexp
 	excludes []*regexp.Regexp
+	// contents skips files whose content matches, see -ignore-content.
+	contents []*regexp.Regexp
+	// vendored prunes vendored directories and skips generated files, see
+	// -skip-vendored.
+	vendored bool
 }
 
-func newFileFilter(incPat, excPat []string) (*fileFilter, error) {
-	compileAll := func(pats []string) ([]*regexp.Regexp, error) {
-		out := make([]*regexp.Regexp, 0, len(pats))
-		for _, p := range pats {
-			rx, err := regexp.Compile(p)
-			if err != nil {
-				return nil, err
-			}
-			out = append(out, rx)
+func compileAll(pats []string) ([]*regexp.Regexp, error) {
+	out := make([]*regexp.Regexp, 0, len(pats))
+	for _, p := range pats {
+		rx, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
 		}
-		return out, nil
+		out = append(out, rx)
 	}
+	return out, nil
+}
+
+func newFileFilter(incPat, excPat []string) (*fileFilter, error) {
 	inc, err := compileAll(incPat)
 	if err != nil {
 		return nil, fmt.Errorf("include: %w", err)
@@ -309,7 +1965,10 @@ func newFileFilter(incPat, excPat []string) (*fileFilter, error) {
 	return &fileFilter{includes: inc, excludes: exc}, nil
 }
 
+// match reports whether path passes the filters. Separators are normalized to
+// '/' first so the same patterns work on every platform.
 func (f *fileFilter) match(path string) bool {
+	path = filepath.ToSlash(path)
 	for _, rx := range f.excludes {
 		if rx.MatchString(path) {
 			return false
@@ -326,6 +1985,21 @@ func (f *fileFilter) match(path string) bool {
 	return false
 }
 
+// skipContent reports whether data matches an -ignore-content pattern or,
+// with -skip-vendored, starts with a generated-code marker. A nil filter
+// skips nothing.
+func (f *fileFilter) skipContent(data []byte) bool {
+	if f == nil {
+		return false
+	}
+	for _, rx := range f.contents {
+		if rx.Match(data) {
+			return true
+		}
+	}
+	return f.vendored && isGenerated(data)
+}
+
 type StringMap map[string]string
 
 func (m *StringMap) String() string {
@@ -338,12 +2012,12 @@ func (m *StringMap) Set(value string) error {
 		*m = make(map[string]string)
 	}
 	for _, pair := range pairs {
-		parts := strings.Split(pair, "=")
-		if len(parts) == 2 {
-			(*m)[parts[0]] = parts[1]
-		} else {
+		// Only the first = separates, values such as padded base64 keep theirs.
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
 			return fmt.Errorf("invalid key=value pair %q, expected format KEY=VALUE", pair)
 		}
+		(*m)[k] = v
 	}
 	return nil
 }
@@ -1,12 +1,10 @@
 package main
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"math/rand"
 	"os"
 	"path/filepath"
-	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -19,55 +17,10 @@ var (
 	benchCloseDelim = []byte("}}")
 )
 
-/*
-makeTestBlob generates:
-
-  - txt      – byte slice of (approx) size bytes
-  - vals     – map used for replacement
-
-Returns deterministic output for a given seed so benches are comparable.
-*/
+// makeTestBlob returns the gen-fixtures text and values for size, keys and
+// seed with the bench delimiters, so benches are comparable across runs.
 func makeTestBlob(size, keys int, seed int64) (txt []byte, vals map[string]string) {
-	if keys <= 0 {
-		panic("keys must be >0")
-	}
-	rng := rand.New(rand.NewSource(seed))
-
-	// Build values map first.
-	vals = make(map[string]string, keys)
-	for i := 0; i < keys; i++ {
-		k := "K" + strconv.Itoa(i)
-		v := "V" + strconv.Itoa(i)
-		vals[k] = v
-	}
-
-	var b bytes.Buffer
-	keyList := make([]string, 0, keys)
-	for k := range vals {
-		keyList = append(keyList, k)
-	}
-
-	for b.Len() < size {
-		if rng.Float64() < 0.08 { // 8 % chance emit a placeholder token
-			k := keyList[rng.Intn(len(keyList))]
-			b.Write(benchOpenDelim)
-			b.WriteString(k)
-			b.Write(benchCloseDelim)
-		} else {
-			b.WriteString(randomWord(rng))
-		}
-		b.WriteByte(' ')
-	}
-	return b.Bytes(), vals
-}
-
-func randomWord(rng *rand.Rand) string {
-	n := rng.Intn(7) + 4
-	var sb strings.Builder
-	for i := 0; i < n; i++ {
-		sb.WriteByte(byte('a' + rng.Intn(26)))
-	}
-	return sb.String()
+	return genFixture(size, keys, seed, string(benchOpenDelim), string(benchCloseDelim))
 }
 
 func humanSize(n int) string {
@@ -88,14 +41,11 @@ func BenchmarkReplacers(b *testing.B) {
 			blob, values := makeTestBlob(sz, k, 42)
 
 			replacers := map[string]replacer{
-				"regex": makeRegexReplacer(benchOpenDelim, benchCloseDelim, values),
-				"strings.ReplaceAll": func(txt []byte) ([]byte, bool, error) {
-					return stringsReplaceAllReplacer(txt, benchOpenDelim, benchCloseDelim, values)
-				},
-				"loop": func(txt []byte) ([]byte, bool, error) {
-					return loopReplacer(txt, benchOpenDelim, benchCloseDelim, values)
-				},
-				"strings.Replacer": buildNewReplacer(benchOpenDelim, benchCloseDelim, values),
+				"regex":              buildNewReplacer(benchOpenDelim, benchCloseDelim, values, replacerOptions{Engine: "regex"}),
+				"strings.ReplaceAll": buildNewReplacer(benchOpenDelim, benchCloseDelim, values, replacerOptions{Engine: "replaceall"}),
+				"loop":               buildNewReplacer(benchOpenDelim, benchCloseDelim, values, replacerOptions{Engine: "loop"}),
+				"strings.Replacer":   buildNewReplacer(benchOpenDelim, benchCloseDelim, values, replacerOptions{}),
+				"aho-corasick":       buildNewReplacer(benchOpenDelim, benchCloseDelim, values, replacerOptions{Engine: "aho"}),
 			}
 
 			for name, fn := range replacers {
@@ -111,93 +61,6 @@ func BenchmarkReplacers(b *testing.B) {
 	}
 }
 
-// regex
-func makeRegexReplacer(open, close []byte, values map[string]string) replacer {
-	openStr, closeStr := regexp.QuoteMeta(string(open)), regexp.QuoteMeta(string(close))
-	re := regexp.MustCompile(openStr + `(.*?)` + closeStr) // safe for concurrent use
-
-	return func(txt []byte) ([]byte, bool, error) {
-		// (Optional) sanity-check that callers pass the same delimiters.
-		if !bytes.Equal(open, open) || !bytes.Equal(close, close) {
-			return nil, false, fmt.Errorf("makeRegexReplacer: mismatched delimiters")
-		}
-
-		changed := false
-		var missingErr error
-
-		out := re.ReplaceAllFunc(txt, func(m []byte) []byte {
-			key := string(m[len(open) : len(m)-len(close)])
-			val, ok := values[key]
-			if !ok {
-				missingErr = fmt.Errorf("env/flag %q not set", key)
-				return m // leave token intact so caller sees original text if desired
-			}
-			changed = true
-			return []byte(val)
-		})
-
-		if missingErr != nil {
-			return nil, false, missingErr
-		}
-		return out, changed, nil
-	}
-}
-
-// strings.ReplaceAll
-func stringsReplaceAllReplacer(txt, open, close []byte, values map[string]string) ([]byte, bool, error) {
-	s := string(txt)
-	openStr := string(open)
-	closeStr := string(close)
-	changed := false
-
-	for k, v := range values {
-		token := openStr + k + closeStr
-		if strings.Contains(s, token) {
-			s = strings.ReplaceAll(s, token, v)
-			changed = true
-		}
-	}
-
-	if idx := strings.Index(s, openStr); idx != -1 {
-		start := idx + len(openStr)
-		if end := strings.Index(s[start:], closeStr); end != -1 {
-			missing := s[start : start+end]
-			return nil, false, fmt.Errorf("env/flag %q not set", missing)
-		}
-	}
-
-	return []byte(s), changed, nil
-}
-
-func loopReplacer(txt, open, close []byte, values map[string]string) ([]byte, bool, error) {
-	var out bytes.Buffer
-	changed := false
-
-	for i := 0; i < len(txt); {
-		if bytes.HasPrefix(txt[i:], open) {
-			start := i + len(open)
-			end := bytes.Index(txt[start:], close)
-			if end < 0 {
-				out.Write(txt[i:])
-				break
-			}
-			key := string(txt[start : start+end])
-			val, ok := values[key]
-			if !ok {
-				return nil, false, fmt.Errorf("env/flag %q not set", key)
-			}
-			out.WriteString(val)
-			i = start + end + len(close)
-			changed = true
-		} else {
-			out.WriteByte(txt[i])
-			i++
-		}
-	}
-
-	return out.Bytes(), changed, nil
-}
-
 func TestProcessFiles_ReplacesKeys(t *testing.T) {
 	t.Parallel()
 
@@ -244,6 +107,164 @@ data:
 	}
 }
 
+func TestWalkFiles_FoldCase(t *testing.T) {
+	tmp := t.TempDir()
+	for _, name := range []string{"Config.YAML", "config.yaml", "Vendor/app.yaml"} {
+		path := filepath.Join(tmp, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "CONFIG.yaml")); err == nil {
+		t.Skip("filesystem is already case-insensitive")
+	}
+
+	ff, _ := newFileFilter(foldPatterns([]string{`.*\.ya?ml$`}), foldPatterns([]string{`/vendor/`}))
+	cfg := config{TargetDir: tmp, FileFilter: ff, FoldCase: true}
+	var got []string
+	if err := walkFiles(cfg, func(p string) error {
+		got = append(got, filepath.Base(p))
+		return nil
+	}); err != nil {
+		t.Fatalf("walkFiles: %v", err)
+	}
+	if len(got) != 1 || got[0] != "Config.YAML" {
+		t.Errorf("walked %v, want only Config.YAML", got)
+	}
+}
+
+func TestLargestFirst(t *testing.T) {
+	tmp := t.TempDir()
+	var paths []string
+	for name, size := range map[string]int{"small.yaml": 10, "big.yaml": 1000, "mid.yaml": 100} {
+		p := filepath.Join(tmp, name)
+		if err := os.WriteFile(p, make([]byte, size), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+		paths = append(paths, p)
+	}
+
+	got := largestFirst(paths, dirSource{})
+	for i, want := range []string{"big.yaml", "mid.yaml", "small.yaml"} {
+		if filepath.Base(got[i].path) != want {
+			t.Fatalf("order = %v, want big, mid, small", got)
+		}
+	}
+}
+
+func TestProcessFiles_SkipsIdenticalOutput(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		Workers:    1,
+		KeyMap:     map[string]string{"V": "1"},
+		FileFilter: ff,
+		CloseLog:   func() {},
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+
+	dest := filepath.Join(out, "app.yaml")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(dest, old, old); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+	if fi, _ := os.Stat(dest); !fi.ModTime().Equal(old) {
+		t.Errorf("identical output was rewritten (mtime %v)", fi.ModTime())
+	}
+
+	cfg.KeyMap = map[string]string{"V": "2"}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "v: 2\n" {
+		t.Errorf("changed output not written: %q", got)
+	}
+}
+
+func TestProcessFiles_QueueDepth(t *testing.T) {
+	tmp := t.TempDir()
+	for i := 0; i < 7; i++ {
+		p := filepath.Join(tmp, "f"+strconv.Itoa(i)+".yaml")
+		if err := os.WriteFile(p, []byte(strings.Repeat("x", i)+"<::K::>"), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  tmp,
+		Workers:    2,
+		QueueDepth: 3,
+		KeyMap:     map[string]string{"K": "v"},
+		FileFilter: ff,
+		CloseLog:   func() {},
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		got, _ := os.ReadFile(filepath.Join(tmp, "f"+strconv.Itoa(i)+".yaml"))
+		if want := strings.Repeat("x", i) + "v"; string(got) != want {
+			t.Errorf("f%d.yaml = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestProcessTree_Written(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	write("static.yaml", "a: 1\n")
+	write("tpl.yaml", "a: <::A::>\n")
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  tmp,
+		Workers:    1,
+		KeyMap:     map[string]string{"A": "1"},
+		FileFilter: ff,
+		CloseLog:   func() {},
+	}
+	results, err := processTree(cfg)
+	if err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+	if !anyWritten(results) {
+		t.Errorf("expected the template rewrite to be reported")
+	}
+
+	// Everything is rendered now, so a second run changes nothing.
+	results, err = processTree(cfg)
+	if err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+	if anyWritten(results) {
+		t.Errorf("expected no writes on the second run: %+v", results)
+	}
+}
+
 func TestDefaultFileFilter(t *testing.T) {
 	inc := sliceFlag{`.*\.ya?ml$`}
 	ign := sliceFlag{`(^|/)\.git(/|$)`}
@@ -331,3 +352,141 @@ func BenchmarkProcessFiles(b *testing.B) {
 		})
 	}
 }
+
+func TestProcessFiles_Profiles(t *testing.T) {
+	t.Parallel()
+
+	src, out := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("env: <::ENV::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		Workers:    2,
+		FileFilter: ff,
+		CloseLog:   func() {},
+		OutTmpl:    filepath.Join(out, "{env}"),
+		Profiles: []profile{
+			{Name: "dev", KeyMap: map[string]string{"ENV": "development"}},
+			{Name: "prod", KeyMap: map[string]string{"ENV": "production"}},
+		},
+	}
+
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles returned error: %v", err)
+	}
+
+	for env, want := range map[string]string{"dev": "env: development\n", "prod": "env: production\n"} {
+		got, err := os.ReadFile(filepath.Join(out, env, "app.yaml"))
+		if err != nil {
+			t.Fatalf("read %s output: %v", env, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s rendered %q, want %q", env, got, want)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(src, "app.yaml")); string(got) != "env: <::ENV::>\n" {
+		t.Errorf("template modified in place: %q", got)
+	}
+}
+
+func TestProcessFiles_IgnoreContent(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"app.yaml":    "v: <::V::>\n",
+		"vendor.yaml": "# charmap: ignore-file\nv: <::UNSET::>\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	ff.contents, _ = compileAll([]string{`(?m)^# charmap: ignore-file$`})
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		Workers:    2,
+		KeyMap:     map[string]string{"V": "1"},
+		FileFilter: ff,
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+	for name, want := range map[string]string{"app.yaml": "v: 1\n", "vendor.yaml": files["vendor.yaml"]} {
+		if got, _ := os.ReadFile(filepath.Join(src, name)); string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestWriteRendered_Mutation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	const newer = "v: <::V::>\nadded: by someone else\n"
+	in := []byte("v: <::V::>\n")
+
+	for _, policy := range []string{"fail", "skip", "retry"} {
+		if err := os.WriteFile(path, in, 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The file is rewritten after it was read.
+		if err := os.WriteFile(path, []byte(newer), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		target := renderTarget{
+			replacer:   buildCountingReplacer([]byte("<::"), []byte("::>"), map[string]string{"V": "1"}, replacerOptions{}),
+			onMutation: policy,
+		}
+		res, err := writeRendered(path, path, in, nil, fi, target)
+		switch policy {
+		case "fail":
+			if err == nil || !strings.Contains(err.Error(), "changed while it was rendered") {
+				t.Errorf("fail: got %v, want an error", err)
+			}
+		case "skip":
+			if err != nil || res.Written {
+				t.Errorf("skip: got written=%v, %v; want the file skipped", res.Written, err)
+			}
+		case "retry":
+			if !errors.Is(err, errMutated) {
+				t.Errorf("retry: got %v, want errMutated", err)
+			}
+		}
+		if got, _ := os.ReadFile(path); string(got) != newer {
+			t.Errorf("%s: newer content was overwritten with %q", policy, got)
+		}
+	}
+}
+
+func TestStringMapSet(t *testing.T) {
+	var m StringMap
+	if err := m.Set("CERT=b64:aGVsbG8="); err != nil {
+		t.Fatalf("Set padded base64: %v", err)
+	}
+	if err := m.Set("RAW=a=b"); err != nil {
+		t.Fatalf("Set raw a=b: %v", err)
+	}
+	if m["RAW"] != "a=b" {
+		t.Errorf("RAW = %q, want %q", m["RAW"], "a=b")
+	}
+	values, err := buildKeyMap(false, true, nil, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["CERT"] != "hello" {
+		t.Errorf("CERT = %q, want %q", values["CERT"], "hello")
+	}
+	if err := m.Set("NOVALUE"); err == nil {
+		t.Errorf("expected an error for a pair without =")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// valueSource identifies where values came from without revealing them.
+type valueSource struct {
+	Type   string `json:"type"` // env, set, values, profile or filters
+	Path   string `json:"path,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+func describeSources(useEnv, useSet bool, valueFiles, profileFiles, filterFiles []string) ([]valueSource, error) {
+	var sources []valueSource
+	if useEnv {
+		sources = append(sources, valueSource{Type: "env"})
+	}
+	for _, group := range []struct {
+		typ   string
+		paths []string
+	}{{"values", valueFiles}, {"profile", profileFiles}, {"filters", filterFiles}} {
+		for _, path := range group.paths {
+			var data []byte
+			var err error
+			if path == "-" {
+				data, err = readStdin()
+			} else {
+				data, err = os.ReadFile(path)
+			}
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, valueSource{Type: group.typ, Path: path, SHA256: sha256Hex(data)})
+		}
+	}
+	if useSet {
+		sources = append(sources, valueSource{Type: "set"})
+	}
+	return sources, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type manifestFile struct {
+	Input        string `json:"input"`
+	InputSHA256  string `json:"input_sha256"`
+	Output       string `json:"output,omitempty"`
+	OutputSHA256 string `json:"output_sha256"`
+	Profile      string `json:"profile,omitempty"`
+	// Keys are the keys the input references, the index -changed-keys
+	// selects files by.
+	Keys []string `json:"keys,omitempty"`
+}
+
+// runManifest records what went into a run and what came out of it, so a
+// later pipeline step can verify rendered artifacts against a signed manifest.
+type runManifest struct {
+	Generated time.Time      `json:"generated"`
+	Dir       string         `json:"dir"`
+	Sources   []valueSource  `json:"sources"`
+	Files     []manifestFile `json:"files"`
+	Keys      []manifestKey  `json:"keys,omitempty"`
+}
+
+// readManifest reads the -manifest at path.
+func readManifest(path string) (runManifest, error) {
+	var m runManifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("failed to parse manifest %q: %w", path, err)
+	}
+	return m, nil
+}
+
+func writeManifest(cfg config, results []fileResult) error {
+	m := runManifest{
+		Generated: time.Now().UTC(),
+		Dir:       cfg.TargetDir,
+		Sources:   cfg.Sources,
+		Files:     make([]manifestFile, 0, len(results)),
+	}
+	for _, r := range results {
+		output := r.Dest
+		if r.Member != "" {
+			output = r.Member
+		}
+		m.Files = append(m.Files, manifestFile{
+			Input:        r.Path,
+			InputSHA256:  r.InSum,
+			Output:       output,
+			OutputSHA256: r.OutSum,
+			Profile:      r.Profile,
+			Keys:         r.Keys,
+		})
+	}
+	m.Keys = keyProvenance(cfg, results)
+	if len(cfg.ChangedKeys) > 0 {
+		prev, err := readManifest(cfg.ManifestPath)
+		if err != nil {
+			return err
+		}
+		m.Files = carryOver(prev.Files, m.Files)
+		for _, k := range prev.Keys {
+			if !slices.Contains(m.Keys, k) {
+				m.Keys = append(m.Keys, k)
+			}
+		}
+	}
+	sort.Slice(m.Files, func(i, j int) bool {
+		if m.Files[i].Input != m.Files[j].Input {
+			return m.Files[i].Input < m.Files[j].Input
+		}
+		return m.Files[i].Profile < m.Files[j].Profile
+	})
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(cfg.ManifestPath, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	if cfg.SignSpec != "" {
+		return signManifest(cfg.SignSpec, cfg.ManifestPath)
+	}
+	return nil
+}
+
+// signManifest signs path with minisign (writing path.minisig) or cosign
+// (writing path.sig) using the binaries found on PATH.
+func signManifest(spec, path string) error {
+	tool, key, _ := strings.Cut(spec, ":")
+
+	var cmd *exec.Cmd
+	switch tool {
+	case "minisign":
+		if key == "" {
+			return fmt.Errorf("-sign minisign requires a secret key path")
+		}
+		cmd = exec.Command("minisign", "-S", "-s", key, "-m", path)
+	case "cosign":
+		args := []string{"sign-blob", "--yes", "--output-signature", path + ".sig"}
+		if key != "" {
+			args = append(args, "--key", key)
+		}
+		cmd = exec.Command("cosign", append(args, path)...)
+	default:
+		return fmt.Errorf("unknown -sign tool %q, must be one of: minisign, cosign", tool)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stdin, cmd.Stderr = os.Stdin, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", tool, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
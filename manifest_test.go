@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessFiles_Manifest(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	const input = "domain: <::PUBLIC_DOMAIN::>\n"
+	if err := os.WriteFile(filepath.Join(src, "config.yaml"), []byte(input), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:    "<::",
+		CloseDelim:   "::>",
+		TargetDir:    src,
+		OutDir:       out,
+		Workers:      1,
+		KeyMap:       map[string]string{"PUBLIC_DOMAIN": "example.com"},
+		FileFilter:   ff,
+		ManifestPath: filepath.Join(t.TempDir(), "manifest.json"),
+		Sources:      []valueSource{{Type: "set"}},
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+
+	data, err := os.ReadFile(cfg.ManifestPath)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var m runManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if len(m.Files) != 1 || len(m.Sources) != 1 {
+		t.Fatalf("manifest = %+v, want one file and one source", m)
+	}
+
+	f := m.Files[0]
+	if f.InputSHA256 != sha256Hex([]byte(input)) {
+		t.Errorf("input hash = %s, want hash of template", f.InputSHA256)
+	}
+	if f.OutputSHA256 != sha256Hex([]byte("domain: example.com\n")) {
+		t.Errorf("output hash = %s, want hash of rendered file", f.OutputSHA256)
+	}
+	if f.Output != filepath.Join(out, "config.yaml") {
+		t.Errorf("output = %s", f.Output)
+	}
+}
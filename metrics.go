@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// keyCount identifies a count of keyCounts: a key of the values of a profile,
+// "" without profiles.
+type keyCount struct {
+	profile, key string
+}
+
+// keyCounts counts, per profile and key, the placeholders of the templates a
+// render with cfg substitutes and those it has no value for. A placeholder
+// whose pipeline starts with default is counted as substituted, one rendered
+// as the -missing-placeholder marker as missing. Function-call placeholders
+// name no key and are left out.
+func keyCounts(cfg config) (replaced, missing map[keyCount]int, err error) {
+	replaced, missing = make(map[keyCount]int), make(map[keyCount]int)
+	targets := renderTargets(cfg)
+	err = walkFiles(cfg, func(path string) error {
+		in, err := cfg.input().readFile(path)
+		if err != nil {
+			return err
+		}
+		if cfg.skipContent(in) {
+			return nil
+		}
+		if in, err = cfg.decode(path, in); err != nil {
+			return err
+		}
+		if in, err = cfg.withIncludes(path, in); err != nil {
+			return err
+		}
+		side, _, err := loadLocalValues(path, cfg)
+		if err != nil {
+			return err
+		}
+		for _, t := range targets {
+			t = t.forPath(path)
+			if side != nil {
+				if t.keyMap, t.opts, err = withSidecar(side, t.keyMap, t.opts); err != nil {
+					return err
+				}
+			}
+			refs, err := scanPlaceholders(string(in), string(t.open), string(t.close), t.keyMap, t.opts)
+			if err != nil {
+				return fmt.Errorf("failed to scan %q: %w", path, err)
+			}
+			for _, ref := range refs {
+				if ref.Source != nil {
+					continue
+				}
+				if _, set := t.keyMap[ref.Key]; set || ref.HasDefault {
+					replaced[keyCount{t.name, ref.Key}]++
+				} else {
+					missing[keyCount{t.name, ref.Key}]++
+				}
+			}
+		}
+		return nil
+	})
+	return replaced, missing, err
+}
+
+// sidecarStats are the totals of a sidecar since it started.
+type sidecarStats struct {
+	renders, failures int
+	last              time.Time
+}
+
+// writeMetrics writes the metrics of the last render with cfg and of stats
+// to path in the Prometheus text format, for the textfile collector of
+// node_exporter. The file is replaced atomically, as the collector requires.
+// When the templates cannot be counted the totals are written all the same,
+// and the error is returned.
+func writeMetrics(path string, cfg config, stats sidecarStats) error {
+	replaced, missing, countErr := keyCounts(cfg)
+	var buf bytes.Buffer
+	writeKeyCounts(&buf, "charmap_replaced_placeholders", "Placeholders per key the last render substituted.", replaced)
+	writeKeyCounts(&buf, "charmap_missing_placeholders", "Placeholders per key the last render had no value for.", missing)
+	fmt.Fprintf(&buf, "# HELP charmap_renders_total Renders since the sidecar started.\n# TYPE charmap_renders_total counter\ncharmap_renders_total %d\n", stats.renders)
+	fmt.Fprintf(&buf, "# HELP charmap_render_failures_total Failed renders since the sidecar started.\n# TYPE charmap_render_failures_total counter\ncharmap_render_failures_total %d\n", stats.failures)
+	fmt.Fprintf(&buf, "# HELP charmap_last_render_timestamp_seconds When the last render ran.\n# TYPE charmap_last_render_timestamp_seconds gauge\ncharmap_last_render_timestamp_seconds %d\n", stats.last.Unix())
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".charmap-metrics-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return errors.Join(countErr, os.Rename(f.Name(), path))
+}
+
+// labelEscaper escapes a label value of the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeKeyCounts writes counts as the gauge name, labeled by profile and key.
+func writeKeyCounts(buf *bytes.Buffer, name, help string, counts map[keyCount]int) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	keys := slices.SortedFunc(maps.Keys(counts), func(a, b keyCount) int {
+		return cmp.Or(cmp.Compare(a.profile, b.profile), cmp.Compare(a.key, b.key))
+	})
+	for _, k := range keys {
+		fmt.Fprintf(buf, "%s{profile=\"%s\",key=\"%s\"} %d\n", name, labelEscaper.Replace(k.profile), labelEscaper.Replace(k.key), counts[k])
+	}
+}
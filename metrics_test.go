@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"app.yaml":                "host: <::HOST::>\nalt: <::HOST::>\nport: <::PORT | default 80::>\n",
+		"db.yaml":                 "url: <::DB_URL::>\nv: <::V::>\n",
+		"db.yaml" + sidecarSuffix: "V=local\n",
+		"notes.txt":               "<::IGNORED::>\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		FileFilter: ff,
+		KeyMap:     map[string]string{"HOST": "db1"},
+	}
+	path := filepath.Join(t.TempDir(), "charmap.prom")
+	if err := writeMetrics(path, cfg, sidecarStats{renders: 3, failures: 1, last: time.Unix(1700000000, 0)}); err != nil {
+		t.Fatalf("writeMetrics: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, line := range []string{
+		`charmap_replaced_placeholders{profile="",key="HOST"} 2`,
+		`charmap_replaced_placeholders{profile="",key="PORT"} 1`,
+		`charmap_replaced_placeholders{profile="",key="V"} 1`,
+		`charmap_missing_placeholders{profile="",key="DB_URL"} 1`,
+		"# TYPE charmap_renders_total counter\ncharmap_renders_total 3\n",
+		"charmap_render_failures_total 1\n",
+		"charmap_last_render_timestamp_seconds 1700000000\n",
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("metrics lack %q:\n%s", line, got)
+		}
+	}
+	if strings.Contains(got, "IGNORED") || strings.Contains(got, "db1") {
+		t.Errorf("metrics count an unmatched file or hold a value:\n%s", got)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".charmap-metrics-*")); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
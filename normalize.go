@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// normalizers reformat rendered output of a -normalize format so that runs
+// with the same values produce byte-identical files. The key is the format
+// name, the extensions are those of the files it applies to.
+var normalizers = map[string]struct {
+	exts []string
+	fn   func([]byte) ([]byte, error)
+}{
+	"json": {[]string{".json"}, normalizeJSON},
+}
+
+// normalizerFor returns the normalizer of formats that applies to path, or
+// nil.
+func normalizerFor(formats []string, path string) func([]byte) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, f := range formats {
+		if n, ok := normalizers[f]; ok && slices.Contains(n.exts, ext) {
+			return n.fn
+		}
+	}
+	return nil
+}
+
+// normalizeJSON re-encodes data with object keys sorted and two-space
+// indentation. Numbers keep their spelling and HTML characters are not
+// escaped. Of duplicate keys the last wins, as with any JSON decoder.
+func normalizeJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("not valid JSON: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("not valid JSON: data after the top-level value")
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
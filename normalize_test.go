@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeJSON(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`{"b":1,"a":{"d":[1, 2.50],"c":"<x>"}}`, "{\n  \"a\": {\n    \"c\": \"<x>\",\n    \"d\": [\n      1,\n      2.50\n    ]\n  },\n  \"b\": 1\n}\n"},
+		{"[]", "[]\n"},
+		{"\t{ \"k\" : 1e3 }\n\n", "{\n  \"k\": 1e3\n}\n"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeJSON([]byte(tt.in))
+		if err != nil {
+			t.Errorf("normalizeJSON(%q): %v", tt.in, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("normalizeJSON(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"", `{"a":}`, `{} {}`} {
+		if _, err := normalizeJSON([]byte(in)); err == nil {
+			t.Errorf("normalizeJSON(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestProcessTree_Normalize(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		"app.json":  `{"replicas": <::N::>, "name": "<::APP::>"}`,
+		"notes.txt": `{"b": <::N::>, "a": 1}`,
+	}
+	for name, txt := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.(json|txt)$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		Workers:    1,
+		KeyMap:     map[string]string{"N": "3", "APP": "web"},
+		FileFilter: ff,
+		Normalize:  []string{"json"},
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"app.json":  "{\n  \"name\": \"web\",\n  \"replicas\": 3\n}\n",
+		"notes.txt": `{"b": 3, "a": 1}`,
+	}
+	for name, w := range want {
+		if got, _ := os.ReadFile(filepath.Join(out, name)); string(got) != w {
+			t.Errorf("%s: got %q, want %q", name, got, w)
+		}
+	}
+	var buf bytes.Buffer
+	if err := renderFile(filepath.Join(src, "app.json"), cfg, &buf); err != nil || buf.String() != want["app.json"] {
+		t.Errorf("render: got %q, %v; want %q", buf.String(), err, want["app.json"])
+	}
+
+	// Output that is not JSON once rendered is an error, not a broken file.
+	cfg.KeyMap["N"] = "three"
+	if _, err := processTree(cfg); err == nil {
+		t.Error("rendering invalid JSON succeeded")
+	}
+}
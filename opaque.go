@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// opaqueMarker brackets the index of a masked region. NUL bytes do not occur
+// in text templates and never form part of a delimiter.
+const opaqueMarker = "\x00charmap-opaque\x00"
+
+// maskOpaque replaces every region enclosed by one of the opaque pairs with a
+// marker so that the rest of the pipeline neither substitutes inside it nor
+// reports its contents as missing keys. The original regions are returned for
+// unmaskOpaque.
+func maskOpaque(txt string, pairs [][2]string) (string, []string) {
+	if len(pairs) == 0 {
+		return txt, nil
+	}
+
+	var regions []string
+	for _, pair := range pairs {
+		if !strings.Contains(txt, pair[0]) {
+			continue
+		}
+
+		var sb strings.Builder
+		sb.Grow(len(txt))
+		for {
+			idx := strings.Index(txt, pair[0])
+			if idx == -1 {
+				break
+			}
+			end := strings.Index(txt[idx+len(pair[0]):], pair[1])
+			if end == -1 {
+				break
+			}
+			end += idx + len(pair[0]) + len(pair[1])
+
+			sb.WriteString(txt[:idx])
+			sb.WriteString(opaqueMarker + strconv.Itoa(len(regions)) + opaqueMarker)
+			regions = append(regions, txt[idx:end])
+			txt = txt[end:]
+		}
+		sb.WriteString(txt)
+		txt = sb.String()
+	}
+	return txt, regions
+}
+
+// unmaskOpaque restores the regions hidden by maskOpaque.
+func unmaskOpaque(txt string, regions []string) string {
+	return unmaskRegions(txt, opaqueMarker, regions)
+}
+
+// unmaskRegions replaces every marker-bracketed index in txt with its region.
+func unmaskRegions(txt, marker string, regions []string) string {
+	if len(regions) == 0 {
+		return txt
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(txt))
+	for {
+		idx := strings.Index(txt, marker)
+		if idx == -1 {
+			break
+		}
+		start := idx + len(marker)
+		end := strings.Index(txt[start:], marker)
+		if end == -1 {
+			break
+		}
+		n, err := strconv.Atoi(txt[start : start+end])
+		if err != nil || n >= len(regions) {
+			break
+		}
+		sb.WriteString(txt[:idx])
+		// Regions masked by a later pair can contain markers from an earlier one.
+		sb.WriteString(unmaskRegions(regions[n], marker, regions))
+		txt = txt[start+end+len(marker):]
+	}
+	sb.WriteString(txt)
+	return sb.String()
+}
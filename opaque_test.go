@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestOpaqueRegions(t *testing.T) {
+	values := map[string]string{"HOST": "example.com"}
+	opts := replacerOptions{Opaque: [][2]string{{"{{", "}}"}}}
+	r := buildNewReplacer([]byte("<::"), []byte("::>"), values, opts)
+
+	const in = `host: <::HOST::>
+tpl: {{ .Values.host | default "<::UNSET::>" }}
+<::#if has(HOST)::>
+helm: {{- include "x" . }}
+<::#end::>
+`
+	const want = `host: example.com
+tpl: {{ .Values.host | default "<::UNSET::>" }}
+helm: {{- include "x" . }}
+`
+	out, _, err := r([]byte(in))
+	if err != nil {
+		t.Fatalf("replacer: %v", err)
+	}
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
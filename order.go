@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+)
+
+// orderGroups are the -order patterns, matched against walked paths like
+// -include. A file belongs to the group of the first pattern it matches, or
+// to a last group of its own when it matches none; groups are rendered, and
+// handed to -apply-cmd, one after the other.
+type orderGroups []*regexp.Regexp
+
+// group returns the index of the group path belongs to.
+func (o orderGroups) group(path string) int {
+	path = filepath.ToSlash(path)
+	for i, rx := range o {
+		if rx.MatchString(path) {
+			return i
+		}
+	}
+	return len(o)
+}
+
+// name describes group i in messages.
+func (o orderGroups) name(i int) string {
+	if i == len(o) {
+		return "files matching no -order pattern"
+	}
+	return "-order " + o[i].String()
+}
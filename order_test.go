@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestProcessTree_Order(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell command")
+	}
+
+	src, capture := t.TempDir(), filepath.Join(t.TempDir(), "applied")
+	write := func(name, txt string) {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	for _, name := range []string{"deployments/web.yaml", "deployments/api.yaml", "extra.yaml"} {
+		write(name, "ns: <::NS::>\n")
+	}
+	write("namespaces/prod.yaml", "name: <::NS::>\n")
+	t.Setenv("CAPTURE", capture)
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	order, _ := compileAll([]string{`/namespaces/`, `/deployments/`})
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		Workers:    4,
+		KeyMap:     map[string]string{"NS": "prod"},
+		FileFilter: ff,
+		Order:      order,
+		// The namespace is slow to apply; without ordering the deployments
+		// would overtake it.
+		ApplyCmd: `case "$CHARMAP_FILE" in */namespaces/*) sleep 0.2;; esac; echo "$CHARMAP_FILE" >> "$CAPTURE"`,
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+	data, err := os.ReadFile(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := strings.Fields(string(data))
+	if len(applied) != 4 {
+		t.Fatalf("applied %v, want 4 files", applied)
+	}
+	groups := []string{"/namespaces/", "/deployments/", "/deployments/", "extra.yaml"}
+	for i, p := range applied {
+		if !strings.Contains(filepath.ToSlash(p), groups[i]) {
+			t.Errorf("applied in order %v, want namespaces, then deployments, then the rest", applied)
+			break
+		}
+	}
+
+	// A failing group stops the later ones.
+	write("namespaces/prod.yaml", "name: <::MISSING::>\n")
+	if err := os.Remove(capture); err != nil {
+		t.Fatal(err)
+	}
+	_, err = processTree(cfg)
+	if err == nil || !strings.Contains(err.Error(), "-order /namespaces/ failed, 3 file(s) of later groups were not rendered") {
+		t.Errorf("got error %v", err)
+	}
+	if _, err := os.Stat(capture); !os.IsNotExist(err) {
+		t.Errorf("files were applied after the failing group: %v", err)
+	}
+}
+
+func TestOrderGroups(t *testing.T) {
+	order, err := compileAll([]string{`^crds/`, `^namespaces/`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := orderGroups(order)
+	tests := map[string]int{
+		"crds/a.yaml":        0,
+		"namespaces/a.yaml":  1,
+		"app/crds/a.yaml":    2,
+		"deployments/a.yaml": 2,
+	}
+	for path, want := range tests {
+		if got := o.group(filepath.FromSlash(path)); got != want {
+			t.Errorf("group(%q) = %d, want %d", path, got, want)
+		}
+	}
+	if got := o.name(2); got != "files matching no -order pattern" {
+		t.Errorf("name(2) = %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// outPathTemplate computes destination paths from -out-path, e.g.
+// '{{dir}}/{{base | trimSuffix ".tpl"}}'. Each {{...}} is a filter pipeline
+// over one of the variables:
+//
+//	path  the template path relative to -dir, with '/' separators
+//	dir   the directory part of path ("." at the top)
+//	base  the file name
+//	name  the file name without its extension
+//	ext   the extension, including the dot
+//	env   the -profile name, empty without profiles
+//
+// The result is relative to -out (or the profile's -out-template directory).
+type outPathTemplate struct {
+	src   string
+	parts []outPathPart
+}
+
+type outPathPart struct {
+	lit  string
+	pipe *pipeline
+}
+
+func parseOutPath(src string) (*outPathTemplate, error) {
+	t := &outPathTemplate{src: src}
+	rest := src
+	for {
+		idx := strings.Index(rest, "{{")
+		if idx == -1 {
+			break
+		}
+		end := strings.Index(rest[idx+2:], "}}")
+		if end == -1 {
+			return nil, fmt.Errorf("-out-path %q: unterminated {{", src)
+		}
+		if idx > 0 {
+			t.parts = append(t.parts, outPathPart{lit: rest[:idx]})
+		}
+		p, err := parsePipeline(strings.TrimSpace(rest[idx+2 : idx+2+end]))
+		if err != nil {
+			return nil, fmt.Errorf("-out-path %q: %w", src, err)
+		}
+		t.parts = append(t.parts, outPathPart{pipe: &p})
+		rest = rest[idx+2+end+2:]
+	}
+	if rest != "" {
+		t.parts = append(t.parts, outPathPart{lit: rest})
+	}
+
+	// Catch unknown variables and filters before any file is rendered.
+	if _, err := t.render("dir/file.ext", "env"); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// render returns the destination for the template at rel, which is relative
+// to -dir.
+func (t *outPathTemplate) render(rel, env string) (string, error) {
+	rel = filepath.ToSlash(rel)
+	base := path.Base(rel)
+	vars := map[string]string{
+		"path": rel,
+		"dir":  path.Dir(rel),
+		"base": base,
+		"name": strings.TrimSuffix(base, path.Ext(base)),
+		"ext":  path.Ext(base),
+		"env":  env,
+	}
+
+	var sb strings.Builder
+	for _, part := range t.parts {
+		if part.pipe == nil {
+			sb.WriteString(part.lit)
+			continue
+		}
+		if _, ok := vars[part.pipe.key]; !ok {
+			return "", fmt.Errorf("-out-path %q: unknown variable %q", t.src, part.pipe.key)
+		}
+		v, err := part.pipe.eval(vars, nil)
+		if err != nil {
+			return "", fmt.Errorf("-out-path %q: %w", t.src, err)
+		}
+		sb.WriteString(v)
+	}
+
+	out := path.Clean(sb.String())
+	if out == "." || path.IsAbs(out) {
+		return "", fmt.Errorf("-out-path %q: %q is not a relative file path", t.src, out)
+	}
+	return filepath.FromSlash(out), nil
+}
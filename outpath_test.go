@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutPathTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl, rel, env, want string
+	}{
+		{`{{dir}}/{{base | trimSuffix ".tpl"}}`, "k8s/app.yaml.tpl", "", "k8s/app.yaml"},
+		{`{{base}}`, "a/b/c.yaml", "", "c.yaml"},
+		{`{{env}}/{{name}}-{{env | upper}}{{ext}}`, "app.yaml", "prod", "prod/app-PROD.yaml"},
+		{`{{path | replace "/" "_"}}`, "a/b.yaml", "", "a_b.yaml"},
+	}
+	for _, tt := range tests {
+		p, err := parseOutPath(tt.tmpl)
+		if err != nil {
+			t.Fatalf("parseOutPath(%q): %v", tt.tmpl, err)
+		}
+		got, err := p.render(filepath.FromSlash(tt.rel), tt.env)
+		if err != nil {
+			t.Errorf("%q on %q: %v", tt.tmpl, tt.rel, err)
+			continue
+		}
+		if got != filepath.FromSlash(tt.want) {
+			t.Errorf("%q on %q = %q, want %q", tt.tmpl, tt.rel, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{`{{nope}}`, `{{base | nope}}`, `{{dir`, `/{{base}}`} {
+		if _, err := parseOutPath(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestProcessFiles_OutPath(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "app.yaml.tpl"), []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	tmpl, err := parseOutPath(`{{base | trimSuffix ".tpl"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ff, _ := newFileFilter([]string{`\.tpl$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		OutPath:    tmpl,
+		Workers:    1,
+		KeyMap:     map[string]string{"V": "1"},
+		FileFilter: ff,
+		CloseLog:   func() {},
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(out, "app.yaml")); err != nil || string(got) != "v: 1\n" {
+		t.Errorf("flattened output = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"io/fs"
+	"os"
+)
+
+// skipReservedNames is false where DOS device names are ordinary file names.
+const skipReservedNames = false
+
+// longPath is the identity outside Windows.
+func longPath(path string) string {
+	return path
+}
+
+// writeFile writes data to dest. src is used on Windows to carry file
+// attributes over.
+func writeFile(src, dest string, data []byte, mode fs.FileMode) error {
+	return os.WriteFile(dest, data, mode)
+}
@@ -0,0 +1,83 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// skipReservedNames makes the walk skip files named after DOS devices, which
+// would otherwise open the device instead of the file.
+const skipReservedNames = true
+
+// maxPath is the length above which paths need the \\?\ prefix.
+const maxPath = 248
+
+// longPath returns path in \\?\ form when it is too long for the classic
+// Win32 APIs.
+func longPath(path string) string {
+	if len(path) < maxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+const keptAttributes = syscall.FILE_ATTRIBUTE_HIDDEN | syscall.FILE_ATTRIBUTE_READONLY
+
+func fileAttributes(path string) (uint32, error) {
+	p, err := syscall.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return 0, err
+	}
+	return syscall.GetFileAttributes(p)
+}
+
+func setFileAttributes(path string, attrs uint32) error {
+	p, err := syscall.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return err
+	}
+	return syscall.SetFileAttributes(p, attrs)
+}
+
+// writeFile writes data to dest, giving it the hidden and readonly attributes
+// of src. A readonly dest is made writable for the duration of the write. A
+// src that is not on disk, read from -from-archive or -git-ref, has no
+// attributes to give.
+func writeFile(src, dest string, data []byte, mode fs.FileMode) error {
+	srcAttrs, err := fileAttributes(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		srcAttrs, err = 0, nil
+	}
+	if err != nil {
+		return err
+	}
+	if attrs, err := fileAttributes(dest); err == nil && attrs&syscall.FILE_ATTRIBUTE_READONLY != 0 {
+		if err := setFileAttributes(dest, attrs&^syscall.FILE_ATTRIBUTE_READONLY); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(longPath(dest), data, mode); err != nil {
+		return err
+	}
+	if srcAttrs&keptAttributes == 0 {
+		return nil
+	}
+	attrs, err := fileAttributes(dest)
+	if err != nil {
+		return err
+	}
+	return setFileAttributes(dest, attrs|srcAttrs&keptAttributes)
+}
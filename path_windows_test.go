@@ -0,0 +1,82 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFileFilter_WindowsSeparators(t *testing.T) {
+	f, err := newFileFilter([]string{`^templates/.*\.yaml$`}, []string{`/vendor/`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.match(`templates\app\deploy.yaml`) {
+		t.Errorf("expected backslash path to match a '/' include")
+	}
+	if f.match(`templates\vendor\deploy.yaml`) {
+		t.Errorf("expected backslash path to match a '/' ignore")
+	}
+}
+
+func TestWriteFile_LongPathAndAttributes(t *testing.T) {
+	dir := t.TempDir()
+	long := filepath.Join(dir, strings.Repeat("d", 120), strings.Repeat("e", 120))
+	if err := os.MkdirAll(longPath(long), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	src := filepath.Join(long, "app.yaml")
+	if err := os.WriteFile(longPath(src), []byte("<::K::>"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	attrs, err := fileAttributes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setFileAttributes(src, attrs|syscall.FILE_ATTRIBUTE_HIDDEN|syscall.FILE_ATTRIBUTE_READONLY); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFile(src, src, []byte("v"), 0o644); err != nil {
+		t.Fatalf("writeFile over readonly file: %v", err)
+	}
+	got, err := os.ReadFile(longPath(src))
+	if err != nil || string(got) != "v" {
+		t.Fatalf("read back: %q, %v", got, err)
+	}
+	attrs, err = fileAttributes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs&keptAttributes != keptAttributes {
+		t.Errorf("attributes not preserved: %#x", attrs)
+	}
+	_ = setFileAttributes(src, attrs&^syscall.FILE_ATTRIBUTE_READONLY)
+}
+
+func TestProcessTree_ArchiveInput(t *testing.T) {
+	out := t.TempDir()
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  ".",
+		OutDir:     out,
+		Workers:    1,
+		KeyMap:     map[string]string{"V": "1"},
+		FileFilter: ff,
+		// Not on disk, so the template has no attributes to copy.
+		Input: fsSource{fstest.MapFS{"archived-only.yaml": {Data: []byte("v: <::V::>\n")}}},
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "archived-only.yaml")); string(got) != "v: 1\n" {
+		t.Errorf("archived-only.yaml = %q, want it rendered", got)
+	}
+}
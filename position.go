@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// placeholderError is an error rendering one placeholder. Once located in the
+// template it reports the line and column of the placeholder and quotes the
+// template line with a caret under it.
+type placeholderError struct {
+	expr string // the placeholder, delimiters included
+	err  error
+	// nth counts the earlier occurrences of expr in the text rendered. Plain
+	// keys are substituted before pipelines are evaluated, so the renderer
+	// turns it into at, the byte offset of expr in the text it was given,
+	// which the callers above shift by where that text starts.
+	nth    int
+	at     int
+	placed bool
+
+	file      string // the #include partial holding the placeholder
+	line, col int
+	context   string
+	caret     string // indentation of the caret under context
+	values    map[string]string
+}
+
+func (e *placeholderError) Error() string {
+	if e.line == 0 {
+		return e.err.Error()
+	}
+	if e.file != "" {
+		return fmt.Sprintf("%s: line %d, column %d: %v\n\t%s\n\t%s^", e.file, e.line, e.col, e.err, e.context, e.caret)
+	}
+	return fmt.Sprintf("line %d, column %d: %v\n\t%s\n\t%s^", e.line, e.col, e.err, e.context, e.caret)
+}
+
+func (e *placeholderError) Unwrap() error { return e.err }
+
+// place records that the placeholder starts at byte offset at of txt, the
+// nth occurrence of expr in it, or nowhere when it has none.
+func (e *placeholderError) place(txt string) {
+	e.at = nthIndex(txt, e.expr, e.nth)
+	e.placed = e.at != -1
+}
+
+// shift moves the offset of a placed error by n bytes, for text that starts
+// n bytes into the text it was cut from.
+func (e *placeholderError) shift(n int) {
+	if e.placed {
+		e.at += n
+	}
+}
+
+// locate records the position of the placeholder in txt, the template being
+// rendered, at the offset the renderer placed it or else at its first
+// occurrence. The values of secret looking keys are masked in the quoted
+// line, since an earlier -stage pass may have rendered them into the
+// template.
+func (e *placeholderError) locate(txt string, values map[string]string) {
+	idx := strings.Index(txt, e.expr)
+	if e.placed && e.at <= len(txt)-len(e.expr) && txt[e.at:e.at+len(e.expr)] == e.expr {
+		idx = e.at
+	}
+	if idx == -1 {
+		return
+	}
+	e.at, e.placed, e.values = idx, true, values
+	e.position(txt, idx)
+}
+
+// position sets the line, column and quoted line of offset idx of txt.
+func (e *placeholderError) position(txt string, idx int) {
+	start := strings.LastIndexByte(txt[:idx], '\n') + 1
+	end := strings.IndexByte(txt[idx:], '\n')
+	if end == -1 {
+		end = len(txt)
+	} else {
+		end += idx
+	}
+	e.line = strings.Count(txt[:idx], "\n") + 1
+	e.col = utf8.RuneCountInString(txt[start:idx]) + 1
+	before, after := redactSecrets(txt[start:idx], e.values), redactSecrets(strings.TrimSuffix(txt[idx:end], "\r"), e.values)
+	e.context = before + after
+	e.caret = caretIndent(before)
+}
+
+// relocate moves a located error from the include-expanded template to the
+// file its placeholder was read from, the template itself or a partial.
+func (e *placeholderError) relocate(path string, spans []includeSpan) {
+	if !e.placed || len(spans) < 2 {
+		return
+	}
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].start > e.at }) - 1
+	if i < 0 {
+		return
+	}
+	s := spans[i]
+	idx := s.at + e.at - s.start
+	if idx+len(e.expr) > len(s.src) || string(s.src[idx:idx+len(e.expr)]) != e.expr {
+		return
+	}
+	if s.path != path {
+		e.file = s.path
+	}
+	e.position(string(s.src), idx)
+}
+
+// nthIndex returns the index of occurrence n, counting from 0, of sub in s,
+// or -1 if s has fewer.
+func nthIndex(s, sub string, n int) int {
+	off := 0
+	for {
+		i := strings.Index(s[off:], sub)
+		if i == -1 {
+			return -1
+		}
+		if n == 0 {
+			return off + i
+		}
+		n--
+		off += i + len(sub)
+	}
+}
+
+// redactedValue replaces secret values in quoted template text.
+const redactedValue = "<redacted>"
+
+// secretWords mark a key whose value must not be shown.
+var secretWords = []string{"SECRET", "TOKEN", "PASSWORD", "PASSWD", "PASSPHRASE", "CREDENTIAL", "PRIVATE", "API_KEY", "APIKEY", "ACCESS_KEY"}
+
+// looksSecret reports whether key names a secret by convention, e.g.
+// DB_PASSWORD or GITHUB_TOKEN.
+func looksSecret(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, w := range secretWords {
+		if strings.Contains(upper, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSecrets replaces the values of secret looking keys in s.
+func redactSecrets(s string, values map[string]string) string {
+	for k, v := range values {
+		if v != "" && looksSecret(k) {
+			s = strings.ReplaceAll(s, v, redactedValue)
+		}
+	}
+	return s
+}
+
+// caretIndent returns the whitespace as wide as prefix, keeping its tabs so a
+// caret after it lines up however tabs are shown.
+func caretIndent(prefix string) string {
+	var sb strings.Builder
+	for _, r := range prefix {
+		if r == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholderErrorPosition(t *testing.T) {
+	values := map[string]string{"DB_PASSWORD": "hunter2", "HOST": "db"}
+	r := buildNewReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{})
+
+	tests := []struct {
+		in, want string
+	}{
+		{
+			"a: 1\n\turl: <::HOST::>/<::PORT::>\n",
+			"line 2, column 18: env/flag \"PORT\" not set\n\t\turl: <::HOST::>/<::PORT::>\n\t\t                ^",
+		},
+		{
+			"x: <::HOST | nosuch::>\n",
+			"line 1, column 4: unknown filter \"nosuch\"\n\tx: <::HOST | nosuch::>\n\t   ^",
+		},
+		{
+			"dsn: hunter2@<::MISSING::>\n",
+			"line 1, column 14: env/flag \"MISSING\" not set\n\tdsn: <redacted>@<::MISSING::>\n\t                ^",
+		},
+	}
+	for _, tt := range tests {
+		_, _, err := r([]byte(tt.in))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%q:\ngot  %v\nwant %s", tt.in, err, tt.want)
+		}
+	}
+
+	_, _, err := r([]byte("<::NOPE::>"))
+	var mk *missingKeyError
+	if !errors.As(err, &mk) || mk.key != "NOPE" {
+		t.Errorf("got %v, want a missingKeyError for NOPE", err)
+	}
+}
+
+func TestPlaceholderErrorPosition_Offset(t *testing.T) {
+	values := map[string]string{"HOST": "db"}
+	tests := []struct {
+		name string
+		opts replacerOptions
+		in   string
+		want string
+	}{
+		{
+			// The first occurrence is opaque, the second one fails.
+			name: "opaque",
+			opts: replacerOptions{Opaque: [][2]string{{"/*", "*/"}}},
+			in:   "/* <::HOST | nosuch::> */\nx: <::HOST | nosuch::>\n",
+			want: "line 2, column 4:",
+		},
+		{
+			// Earlier plain keys change length when substituted.
+			name: "substituted",
+			in:   "a: <::HOST::><::HOST::>\nb: <::HOST::> <::MISSING | upper::>\n",
+			want: "line 2, column 15:",
+		},
+		{
+			name: "pragma region",
+			in:   "a: <::HOST::>\n# charmap delims: [[ ]]\nb: [[HOST]]\nc: [[NOPE]]\n",
+			want: "line 4, column 4:",
+		},
+		{
+			name: "chunks",
+			opts: replacerOptions{ChunkSize: 8},
+			in:   "a: <::HOST::>\nb: <::HOST::>\nc: <::NOPE::>\n",
+			want: "line 3, column 4:",
+		},
+	}
+	for _, tt := range tests {
+		r := buildNewReplacer([]byte("<::"), []byte("::>"), values, tt.opts)
+		_, _, err := r([]byte(tt.in))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPlaceholderErrorPosition_Include(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "part.yaml"), []byte("p: 1\nq: <::NOPE::>\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "app.yaml")
+	if err := os.WriteFile(path, []byte("a: 1\nb: 2\n<::#include \"part.yaml\"::>\nc: <::NOPE::>\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{OpenDelim: "<::", CloseDelim: "::>", TargetDir: dir}
+	err := renderFile(path, cfg, io.Discard)
+	want := filepath.Join(dir, "part.yaml") + ": line 2, column 4:"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
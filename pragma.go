@@ -0,0 +1,49 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// delimPragma matches a line such as `# charmap delims: [[ ]]` in any common
+// comment syntax. It switches delimiters for the rest of the file.
+var delimPragma = regexp.MustCompile(`(?m)^[ \t]*(?:#|//|--|;|<!--)[ \t]*charmap delims:[ \t]*(\S+)[ \t]+(\S+?)(?:[ \t]+-->)?[ \t]*\r?(?:\n|$)`)
+
+// delimRegion is a slice of a file rendered with one pair of delimiters. Empty
+// open and close mean the configured defaults.
+type delimRegion struct {
+	open, close string
+	text        string
+	line        int
+	offset      int // byte offset of text in the file
+}
+
+// splitDelimPragmas cuts txt at every delimiter pragma. Pragma lines are
+// dropped from the output.
+func splitDelimPragmas(txt string) []delimRegion {
+	if !strings.Contains(txt, "charmap delims:") {
+		return []delimRegion{{text: txt, line: 1}}
+	}
+
+	matches := delimPragma.FindAllStringSubmatchIndex(txt, -1)
+	if len(matches) == 0 {
+		return []delimRegion{{text: txt, line: 1}}
+	}
+
+	regions := make([]delimRegion, 0, len(matches)+1)
+	cur := delimRegion{line: 1}
+	pos := 0
+	for _, m := range matches {
+		cur.text = txt[pos:m[0]]
+		regions = append(regions, cur)
+		pos = m[1]
+		cur = delimRegion{
+			open:   txt[m[2]:m[3]],
+			close:  txt[m[4]:m[5]],
+			line:   strings.Count(txt[:pos], "\n") + 1,
+			offset: pos,
+		}
+	}
+	cur.text = txt[pos:]
+	return append(regions, cur)
+}
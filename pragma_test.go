@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestDelimPragma(t *testing.T) {
+	values := map[string]string{"HOST": "example.com"}
+	r := buildNewReplacer([]byte("{{"), []byte("}}"), values, replacerOptions{})
+
+	const in = `host: {{HOST}}
+# charmap delims: [[ ]]
+script: echo "{{ not_ours }}" [[HOST]]
+<!-- charmap delims: {{ }} -->
+again: {{HOST}}
+`
+	const want = `host: example.com
+script: echo "{{ not_ours }}" example.com
+again: example.com
+`
+	out, changed, err := r([]byte(in))
+	if err != nil {
+		t.Fatalf("replacer: %v", err)
+	}
+	if string(out) != want || !changed {
+		t.Errorf("got %q (changed=%v), want %q", out, changed, want)
+	}
+}
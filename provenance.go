@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// logKeyOrigins logs the source of every key at debug level. Values are never
+// logged.
+func logKeyOrigins(cfg config) {
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	sets := []profile{{KeyMap: cfg.KeyMap, Origins: cfg.Origins}}
+	if len(cfg.Profiles) > 0 {
+		sets = cfg.Profiles
+	}
+	for _, ks := range sets {
+		keys := make([]string, 0, len(ks.Origins))
+		for k := range ks.Origins {
+			if _, ok := ks.KeyMap[k]; ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			slog.Debug("key source", slog.String("key", k), slog.String("source", ks.Origins[k]),
+				slog.String("profile", ks.Name))
+		}
+	}
+}
+
+// referencedKeys returns the keys of every placeholder in txt, plain or
+// filtered, in order of first appearance.
+func referencedKeys(txt, open, close string) []string {
+	if open == "" || close == "" {
+		return nil
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	for {
+		idx := strings.Index(txt, open)
+		if idx == -1 {
+			return keys
+		}
+		txt = txt[idx+len(open):]
+		end := strings.Index(txt, close)
+		if end == -1 {
+			return keys
+		}
+		expr := strings.TrimSpace(txt[:end])
+		key, _, _ := strings.Cut(expr, " ")
+		key, _, _ = strings.Cut(key, "|")
+		if key != "" && key[0] != '#' && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+		txt = txt[end+len(close):]
+	}
+}
+
+// manifestKey records where the value of a key used by the run came from.
+type manifestKey struct {
+	Key     string `json:"key"`
+	Source  string `json:"source"`
+	Profile string `json:"profile,omitempty"`
+}
+
+// keyProvenance lists the source of every key referenced by the rendered
+// files, once per profile. Keys without a value, or only set by a sidecar
+// file, are left out.
+func keyProvenance(cfg config, results []fileResult) []manifestKey {
+	origins := map[string]map[string]string{"": cfg.Origins}
+	for _, p := range cfg.Profiles {
+		origins[p.Name] = p.Origins
+	}
+
+	seen := make(map[manifestKey]bool)
+	var keys []manifestKey
+	for _, r := range results {
+		for _, k := range r.Keys {
+			src, ok := origins[r.Profile][k]
+			if !ok {
+				continue
+			}
+			mk := manifestKey{Key: k, Source: src, Profile: r.Profile}
+			if !seen[mk] {
+				seen[mk] = true
+				keys = append(keys, mk)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Key != keys[j].Key {
+			return keys[i].Key < keys[j].Key
+		}
+		return keys[i].Profile < keys[j].Profile
+	})
+	return keys
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyProvenance(t *testing.T) {
+	if got, want := referencedKeys("<::A::> <::B | upper::> <::#if has(C)::><::A::><::#end::>", "<::", "::>"), []string{"A", "B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("referencedKeys = %v, want %v", got, want)
+	}
+
+	cfg := config{
+		Origins: map[string]string{"A": "env", "B": "values:base.env"},
+		Profiles: []profile{
+			{Name: "prod", Origins: map[string]string{"A": "profile:prod.env", "B": "set"}},
+		},
+	}
+	results := []fileResult{
+		{Path: "x.yaml", Keys: []string{"B", "A", "MISSING"}},
+		{Path: "y.yaml", Keys: []string{"A"}},
+		{Path: "x.yaml", Profile: "prod", Keys: []string{"A"}},
+	}
+	want := []manifestKey{
+		{Key: "A", Source: "env"},
+		{Key: "A", Source: "profile:prod.env", Profile: "prod"},
+		{Key: "B", Source: "values:base.env"},
+	}
+	if got := keyProvenance(cfg, results); !reflect.DeepEqual(got, want) {
+		t.Errorf("keyProvenance = %+v, want %+v", got, want)
+	}
+}
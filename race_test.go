@@ -0,0 +1,7 @@
+//go:build race
+
+package main
+
+// raceEnabled reports whether the tests run under the race detector, whose
+// instrumentation allocates enough to break allocation budgets.
+const raceEnabled = true
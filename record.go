@@ -0,0 +1,401 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// recordManifest is the name of the run description inside a -record tar.
+// Templates are stored below recordTree.
+const (
+	recordManifest = "run.json"
+	recordTree     = "tree/"
+)
+
+// recordedFlags are the flags that change how a tree renders. They are
+// recorded with their values.
+var recordedFlags = []string{
+	"open", "close", "include", "ignore", "ignore-content", "opaque", "ext-delims", "syntax", "stage",
+	"engine", "chunk-size", "strict-count", "max-value-bytes", "max-value-lines", "value-limit-policy",
+	"fs-case", "hard-links", "on-mutation", "normalize", "head-bytes", "invalid-utf8",
+	"missing-placeholder", "skip-vendored", "scoped-values", "force",
+}
+
+// archivedFlags are the rendering flags that name files or directories. What
+// they name is archived below archiveDir and replay points them there.
+var archivedFlags = []string{"template-path", "filters", "allow-keys"}
+
+// unrecordedFlags are the flags that do not change how a tree renders: value
+// sources, destinations, logging and the flags of other commands. A new flag
+// must be added to one of the three lists, see TestRecordedFlags.
+var unrecordedFlags = []string{
+	"config", "dir", "out", "golden", "update", "workers", "queue-depth", "mode", "log",
+	"errors-format", "errors-file", "log-level", "cpuprofile", "set", "values", "profile",
+	"source", "order", "watch", "watch-interval", "signal-pid", "metrics-file", "max-files", "max-total-bytes",
+	"secrets-dir", "out-tar", "out-template", "out-path", "encrypt", "apply-cmd", "allow-outside",
+	"changed-keys", "history", "manifest", "header", "summary", "from", "to", "dry-run", "i", "o",
+	"downward-dir", "builtins", "expand-json-env", "from-archive", "git-ref", "run-lock",
+	"editor-locks", "size", "keys", "seed", "files", "docs", "resolved", "show-values", "docs-format",
+	"graph-format", "trace-file", "record", "replay", "lock", "frozen", "values-lock",
+	"checksums", "changed-exit-code", "sign", "readable-secrets",
+}
+
+// archiveDir holds the files and directories of archivedFlags, each below
+// its flag's name and its position among the flag's values, e.g.
+// files/template-path/0/.
+const archiveDir = "files/"
+
+// recording is the run.json of a -record archive.
+type recording struct {
+	// Flags holds the recorded flags as -name=value arguments.
+	Flags []string `json:"flags"`
+	// Files maps each archived flag that was set to the names, in the
+	// archive, of what its values name.
+	Files map[string][]string `json:"files,omitempty"`
+	// Values maps every key the templates reference to its redacted value.
+	Values map[string]string `json:"values"`
+}
+
+// readRecorder is an inputSource that keeps every file read through it, so
+// that a recording holds the partials, sidecars and scoped values files a
+// render reads besides its templates.
+type readRecorder struct {
+	inputSource
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (r *readRecorder) readFile(path string) ([]byte, error) {
+	data, err := r.inputSource.readFile(path)
+	if err == nil {
+		r.mu.Lock()
+		r.files[path] = data
+		r.mu.Unlock()
+	}
+	return data, err
+}
+
+// writeRecording archives the matching templates under cfg.TargetDir as they
+// are before the run, with every partial, sidecar and scoped values file they
+// read, the rendering flags and the referenced keys with redacted values, see
+// -record. Values in sidecars and scoped values files are redacted as well.
+func writeRecording(dest string, cfg config) error {
+	rec := recording{Flags: []string{}, Files: make(map[string][]string), Values: make(map[string]string)}
+	var roots []string    // archive names of the -template-path roots
+	var extra [][2]string // files named by archivedFlags: path, archive name
+	flag.Visit(func(fl *flag.Flag) {
+		if !slices.Contains(recordedFlags, fl.Name) {
+			return
+		}
+		if s, ok := fl.Value.(*sliceFlag); ok {
+			for _, v := range *s {
+				rec.Flags = append(rec.Flags, "-"+fl.Name+"="+v)
+			}
+			return
+		}
+		rec.Flags = append(rec.Flags, "-"+fl.Name+"="+fl.Value.String())
+	})
+	for i, root := range cfg.TemplatePath {
+		name := fmt.Sprintf("%stemplate-path/%d", archiveDir, i)
+		if rel, err := relativeTo(cfg.TargetDir, root); err == nil && (rel == "." || filepath.IsLocal(rel)) {
+			name = path.Join(strings.TrimSuffix(recordTree, "/"), filepath.ToSlash(rel))
+		}
+		roots = append(roots, name)
+	}
+	if len(roots) > 0 {
+		rec.Files["template-path"] = roots
+	}
+	for i, p := range filterFiles {
+		extra = append(extra, [2]string{p, fmt.Sprintf("%sfilters/%d/%s", archiveDir, i, filepath.Base(p))})
+		rec.Files["filters"] = append(rec.Files["filters"], extra[len(extra)-1][1])
+	}
+	if *allowKeysFile != "" {
+		extra = append(extra, [2]string{*allowKeysFile, archiveDir + "allow-keys/0/" + filepath.Base(*allowKeysFile)})
+		rec.Files["allow-keys"] = []string{extra[len(extra)-1][1]}
+	}
+
+	src := &readRecorder{inputSource: cfg.input(), files: make(map[string][]byte)}
+	cfg.Input, cfg.Scopes = src, nil
+	err := walkFiles(cfg, func(p string) error {
+		in, err := src.readFile(p)
+		if err != nil {
+			return err
+		}
+		if _, _, err := loadLocalValues(p, cfg); err != nil {
+			return err
+		}
+		keys, err := templateKeys(p, string(in), cfg, cfg.KeyMap)
+		if err != nil {
+			return fmt.Errorf("failed to scan %q: %w", p, err)
+		}
+		for _, k := range keys {
+			if v, ok := cfg.KeyMap[k]; ok {
+				rec.Values[k] = redactValue(v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string][]byte, len(src.files)+len(extra))
+	for p, data := range src.files {
+		name, err := archiveName(cfg, roots, p)
+		if err != nil {
+			return err
+		}
+		if base := filepath.Base(p); strings.HasSuffix(base, sidecarSuffix) || base == cfg.ScopedValues {
+			if data, err = redactValuesFile(base, data); err != nil {
+				return fmt.Errorf("failed to redact %q: %w", p, err)
+			}
+		}
+		entries[name] = data
+	}
+	for _, e := range extra {
+		data, err := os.ReadFile(e[0])
+		if err != nil {
+			return err
+		}
+		entries[e[1]] = data
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	entries[recordManifest] = data
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(f)
+	for _, name := range slices.Sorted(maps.Keys(entries)) {
+		if err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(entries[name]))}); err != nil {
+			break
+		}
+		if _, err = tw.Write(entries[name]); err != nil {
+			break
+		}
+	}
+	return errors.Join(err, tw.Close(), f.Close())
+}
+
+// archiveName returns the name of the file p, read during a recording, in
+// the archive: below recordTree for the tree and below the archive name of
+// its -template-path root, roots, otherwise.
+func archiveName(cfg config, roots []string, p string) (string, error) {
+	if rel, err := relativeTo(cfg.TargetDir, p); err == nil && filepath.IsLocal(rel) {
+		return recordTree + filepath.ToSlash(rel), nil
+	}
+	for i, root := range cfg.TemplatePath {
+		if rel, err := relativeTo(root, p); err == nil && filepath.IsLocal(rel) {
+			return roots[i] + "/" + filepath.ToSlash(rel), nil
+		}
+	}
+	return "", fmt.Errorf("cannot record %q, it lies outside -dir and -template-path", p)
+}
+
+// redactValuesFile returns the sidecar or scoped values file named base, data,
+// with every value redacted by redactFileValue, in the same format.
+func redactValuesFile(base string, data []byte) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(base))
+	if strings.HasSuffix(base, sidecarSuffix) {
+		ext = ""
+	}
+	values, err := parseValues(data, ext)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range values {
+		values[k] = redactFileValue(v)
+	}
+	if ext == ".json" {
+		return json.MarshalIndent(values, "", "  ")
+	}
+	sep := "="
+	if ext == ".yaml" || ext == ".yml" {
+		sep = ": "
+	}
+	var buf bytes.Buffer
+	for _, k := range slices.Sorted(maps.Keys(values)) {
+		fmt.Fprintf(&buf, "%s%s%s\n", k, sep, strconv.Quote(values[k]))
+	}
+	return buf.Bytes(), nil
+}
+
+// redactFileValue redacts v as written in a values file. What the file gives
+// a meaning to survives, so that a replay resolves it the same way: ${KEY}
+// references are kept, and a b64: or hex: value is redacted once decoded and
+// encoded again.
+func redactFileValue(v string) string {
+	switch {
+	case strings.HasPrefix(v, "b64:"):
+		if data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v[len("b64:"):])); err == nil {
+			return "b64:" + base64.StdEncoding.EncodeToString([]byte(redactValue(string(data))))
+		}
+	case strings.HasPrefix(v, "hex:"):
+		if data, err := hex.DecodeString(strings.TrimSpace(v[len("hex:"):])); err == nil {
+			return "hex:" + hex.EncodeToString([]byte(redactValue(string(data))))
+		}
+	}
+	var sb strings.Builder
+	for {
+		idx := strings.Index(v, "${")
+		if idx == -1 {
+			break
+		}
+		end := strings.IndexByte(v[idx:], '}')
+		if end == -1 {
+			break
+		}
+		sb.WriteString(redactValue(v[:idx]))
+		sb.WriteString(v[idx : idx+end+1])
+		v = v[idx+end+1:]
+	}
+	sb.WriteString(redactValue(v))
+	return sb.String()
+}
+
+// redactValue keeps the shape of v, which is what usually matters when a
+// render goes wrong: whitespace, newlines and punctuation, including any
+// delimiters, are kept while letters become x and digits 0.
+func redactValue(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r):
+			return 'x'
+		case unicode.IsDigit(r):
+			return '0'
+		}
+		return r
+	}, v)
+}
+
+// loadRecording extracts a -record archive into a new temporary directory and
+// returns it with the recording. The templates are in its recordTree.
+func loadRecording(src string) (recording, string, error) {
+	var rec recording
+	f, err := os.Open(src)
+	if err != nil {
+		return rec, "", err
+	}
+	defer f.Close()
+
+	dir, err := os.MkdirTemp("", "charmap-replay-")
+	if err != nil {
+		return rec, "", err
+	}
+	var found bool
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rec, "", fmt.Errorf("read %s: %w", src, err)
+		}
+		switch name := path.Clean(hdr.Name); {
+		case name == recordManifest:
+			if err := json.NewDecoder(tr).Decode(&rec); err != nil {
+				return rec, "", fmt.Errorf("read %s: %w", recordManifest, err)
+			}
+			found = true
+		case hdr.Typeflag == tar.TypeReg:
+			if !filepath.IsLocal(filepath.FromSlash(name)) {
+				return rec, "", fmt.Errorf("%s: entry %q escapes the archive", src, hdr.Name)
+			}
+			out := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+				return rec, "", err
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return rec, "", err
+			}
+			if err := os.WriteFile(out, data, 0o644); err != nil {
+				return rec, "", err
+			}
+		}
+	}
+	if !found {
+		return rec, "", fmt.Errorf("%s: no %s, not a charmap recording", src, recordManifest)
+	}
+	return rec, dir, nil
+}
+
+// applyRecording sets up a -replay run of the archive at src: it is extracted
+// to a temporary directory whose recordTree becomes -dir, its flags apply
+// unless given on the command line, archived ones naming the extracted
+// files, and its redacted values are the -set values, with -mode flag.
+func applyRecording(src string) error {
+	rec, base, err := loadRecording(src)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(base, filepath.FromSlash(recordTree))
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	for _, arg := range rec.Flags {
+		name, value, ok := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+		if !ok || given[name] || !slices.Contains(recordedFlags, name) {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("flag %q: %w", name, err)
+		}
+	}
+	for name, files := range rec.Files {
+		if given[name] || !slices.Contains(archivedFlags, name) {
+			continue
+		}
+		paths := make([]string, len(files))
+		for i, f := range files {
+			if paths[i] = filepath.Join(base, filepath.FromSlash(f)); !strings.HasPrefix(paths[i], base+string(filepath.Separator)) {
+				return fmt.Errorf("flag %q: %q escapes the archive", name, f)
+			}
+		}
+		if name == "template-path" {
+			// A root no partial was read from is not in the archive.
+			for _, p := range paths {
+				if err := os.MkdirAll(p, 0o755); err != nil {
+					return err
+				}
+			}
+			paths = []string{strings.Join(paths, string(filepath.ListSeparator))}
+		}
+		for _, p := range paths {
+			if err := flag.Set(name, p); err != nil {
+				return fmt.Errorf("flag %q: %w", name, err)
+			}
+		}
+	}
+	for k, v := range rec.Values {
+		if _, ok := userKV[k]; !ok {
+			userKV[k] = v
+		}
+	}
+	if err := flag.Set("dir", dir); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "replaying %s in %s\n", src, dir)
+	return flag.Set("mode", "flag")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRecording(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"app.yaml":    "token: <::TOKEN::>\n",
+		"sub/db.yaml": "url: <::URL::>\n",
+		"notes.txt":   "not a template\n",
+		"sub/x.yaml":  "plain: text\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		FileFilter: ff,
+		KeyMap:     map[string]string{"TOKEN": "Abc-123", "URL": "pg://db:5432/<::x::>\n", "HOME": "/root"},
+	}
+	archive := filepath.Join(t.TempDir(), "run.tar")
+	if err := writeRecording(archive, cfg); err != nil {
+		t.Fatalf("writeRecording: %v", err)
+	}
+
+	rec, dir, err := loadRecording(archive)
+	if err != nil {
+		t.Fatalf("loadRecording: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if want := map[string]string{"TOKEN": "xxx-000", "URL": "xx://xx:0000/<::x::>\n"}; !reflect.DeepEqual(rec.Values, want) {
+		t.Errorf("values = %q, want %q", rec.Values, want)
+	}
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(dir, "tree", name))
+		if filepath.Ext(name) != ".yaml" {
+			if err == nil {
+				t.Errorf("%s was recorded", name)
+			}
+			continue
+		}
+		if string(got) != data {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, data)
+		}
+	}
+}
+
+func TestRecording_LocalFiles(t *testing.T) {
+	src, partials := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(src, "app.yaml"):                    "<::#include \"head.tpl\"::>\nuser: <::USER::>\n",
+		filepath.Join(src, "app.yaml"+sidecarSuffix):      "USER=admin-${REGION}\nKEY=hex:414243\n",
+		filepath.Join(src, "sub", "db.yaml"):              "url: <::URL::>\n",
+		filepath.Join(src, "values.env"):                  "URL=pg://db:5432\n",
+		filepath.Join(partials, "head.tpl"):               "region: <::REGION::>\n",
+		filepath.Join(src, "sub", "unread"+sidecarSuffix): "X=secret\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:    "<::",
+		CloseDelim:   "::>",
+		TargetDir:    src,
+		FileFilter:   ff,
+		TemplatePath: []string{partials},
+		ScopedValues: "values.env",
+		KeyMap:       map[string]string{"REGION": "eu-west-1"},
+	}
+	archive := filepath.Join(t.TempDir(), "run.tar")
+	if err := writeRecording(archive, cfg); err != nil {
+		t.Fatalf("writeRecording: %v", err)
+	}
+	rec, dir, err := loadRecording(archive)
+	if err != nil {
+		t.Fatalf("loadRecording: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if want := []string{"files/template-path/0"}; !reflect.DeepEqual(rec.Files["template-path"], want) {
+		t.Errorf("template-path = %q, want %q", rec.Files["template-path"], want)
+	}
+	want := map[string]string{
+		"tree/app.yaml":                  files[filepath.Join(src, "app.yaml")],
+		"tree/app.yaml" + sidecarSuffix:  "KEY=\"hex:787878\"\nUSER=\"xxxxx-${REGION}\"\n",
+		"tree/sub/db.yaml":               files[filepath.Join(src, "sub", "db.yaml")],
+		"tree/values.env":                "URL=\"xx://xx:0000\"\n",
+		"files/template-path/0/head.tpl": files[filepath.Join(partials, "head.tpl")],
+	}
+	for name, w := range want {
+		if got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); string(got) != w {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, w)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tree", "sub", "unread"+sidecarSuffix)); err == nil {
+		t.Error("a sidecar no template reads was recorded")
+	}
+
+	// The archive renders on its own, as a replay does.
+	cfg.TargetDir = filepath.Join(dir, "tree")
+	cfg.TemplatePath = []string{filepath.Join(dir, "files", "template-path", "0")}
+	cfg.KeyMap = rec.Values
+	cfg.Workers = 1
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("render the recording: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(cfg.TargetDir, "app.yaml")); string(got) != "region: xx-xxxx-0\nuser: xxxxx-xx-xxxx-0\n" {
+		t.Errorf("replayed app.yaml = %q", got)
+	}
+}
+
+func TestRecordedFlags(t *testing.T) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			return
+		}
+		n := 0
+		for _, list := range [][]string{recordedFlags, archivedFlags, unrecordedFlags} {
+			if slices.Contains(list, f.Name) {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Errorf("flag -%s is in %d of recordedFlags, archivedFlags and unrecordedFlags, want 1", f.Name, n)
+		}
+	})
+}
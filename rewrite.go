@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// rewriteCmd edits the templates under -dir in place: -from '<::OLD::>' -to
+// '<::NEW::>' renames a key everywhere it is referenced, any other -from is
+// replaced literally by -to, e.g. a value by the placeholder that should
+// produce it. With -dry-run it prints a diff instead of writing.
+func rewriteCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("rewrite: unexpected arguments %v", args)
+	}
+	if cfg.RewriteFrom == "" {
+		return fmt.Errorf("rewrite: -from must be set")
+	}
+
+	n, err := rewriteTree(cfg, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if cfg.DryRun {
+		fmt.Printf("%d file(s) would change\n", n)
+	} else {
+		fmt.Printf("%d file(s) rewritten\n", n)
+	}
+	return nil
+}
+
+// rewriteTree applies the -from/-to rewrite to every matching file and
+// returns how many changed. With cfg.DryRun it writes a unified diff per file
+// to w instead of rewriting it.
+func rewriteTree(cfg config, w io.Writer) (int, error) {
+	rewrite := func(path, txt string) string {
+		return strings.ReplaceAll(txt, cfg.RewriteFrom, cfg.RewriteTo)
+	}
+	oldKey, okOld := plainKey(cfg.RewriteFrom, cfg.OpenDelim, cfg.CloseDelim)
+	newKey, okNew := plainKey(cfg.RewriteTo, cfg.OpenDelim, cfg.CloseDelim)
+	if okOld && okNew {
+		// The key is renamed in the delimiters of each file, -ext-delims
+		// included.
+		rewrite = func(path, txt string) string {
+			open, close := cfg.delims(path)
+			return renameKey(txt, open, close, oldKey, newKey)
+		}
+	}
+
+	var n int
+	err := walkFiles(cfg, func(path string) error {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if cfg.skipContent(in) {
+			return nil
+		}
+		out := rewrite(path, string(in))
+		if out == string(in) {
+			return nil
+		}
+		n++
+		if cfg.DryRun {
+			_, err := io.WriteString(w, unifiedDiff(path, path, in, []byte(out)))
+			return err
+		}
+		return writeFile(path, path, []byte(out), fi.Mode())
+	})
+	return n, err
+}
+
+// plainKey returns KEY when s is exactly one placeholder open+KEY+close
+// without filters.
+func plainKey(s, open, close string) (string, bool) {
+	if !strings.HasPrefix(s, open) || !strings.HasSuffix(s, close) || len(s) <= len(open)+len(close) {
+		return "", false
+	}
+	key := strings.TrimSpace(s[len(open) : len(s)-len(close)])
+	if key == "" || strings.ContainsAny(key, " \t|#") || strings.Contains(key, open) {
+		return "", false
+	}
+	return key, true
+}
+
+// renameKey renames the key old to new in every placeholder of txt: plain
+// ones, the key of filter pipelines and identifiers in #if and #elif
+// conditions. Formatting and everything else is kept.
+func renameKey(txt, open, close, old, new string) string {
+	var sb strings.Builder
+	pos := 0
+	for {
+		idx := strings.Index(txt[pos:], open)
+		if idx == -1 {
+			break
+		}
+		start := pos + idx + len(open)
+		end := strings.Index(txt[start:], close)
+		if end == -1 {
+			break
+		}
+		end += start
+		if inner := strings.LastIndex(txt[start:end], open); inner != -1 {
+			start += inner + len(open)
+		}
+
+		sb.WriteString(txt[pos:start])
+		sb.WriteString(renameInExpr(txt[start:end], old, new))
+		pos = end
+	}
+	if pos == 0 {
+		return txt
+	}
+	sb.WriteString(txt[pos:])
+	return sb.String()
+}
+
+// renameInExpr renames old in one placeholder expression.
+func renameInExpr(expr, old, new string) string {
+	trimmed := strings.TrimSpace(expr)
+	if strings.HasPrefix(trimmed, "#") {
+		return renameIdent(expr, old, new)
+	}
+	lead := len(expr) - len(strings.TrimLeft(expr, " \t"))
+	key := expr[lead:]
+	if i := strings.IndexAny(key, " \t|"); i != -1 {
+		key = key[:i]
+	}
+	if key != old {
+		return expr
+	}
+	return expr[:lead] + new + expr[lead+len(key):]
+}
+
+// renameIdent renames every identifier old in a directive outside string
+// literals.
+func renameIdent(expr, old, new string) string {
+	var sb strings.Builder
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(expr))
+			sb.WriteString(expr[i:end])
+			i = end
+		case isIdentByte(c):
+			end := i
+			for end < len(expr) && (isIdentByte(expr[end]) || (expr[end] >= '0' && expr[end] <= '9') || expr[end] == '.') {
+				end++
+			}
+			if expr[i:end] == old {
+				sb.WriteString(new)
+			} else {
+				sb.WriteString(expr[i:end])
+			}
+			i = end
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String()
+}
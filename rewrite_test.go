@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenameKey(t *testing.T) {
+	const in = `a: <::OLD::>
+b: <:: OLD | upper::>
+c: <::OLDER::>
+<::#if has(OLD) && eq(OLD, "OLD")::>
+d: <::<::OLD::>
+<::#end::>
+`
+	const want = `a: <::NEW::>
+b: <:: NEW | upper::>
+c: <::OLDER::>
+<::#if has(NEW) && eq(NEW, "OLD")::>
+d: <::<::NEW::>
+<::#end::>
+`
+	if got := renameKey(in, "<::", "::>", "OLD", "NEW"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRewriteTree(t *testing.T) {
+	src := t.TempDir()
+	path := filepath.Join(src, "app.yaml")
+	const in = "host: example.com\nurl: https://example.com/\n"
+	if err := os.WriteFile(path, []byte(in), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:   "<::",
+		CloseDelim:  "::>",
+		TargetDir:   src,
+		FileFilter:  ff,
+		RewriteFrom: "example.com",
+		RewriteTo:   "<::PUBLIC_DOMAIN::>",
+		DryRun:      true,
+	}
+
+	var buf bytes.Buffer
+	if n, err := rewriteTree(cfg, &buf); err != nil || n != 1 {
+		t.Fatalf("dry run = %d, %v", n, err)
+	}
+	if !strings.Contains(buf.String(), "+host: <::PUBLIC_DOMAIN::>") {
+		t.Errorf("dry run diff missing change:\n%s", buf.String())
+	}
+	if got, _ := os.ReadFile(path); string(got) != in {
+		t.Errorf("dry run modified the file: %q", got)
+	}
+
+	cfg.DryRun = false
+	if n, err := rewriteTree(cfg, &buf); err != nil || n != 1 {
+		t.Fatalf("rewrite = %d, %v", n, err)
+	}
+	want := "host: <::PUBLIC_DOMAIN::>\nurl: https://<::PUBLIC_DOMAIN::>/\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Errorf("rewritten %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runLockName is the file in -dir that -run-lock locks. It is never rendered.
+// Its name keeps it apart from charmap.lock, the -lock file of pinned values,
+// which is meant to be committed while this one is transient.
+const runLockName = ".charmap.run.lock"
+
+// errRunLocked is returned by lockRunFile when another process holds the lock.
+var errRunLocked = errors.New("run lock held")
+
+// acquireRunLock takes the run lock of cfg.TargetDir so that overlapping runs,
+// such as a cron job and someone at a terminal, do not render the same files
+// at once. With -run-lock wait it blocks until the lock is free; with fail it
+// returns an error naming the holder. The returned func releases the lock.
+func acquireRunLock(cfg config) (func(), error) {
+	path := filepath.Join(cfg.TargetDir, runLockName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open run lock: %w", err)
+	}
+	err = lockRunFile(f, false)
+	if errors.Is(err, errRunLocked) && cfg.RunLock == "wait" {
+		slog.Info("waiting for another run to finish", slog.String("lock", path), slog.String("holder", runLockHolder(path)))
+		err = lockRunFile(f, true)
+	}
+	if errors.Is(err, errRunLocked) {
+		f.Close()
+		return nil, fmt.Errorf("another charmap run holds %s (%s); wait for it to finish or use -run-lock wait", path, runLockHolder(path))
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// The holder is recorded for the message of a run that finds it locked.
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "pid %d, started %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	}
+	return func() {
+		if err := unlockRunFile(f); err != nil {
+			slog.Warn("failed to release run lock", slog.String("lock", path), slog.Any("error", err))
+		}
+	}, nil
+}
+
+// runLockHolder describes the process holding the run lock at path, as it
+// recorded itself.
+func runLockHolder(path string) string {
+	data, err := os.ReadFile(path)
+	if holder := strings.TrimSpace(string(data)); err == nil && holder != "" {
+		return holder
+	}
+	return "holder unknown"
+}
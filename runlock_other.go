@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// lockRunFile cannot lock files on this platform.
+func lockRunFile(f *os.File, wait bool) error {
+	return errors.New("-run-lock is not supported on this platform")
+}
+
+func unlockRunFile(f *os.File) error {
+	return f.Close()
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockRunFile takes an exclusive flock on f, which the kernel releases
+// should the process die. Unless wait is set it returns errRunLocked instead
+// of blocking.
+func lockRunFile(f *os.File, wait bool) error {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	err := syscall.Flock(int(f.Fd()), how)
+	if err == syscall.EWOULDBLOCK {
+		return errRunLocked
+	}
+	return err
+}
+
+// unlockRunFile releases the lock on f and closes it. The file is left in
+// place: removing it would let a waiting run and a new one lock different
+// files.
+func unlockRunFile(f *os.File) error {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return f.Close()
+}
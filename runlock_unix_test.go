@@ -0,0 +1,63 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunLock(t *testing.T) {
+	src := t.TempDir()
+	tmpl := filepath.Join(src, "app.yaml")
+	if err := os.WriteFile(tmpl, []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`.*`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		Workers:    1,
+		KeyMap:     map[string]string{"V": "1"},
+		FileFilter: ff,
+		RunLock:    "fail",
+	}
+
+	release, err := acquireRunLock(cfg)
+	if err != nil {
+		t.Fatalf("acquireRunLock: %v", err)
+	}
+	_, err = processTree(cfg)
+	if want := fmt.Sprintf("another charmap run holds %s (pid %d, started ", filepath.Join(src, runLockName), os.Getpid()); err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+	if got, _ := os.ReadFile(tmpl); string(got) != "v: <::V::>\n" {
+		t.Errorf("file rendered while locked: %q", got)
+	}
+
+	// A waiting run starts once the first releases the lock.
+	cfg.RunLock = "wait"
+	done := make(chan error)
+	go func() {
+		_, err := processTree(cfg)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("run did not wait for the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	release()
+	if err := <-done; err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+	// The lock file itself is never rendered, whatever -include says.
+	if got, _ := os.ReadFile(tmpl); string(got) != "v: 1\n" {
+		t.Errorf("got %q after the wait", got)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// usedKeys returns the keys of values that appear as plain placeholders in
+// txt, in order of first appearance.
+func usedKeys(txt, open, close string, values map[string]string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for {
+		idx := strings.Index(txt, open)
+		if idx == -1 {
+			return keys
+		}
+		rest := txt[idx+len(open):]
+		end := strings.Index(rest, close)
+		if end == -1 {
+			return keys
+		}
+		key := rest[:end]
+		if _, ok := values[key]; !ok {
+			// Another placeholder may start after open, as in
+			// "<::<::KEY::>", or inside it, as in "{{{KEY}}".
+			txt = txt[idx+1:]
+			continue
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+		// A close delimiter may itself start the next placeholder's open, so
+		// only the key is skipped.
+		txt = rest[end:]
+	}
+}
+
+// replacerCache holds the replacers built by cachedReplacer, keyed by a hash
+// of the delimiters and the key/value pairs they substitute. Files using the
+// same keys share one replacer instead of each paying for one over the whole
+// key map, which can hold every environment variable.
+var replacerCache keySetCache[*strings.Replacer]
+
+func cachedReplacer(open, close string, keys []string, values map[string]string) *strings.Replacer {
+	sum, sorted := keySetHash(open, close, keys, values)
+	if r, ok := replacerCache.load(sum); ok {
+		return r
+	}
+	pairs := make([]string, 0, len(sorted)*2)
+	for _, k := range sorted {
+		pairs = append(pairs, open+k+close, values[k])
+	}
+	return replacerCache.store(sum, strings.NewReplacer(pairs...))
+}
+
+// maxKeySets bounds each keySetCache. Key sets rarely number more than the
+// files in a tree, so a cache that fills up is simply emptied.
+const maxKeySets = 1024
+
+// keySetCache maps keySetHash sums to what was built for them. The entries
+// embed values, secrets included, so the cache is bounded by maxKeySets and
+// emptied by resetKeySetCaches whenever values are reloaded, rather than
+// keeping every value the process has seen.
+type keySetCache[V any] struct {
+	mu sync.Mutex
+	m  map[[sha256.Size]byte]V
+}
+
+func (c *keySetCache[V]) load(sum [sha256.Size]byte) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[sum]
+	return v, ok
+}
+
+// store caches v under sum and returns the cached entry, which is the one
+// stored first when two callers race.
+func (c *keySetCache[V]) store(sum [sha256.Size]byte, v V) V {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.m[sum]; ok {
+		return old
+	}
+	if c.m == nil || len(c.m) >= maxKeySets {
+		c.m = make(map[[sha256.Size]byte]V)
+	}
+	c.m[sum] = v
+	return v
+}
+
+func (c *keySetCache[V]) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m = nil
+}
+
+// resetKeySetCaches drops every cached replacer and matcher, so the values
+// they were built for are no longer held once new ones are loaded.
+func resetKeySetCaches() {
+	replacerCache.reset()
+	ahoCache.reset()
+}
+
+// keySetHash identifies a substitution by its delimiters and key/value pairs,
+// and returns the keys sorted.
+func keySetHash(open, close string, keys []string, values map[string]string) ([sha256.Size]byte, []string) {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	h.Write([]byte(open + "\x00" + close + "\x00"))
+	for _, k := range sorted {
+		h.Write([]byte(k + "\x00" + values[k] + "\x00"))
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum, sorted
+}
+
+// missingKey is one placeholder whose key has no value.
+type missingKey struct {
+	Key  string
+	Line int
+}
+
+// findMissing reports every placeholder in txt whose key has no value, in
+// order of appearance, without substituting anything. A missing key whose
+// first filter is default is accepted.
+func findMissing(txt, open, close string, values map[string]string, opts replacerOptions) ([]missingKey, error) {
+	refs, err := scanPlaceholders(txt, open, close, values, opts)
+	if err != nil {
+		return nil, err
+	}
+	var missing []missingKey
+	for _, ref := range refs {
+		if ref.Source != nil {
+			continue
+		}
+		if _, ok := values[ref.Key]; !ok && !ref.HasDefault {
+			missing = append(missing, missingKey{Key: ref.Key, Line: ref.Line})
+		}
+	}
+	return missing, nil
+}
+
+// placeholderRef is one placeholder found by scanPlaceholders.
+type placeholderRef struct {
+	Key  string
+	Line int
+	// HasDefault is set when the first filter is default, so the key may be
+	// unset.
+	HasDefault bool
+	// Source is set for a function-call placeholder, whose Key is the call
+	// rather than a key of values.
+	Source *sourceCall
+}
+
+// scanPlaceholders lists the placeholders in txt that a render with values
+// would substitute, in order of appearance. Like the renderer it skips opaque
+// regions and branches not taken and follows delimiter pragmas. Placeholders
+// of later stages than opts.Stage, or in text protected by opts.Syntax, are
+// left out.
+func scanPlaceholders(txt, open, close string, values map[string]string, opts replacerOptions) ([]placeholderRef, error) {
+	var refs []placeholderRef
+	for _, r := range splitDelimPragmas(blankOpaque(txt, opts.Opaque)) {
+		o, c := open, close
+		if r.open != "" {
+			o, c = r.open, r.close
+		}
+		staged, _, err := resolveStages(r.text, o, c, opts.Stage)
+		if err != nil {
+			return nil, fmt.Errorf("in region starting at line %d: %w", r.line, err)
+		}
+		if syntax := syntaxModes[opts.Syntax]; syntax.protected != nil {
+			staged = blankSpans(staged, syntax.protected(staged, o, c))
+		}
+		resolved, err := resolveConditionals(staged, o, c, values, true)
+		if err != nil {
+			return nil, fmt.Errorf("in region starting at line %d: %w", r.line, err)
+		}
+
+		line, rest := r.line, resolved
+		for {
+			idx := strings.Index(rest, o)
+			if idx == -1 {
+				break
+			}
+			start := idx + len(o)
+			end := strings.Index(rest[start:], c)
+			if end == -1 {
+				break
+			}
+			line += strings.Count(rest[:idx], "\n")
+			expr := rest[start : start+end]
+			rest = rest[idx:]
+
+			ref := placeholderRef{Key: expr, Line: line}
+			if _, _, call := cutCall(expr); call || strings.Contains(expr, "|") {
+				p, err := parsePipeline(expr)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				ref.Key, ref.Source = p.key, p.source
+				ref.HasDefault = len(p.calls) > 0 && p.calls[0].name == "default"
+			}
+			refs = append(refs, ref)
+			rest = rest[len(o)+end:]
+		}
+	}
+	return refs, nil
+}
+
+// blankOpaque replaces every region enclosed by one of the opaque pairs with
+// its newlines, keeping line numbers intact.
+func blankOpaque(txt string, pairs [][2]string) string {
+	for _, pair := range pairs {
+		var sb strings.Builder
+		for {
+			idx := strings.Index(txt, pair[0])
+			if idx == -1 {
+				break
+			}
+			end := strings.Index(txt[idx+len(pair[0]):], pair[1])
+			if end == -1 {
+				break
+			}
+			end += idx + len(pair[0]) + len(pair[1])
+			sb.WriteString(txt[:idx])
+			sb.WriteString(strings.Repeat("\n", strings.Count(txt[idx:end], "\n")))
+			txt = txt[end:]
+		}
+		sb.WriteString(txt)
+		txt = sb.String()
+	}
+	return txt
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUsedKeys(t *testing.T) {
+	values := map[string]string{"A": "1", "B": "2", "UNUSED": "3"}
+	got := usedKeys("<::B::> <::<::A::> <::MISSING::> <::B::><::A | upper::>", "<::", "::>", values)
+	if want := []string{"B", "A"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("usedKeys = %v, want %v", got, want)
+	}
+}
+
+func TestCachedReplacer(t *testing.T) {
+	values := map[string]string{"A": "1", "B": "2"}
+	r1 := cachedReplacer("<::", "::>", []string{"A", "B"}, values)
+	r2 := cachedReplacer("<::", "::>", []string{"B", "A"}, values)
+	if r1 != r2 {
+		t.Errorf("expected the same replacer for the same key set")
+	}
+	if r3 := cachedReplacer("<::", "::>", []string{"A", "B"}, map[string]string{"A": "1", "B": "3"}); r3 == r1 {
+		t.Errorf("expected a new replacer when a value changes")
+	}
+	if got := r1.Replace("<::A::>-<::B::>"); got != "1-2" {
+		t.Errorf("Replace = %q, want %q", got, "1-2")
+	}
+}
+
+// indexByteChain is the classic alternative to strings.Index for short
+// delimiters: jump between occurrences of the first byte with the vectorized
+// IndexByte and check the rest.
+func indexByteChain(s, delim string) int {
+	for i := 0; ; {
+		j := strings.IndexByte(s[i:], delim[0])
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if strings.HasPrefix(s[i:], delim) {
+			return i
+		}
+		i++
+	}
+}
+
+// BenchmarkDelimScan compares ways of locating open delimiters on the
+// 4MiB/1000-key blob of BenchmarkReplacers. strings.Index is already backed by
+// SIMD assembly on amd64 and arm64; the IndexByte chain is at best about 10%
+// faster, well under 1% of a full render of the same blob, and falls back to
+// a byte-at-a-time walk when the delimiter's first byte is common in the
+// text. The scanners therefore keep using strings.Index.
+func BenchmarkDelimScan(b *testing.B) {
+	blob, _ := makeTestBlob(4<<20, 1000, 42)
+	txt := string(blob)
+	count := func(delim string, index func(s, substr string) int) int {
+		n := 0
+		for s := txt; ; n++ {
+			i := index(s, delim)
+			if i < 0 {
+				return n
+			}
+			s = s[i+len(delim):]
+		}
+	}
+
+	for _, delim := range []string{string(benchOpenDelim), "<::"} {
+		b.Run(delim+"/strings.Index", func(b *testing.B) {
+			b.SetBytes(int64(len(txt)))
+			for i := 0; i < b.N; i++ {
+				count(delim, strings.Index)
+			}
+		})
+		b.Run(delim+"/IndexByte-chain", func(b *testing.B) {
+			b.SetBytes(int64(len(txt)))
+			for i := 0; i < b.N; i++ {
+				count(delim, indexByteChain)
+			}
+		})
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	const txt = `a: <::A::>
+b: <::B::>
+<::#if eq(A, "x")::>
+c: <::C::>
+<::#else::>
+d: <::D | upper::>
+<::#end::>
+e: <::E | default "e"::>
+helm: {{ .Values.x
+  | quote }}
+f: <::B::>
+# charmap delims: [[ ]]
+g: [[G]] <::IGNORED::>
+`
+	missing, err := findMissing(txt, "<::", "::>", map[string]string{"A": "y"}, replacerOptions{Opaque: [][2]string{{"{{", "}}"}}})
+	if err != nil {
+		t.Fatalf("findMissing: %v", err)
+	}
+	want := []missingKey{{"B", 2}, {"D", 6}, {"B", 11}, {"G", 13}}
+	if !reflect.DeepEqual(missing, want) {
+		t.Errorf("findMissing = %v, want %v", missing, want)
+	}
+}
+
+func TestKeySetCache_Bounded(t *testing.T) {
+	var c keySetCache[int]
+	var first [32]byte
+	c.store(first, 0)
+	for i := 1; i <= maxKeySets; i++ {
+		var sum [32]byte
+		sum[0], sum[1] = byte(i), byte(i>>8)
+		c.store(sum, i)
+	}
+	if len(c.m) > maxKeySets {
+		t.Errorf("cache holds %d entries, want at most %d", len(c.m), maxKeySets)
+	}
+	if _, ok := c.load(first); ok {
+		t.Errorf("expected the first entry to be dropped once the cache filled up")
+	}
+	c.reset()
+	if len(c.m) != 0 {
+		t.Errorf("reset left %d entries", len(c.m))
+	}
+}
+
+func TestResetKeySetCaches(t *testing.T) {
+	values := map[string]string{"A": "secret"}
+	r1 := cachedReplacer("<::", "::>", []string{"A"}, values)
+	ahoEngine("<::A::>", "<::", "::>", values)
+	resetKeySetCaches()
+	if len(replacerCache.m) != 0 || len(ahoCache.m) != 0 {
+		t.Fatalf("caches still hold %d replacers and %d matchers", len(replacerCache.m), len(ahoCache.m))
+	}
+	if r2 := cachedReplacer("<::", "::>", []string{"A"}, values); r2 == r1 {
+		t.Errorf("expected a new replacer after a reset")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// within reports whether path is root or lies beneath it. Both must be clean
+// absolute paths.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// realPath resolves symlinks in path and makes it absolute. Components that do
+// not exist yet are appended unresolved to their deepest existing ancestor.
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(abs)}, missing...)
+		abs = parent
+	}
+}
+
+// readScope confines the walk to the target directory: symlinked files must
+// resolve inside it and directories must live on the same device, unless
+// -allow-outside is set.
+type readScope struct {
+	root    string
+	rootDev uint64
+	hasDev  bool
+	allow   bool
+}
+
+func newReadScope(root string, allow bool) (readScope, error) {
+	real, err := realPath(root)
+	if err != nil {
+		return readScope{}, err
+	}
+	s := readScope{root: real, allow: allow}
+	if fi, err := os.Stat(real); err == nil {
+		s.rootDev, s.hasDev = deviceID(fi)
+	}
+	return s, nil
+}
+
+func (s readScope) check(path string, d fs.DirEntry) error {
+	if s.allow {
+		return nil
+	}
+
+	if d.Type()&fs.ModeSymlink != 0 {
+		real, err := realPath(path)
+		if err != nil {
+			return err
+		}
+		if !within(s.root, real) {
+			return fmt.Errorf("%q links to %q outside %q (use -allow-outside to permit)", path, real, s.root)
+		}
+	}
+
+	if d.IsDir() && s.hasDev {
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if dev, ok := deviceID(fi); ok && dev != s.rootDev {
+			return fmt.Errorf("%q is on a different device than %q (use -allow-outside to permit)", path, s.root)
+		}
+	}
+	return nil
+}
+
+// escapesName reports whether name, a path written in a template, may leave
+// the directory it is resolved against: it is absolute or has .. elements.
+func escapesName(name string) bool {
+	name = filepath.FromSlash(name)
+	return filepath.IsAbs(name) || slices.Contains(strings.Split(name, string(filepath.Separator)), "..")
+}
+
+// checkReadScope fails unless path resolves, through symlinks, inside one of
+// roots.
+func checkReadScope(path string, roots []string) error {
+	real, err := realPath(path)
+	if err != nil {
+		return err
+	}
+	for _, dir := range roots {
+		if base, err := realPath(dir); err == nil && within(base, real) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q resolves to %q outside %s (use -allow-outside to permit)", path, real, strings.Join(roots, ", "))
+}
+
+// checkWriteScope rejects destinations that escape outDir, lexically or
+// through symlinks already present in the output tree.
+func checkWriteScope(outDir, dest string) error {
+	root, err := realPath(outDir)
+	if err != nil {
+		return err
+	}
+	real, err := realPath(dest)
+	if err != nil {
+		return err
+	}
+	if !within(root, real) {
+		return fmt.Errorf("output path %q resolves to %q outside %q (use -allow-outside to permit)", dest, real, root)
+	}
+	return nil
+}
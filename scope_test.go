@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestScopeContainment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on windows")
+	}
+
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.yaml")
+	if err := os.WriteFile(secret, []byte("k: <::KEY::>\n"), 0o644); err != nil {
+		t.Fatalf("write outside file: %v", err)
+	}
+
+	src := t.TempDir()
+	if err := os.Symlink(secret, filepath.Join(src, "link.yaml")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		Workers:    1,
+		KeyMap:     map[string]string{"KEY": "v"},
+		FileFilter: ff,
+	}
+
+	if err := processFiles(cfg); err == nil {
+		t.Errorf("expected error for symlink escaping -dir")
+	}
+	if got, _ := os.ReadFile(secret); string(got) != "k: <::KEY::>\n" {
+		t.Errorf("file outside -dir was rewritten: %q", got)
+	}
+
+	// An output tree containing a symlink that points outside -out.
+	if err := os.Remove(filepath.Join(src, "link.yaml")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("k: <::KEY::>\n"), 0o644); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	cfg.OutDir = t.TempDir()
+	if err := os.Symlink(secret, filepath.Join(cfg.OutDir, "app.yaml")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := processFiles(cfg); err == nil {
+		t.Errorf("expected error for output symlink escaping -out")
+	}
+
+	cfg.AllowOutside = true
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles with -allow-outside: %v", err)
+	}
+	if got, _ := os.ReadFile(secret); string(got) != "k: v\n" {
+		t.Errorf("expected write through symlink with -allow-outside, got %q", got)
+	}
+}
+
+func TestWithin(t *testing.T) {
+	root := filepath.FromSlash("/srv/out")
+	tests := map[string]bool{
+		"/srv/out":             true,
+		"/srv/out/a/b.yaml":    true,
+		"/srv/out/..foo":       true,
+		"/srv/output/x":        false,
+		"/srv/out/../etc/pass": false,
+		"/etc/passwd":          false,
+	}
+	for path, want := range tests {
+		if got := within(root, filepath.Clean(filepath.FromSlash(path))); got != want {
+			t.Errorf("within(%q, %q) = %v, want %v", root, path, got, want)
+		}
+	}
+}
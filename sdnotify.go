@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state, such as "READY=1", to the systemd notification socket
+// named by NOTIFY_SOCKET. It does nothing when charmap is not run by systemd
+// with Type=notify. Sockets passed in LISTEN_FDS are not used: charmap has no
+// mode that accepts connections, so there is nothing to activate.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	if path[0] == '@' {
+		// Abstract socket namespace.
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// startWatchdog sends WATCHDOG=1 every interval until ctx is done. It pings
+// from its own goroutine rather than between renders, so that a render or a
+// poll outlasting WatchdogSec does not get the process killed. An interval of
+// 0 sends nothing.
+func startWatchdog(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				notify("WATCHDOG=1")
+			}
+		}
+	}()
+}
+
+// sdWatchdogInterval returns how often to send WATCHDOG=1: half the
+// WatchdogSec systemd passes in WATCHDOG_USEC, or 0 when the watchdog is off
+// or WATCHDOG_PID names another process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
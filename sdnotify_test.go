@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs unixgram sockets")
+	}
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("without NOTIFY_SOCKET: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("listen: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("sdNotify: %v", err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("received %q, %v; want READY=1", buf[:n], err)
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if got := sdWatchdogInterval(); got != 15*time.Second {
+		t.Errorf("interval = %v, want 15s", got)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if os.Getpid() != 1 {
+		if got := sdWatchdogInterval(); got != 0 {
+			t.Errorf("watchdog for another process: interval = %v, want 0", got)
+		}
+	}
+}
+
+func TestStartWatchdog(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs unixgram sockets")
+	}
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("listen: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	// Nothing else runs while the pings are read: they do not wait for a
+	// render loop.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startWatchdog(ctx, 10*time.Millisecond)
+	buf := make([]byte, 64)
+	for i := 0; i < 2; i++ {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "WATCHDOG=1" {
+			t.Fatalf("ping %d: received %q, %v; want WATCHDOG=1", i, buf[:n], err)
+		}
+	}
+}
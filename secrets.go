@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// tokenPrefixes are the prefixes of well-known credential formats.
+var tokenPrefixes = [][2]string{
+	{"AKIA", "AWS access key ID"},
+	{"ASIA", "AWS temporary access key ID"},
+	{"ghp_", "GitHub token"},
+	{"gho_", "GitHub token"},
+	{"ghs_", "GitHub token"},
+	{"github_pat_", "GitHub token"},
+	{"glpat-", "GitLab token"},
+	{"xoxb-", "Slack token"},
+	{"xoxp-", "Slack token"},
+	{"sk_live_", "Stripe key"},
+	{"AIza", "Google API key"},
+}
+
+// A value of at least minSecretLen bytes without spaces looks random, as
+// generated passwords and keys do, when its Shannon entropy reaches
+// secretEntropyRatio of the most its length allows. URLs, image references
+// and hex digests stay below that.
+const (
+	secretEntropyRatio = 0.85
+	minSecretLen       = 20
+)
+
+// classifySecret returns why the value of key looks like a credential, or ""
+// when it does not.
+func classifySecret(key, value string) string {
+	if value == "" {
+		return ""
+	}
+	for _, p := range tokenPrefixes {
+		if strings.HasPrefix(value, p[0]) && len(value) >= minSecretLen {
+			return p[1]
+		}
+	}
+	if strings.Contains(value, "PRIVATE KEY-----") {
+		return "PEM private key"
+	}
+	if len(value) >= minSecretLen && !strings.ContainsAny(value, " \t\n") {
+		if e := entropy(value); e >= secretEntropyRatio*math.Log2(float64(min(len(value), 64))) {
+			return fmt.Sprintf("high entropy (%.1f bits/byte)", e)
+		}
+	}
+	if looksSecret(key) {
+		return "secret key name"
+	}
+	return ""
+}
+
+// entropy returns the Shannon entropy of s in bits per byte.
+func entropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var e float64
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(s))
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// repoRoot returns the root of the git, Mercurial or Subversion checkout
+// holding dir, or "" when there is none.
+func repoRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		for _, marker := range []string{".git", ".hg", ".svn"} {
+			if _, err := os.Lstat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// gitIgnored reports whether git ignores path in the checkout at root. It is
+// false when git is not installed, so such files are reported.
+func gitIgnored(root, path string) bool {
+	if _, err := os.Lstat(filepath.Join(root, ".git")); err != nil {
+		return false
+	}
+	return exec.Command("git", "-C", root, "check-ignore", "-q", path).Run() == nil
+}
+
+// secretsCmd reports every key whose value looks like a credential and that
+// a render would write into a file inside a version-controlled checkout. It
+// is advisory: nothing is rendered or written and the exit status is 0.
+func secretsCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("secrets: unexpected arguments %v", args)
+	}
+	n, err := secretReport(cfg, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		fmt.Println("no credential-like value is written under version control")
+	}
+	return nil
+}
+
+// secretReport writes one row per file and key flagged by secretsCmd and
+// returns how many it wrote. Values are never written.
+func secretReport(cfg config, w io.Writer) (int, error) {
+	targets := renderTargets(cfg)
+	roots := make(map[string]string) // directory -> repoRoot
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	var n int
+	err := walkFiles(cfg, func(path string) error {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if cfg.skipContent(in) {
+			return nil
+		}
+		if in, err = cfg.withIncludes(path, in); err != nil {
+			return err
+		}
+		for _, t := range targets {
+			if t.applyCmd != "" {
+				continue
+			}
+			dest, err := targetPath(cfg.TargetDir, path, t)
+			if err != nil {
+				return err
+			}
+			dir := filepath.Dir(dest)
+			root, ok := roots[dir]
+			if !ok {
+				root = repoRoot(dir)
+				roots[dir] = root
+			}
+			if root == "" {
+				continue
+			}
+
+			values, _, err := fileValues(path, cfg, t.keyMap, t.opts)
+			if err != nil {
+				return err
+			}
+			open, close := cfg.delims(path)
+			refs, err := scanPlaceholders(string(in), open, close, values, t.opts)
+			if err != nil {
+				return fmt.Errorf("failed to scan %q: %w", path, err)
+			}
+			ignored, checked := false, false
+			seen := make(map[string]bool)
+			for _, ref := range refs {
+				k := ref.Key
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+				v := values[k]
+				if ref.Source != nil {
+					v, _ = ref.Source.value(t.opts)
+				}
+				reason := classifySecret(k, v)
+				if reason == "" {
+					continue
+				}
+				if !checked {
+					ignored, checked = gitIgnored(root, dest), true
+				}
+				if ignored {
+					break
+				}
+				if n == 0 {
+					fmt.Fprintln(tw, "DEST\tKEY\tLOOKS LIKE\tCHECKOUT")
+				}
+				n++
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", dest, k, reason, root)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, tw.Flush()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+)
+
+// sidecarSuffix names the per-template values file: the values in
+// config.yaml.charmap-values apply to config.yaml only, over the global map.
+const sidecarSuffix = ".charmap-values"
+
+// loadSidecar reads the KEY=value sidecar of path from src. It returns nil
+// when there is none.
+func loadSidecar(src inputSource, path string) (map[string]string, error) {
+	data, err := src.readFile(path + sidecarSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseLineValues(data, '=')
+}
+
+// withSidecar merges the sidecar values over values. Like values files,
+// sidecar values may reference other keys as ${KEY} or be encoded as b64: or
+// hex:, and keys outside the -allow-keys list are dropped. opts is returned
+// with Denied extended accordingly.
+func withSidecar(side, values map[string]string, opts replacerOptions) (map[string]string, replacerOptions, error) {
+	merged := maps.Clone(values)
+	if merged == nil {
+		merged = make(map[string]string, len(side))
+	}
+	fromFile := make(map[string]bool, len(side))
+	for k, v := range side {
+		merged[k] = v
+		fromFile[k] = true
+	}
+	if err := decodeValues(merged, maps.Clone(fromFile), fromFile); err != nil {
+		return nil, opts, err
+	}
+	if err := interpolateValues(merged, fromFile); err != nil {
+		return nil, opts, err
+	}
+	if opts.Allowed != nil {
+		denied := maps.Clone(opts.Denied)
+		if denied == nil {
+			denied = make(map[string]bool)
+		}
+		restrictKeys(merged, opts.Allowed, denied)
+		opts.Denied = denied
+	}
+	return merged, opts, nil
+}
+
+// fileValues returns the values and options for rendering path: values and
+// opts themselves, or merged with the scoped values and sidecar of path if it
+// has any, see loadLocalValues.
+func fileValues(path string, cfg config, values map[string]string, opts replacerOptions) (map[string]string, replacerOptions, error) {
+	local, _, err := loadLocalValues(path, cfg)
+	if err != nil {
+		return nil, opts, err
+	}
+	if local == nil {
+		return values, opts, nil
+	}
+	return withSidecar(local, values, opts)
+}
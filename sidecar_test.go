@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessFiles_Sidecar(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	write("a.yaml", "url: <::URL::> port: <::PORT::>\n")
+	write("a.yaml"+sidecarSuffix, "PORT=9090\nURL=http://${HOST}:${PORT}\n")
+	write("b.yaml", "host: <::HOST::> port: <::PORT::>\n")
+
+	ff, _ := newFileFilter(nil, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		Workers:    1,
+		KeyMap:     map[string]string{"HOST": "db", "PORT": "5432"},
+		FileFilter: ff,
+		CloseLog:   func() {},
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"a.yaml": "url: http://db:9090 port: 9090\n",
+		"b.yaml": "host: db port: 5432\n",
+	} {
+		if got, _ := os.ReadFile(filepath.Join(out, name)); string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "a.yaml"+sidecarSuffix)); err == nil {
+		t.Errorf("sidecar was rendered as a template")
+	}
+}
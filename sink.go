@@ -0,0 +1,112 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// outputSink receives every file a run writes, named by its slash-separated
+// path relative to the sink. src is the template the file was rendered from.
+// Runs write through a diskSink unless -out-tar gives them an archive; the
+// checks that decide whether a file on disk is written at all, identical
+// content and editor locks, are left to writeRendered.
+type outputSink interface {
+	WriteFile(name, src string, data []byte, mode fs.FileMode) error
+}
+
+// output returns the sink of t: its archive, or the disk below outDir.
+func (t renderTarget) output() outputSink {
+	if t.sink == nil {
+		return diskSink{dir: t.outDir}
+	}
+	return t.sink
+}
+
+// sinkName returns the name dest, a path below the outDir of t, has in a
+// sink: relative to outDir and, for a profile writing into an archive, below
+// a directory named after it.
+func sinkName(t renderTarget, dest string) (string, error) {
+	rel, err := filepath.Rel(t.outDir, dest)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("output path %q is outside %q", dest, t.outDir)
+	}
+	if t.sink != nil && t.name != "" {
+		rel = filepath.Join(t.name, rel)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// diskSink writes files below dir, creating directories as needed, and
+// carries Windows attributes over from src. With an empty dir names are paths
+// as they are, for files rendered in place and render -o.
+type diskSink struct {
+	dir string
+}
+
+func (s diskSink) WriteFile(name, src string, data []byte, mode fs.FileMode) error {
+	dest := filepath.FromSlash(name)
+	if s.dir != "" {
+		dest = filepath.Join(s.dir, dest)
+		if err := os.MkdirAll(longPath(filepath.Dir(dest)), 0o755); err != nil {
+			return err
+		}
+	}
+	return writeFile(src, dest, data, mode)
+}
+
+// tarSink writes files into a tar archive, for -out-tar. Every entry gets the
+// time the archive was started, so archives of the same files differ only in
+// entry order.
+type tarSink struct {
+	mu    sync.Mutex
+	w     io.WriteCloser
+	tw    *tar.Writer
+	start time.Time
+}
+
+// newTarSink creates the archive at path, or writes it to stdout for "-".
+func newTarSink(path string) (*tarSink, error) {
+	var w io.WriteCloser = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &tarSink{w: w, tw: tar.NewWriter(w), start: time.Now().Truncate(time.Second)}, nil
+}
+
+func (s *tarSink) WriteFile(name, src string, data []byte, mode fs.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     int64(len(data)),
+		ModTime:  s.start,
+	}
+	if err := s.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := s.tw.Write(data)
+	return err
+}
+
+// Close finishes the archive. Standard output is left open.
+func (s *tarSink) Close() error {
+	err := s.tw.Close()
+	if s.w != os.Stdout {
+		if cerr := s.w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
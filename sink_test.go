@@ -0,0 +1,172 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// memSink keeps files in memory, keyed by name.
+type memSink struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *memSink) WriteFile(name, src string, data []byte, mode fs.FileMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string][]byte)
+	}
+	s.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func TestProcessTree_OutTar(t *testing.T) {
+	src, out := t.TempDir(), filepath.Join(t.TempDir(), "out")
+	archive := filepath.Join(t.TempDir(), "render.tar")
+	for name, txt := range map[string]string{"app.yaml": "v: <::V::>\n", "db/db.yaml": "host: <::HOST::>\n"} {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(txt), 0o640); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		OutTar:     archive,
+		Workers:    2,
+		KeyMap:     map[string]string{"V": "1", "HOST": "db1"},
+		FileFilter: ff,
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("-out-tar run created %s", out)
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := make(map[string]string)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		got[hdr.Name] = string(data)
+		if hdr.Mode != 0o640 {
+			t.Errorf("%s: mode %o, want 640", hdr.Name, hdr.Mode)
+		}
+	}
+	want := map[string]string{"app.yaml": "v: 1\n", "db/db.yaml": "host: db1\n"}
+	if len(got) != len(want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+	for name, w := range want {
+		if got[name] != w {
+			t.Errorf("%s: got %q, want %q", name, got[name], w)
+		}
+	}
+}
+
+func TestWriteRendered_Sink(t *testing.T) {
+	src := t.TempDir()
+	path := filepath.Join(src, "app.yaml")
+	if err := os.WriteFile(path, []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	fi, _ := os.Stat(path)
+	sink := &memSink{}
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		OutTmpl:    "out/{env}",
+		Profiles:   []profile{{Name: "staging", KeyMap: map[string]string{"V": "2"}}},
+	}
+	target := renderTargets(cfg)[0]
+	target.sink = sink
+	dest, err := targetPath(src, path, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, _ := os.ReadFile(path)
+	if _, err := writeRendered(path, dest, in, nil, fi, target); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(sink.files["staging/app.yaml"]); got != "v: 2\n" {
+		t.Errorf("sink holds %q under staging/app.yaml, want %q (all: %v)", got, "v: 2\n", sink.files)
+	}
+}
+
+func TestProcessFiles_OutTarListsMembers(t *testing.T) {
+	src, out, dir := t.TempDir(), filepath.Join(t.TempDir(), "out"), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	sums, manifest := filepath.Join(dir, "sha256sums.txt"), filepath.Join(dir, "manifest.json")
+	cfg := config{
+		OpenDelim:     "<::",
+		CloseDelim:    "::>",
+		TargetDir:     src,
+		OutTmpl:       filepath.Join(out, "{env}"),
+		OutTar:        filepath.Join(dir, "render.tar"),
+		Workers:       1,
+		Profiles:      []profile{{Name: "prod", KeyMap: map[string]string{"V": "1"}}},
+		FileFilter:    ff,
+		ChecksumsPath: sums,
+		ManifestPath:  manifest,
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+	data, err := os.ReadFile(sums)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256Hex([]byte("v: 1\n")) + "  prod/app.yaml\n"; string(data) != want {
+		t.Errorf("checksums = %q, want %q", data, want)
+	}
+	m, err := readManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Output != "prod/app.yaml" {
+		t.Errorf("manifest files = %+v, want output prod/app.yaml", m.Files)
+	}
+}
+
+func TestDiskSink(t *testing.T) {
+	dir := t.TempDir()
+	if err := (diskSink{dir: dir}).WriteFile("sub/app.yaml", "app.yaml", []byte("v: 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "sub", "app.yaml")
+	data, err := os.ReadFile(dest)
+	if err != nil || string(data) != "v: 1\n" {
+		t.Errorf("read %q, %v, want %q", data, err, "v: 1\n")
+	}
+	if fi, err := os.Stat(dest); err == nil && runtime.GOOS != "windows" && fi.Mode().Perm() != 0o600 {
+		t.Errorf("mode %v, want 0600", fi.Mode().Perm())
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// snapshotValuesCmd writes the value of every key the templates under -dir
+// reference to the -o file, as a JSON values file. A later run given the file
+// with -values-lock renders with exactly these values, whatever the
+// environment holds.
+func snapshotValuesCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("snapshot-values: unexpected arguments %v", args)
+	}
+	if cfg.RenderOut == "" {
+		return fmt.Errorf("snapshot-values: -o must be set")
+	}
+	values, err := snapshotValues(cfg, cfg.KeyMap)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The snapshot holds secrets in clear text.
+	if err := os.WriteFile(cfg.RenderOut, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("%d value(s) written to %s\n", len(values), cfg.RenderOut)
+	return nil
+}
+
+// snapshotValues returns the values of keyMap referenced by any template,
+// including keys only used in #if conditions. Keys without a value are left
+// out; check reports them.
+func snapshotValues(cfg config, keyMap map[string]string) (map[string]string, error) {
+	values := make(map[string]string)
+	err := walkFiles(cfg, func(path string) error {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if cfg.skipContent(in) {
+			return nil
+		}
+		keys, err := templateKeys(path, string(in), cfg, keyMap)
+		if err != nil {
+			return fmt.Errorf("failed to scan %q: %w", path, err)
+		}
+		for _, k := range keys {
+			if v, ok := keyMap[k]; ok {
+				values[k] = v
+			}
+		}
+		return nil
+	})
+	return values, err
+}
+
+// templateKeys returns the keys txt, the contents of path, references, in
+// placeholders a render with keyMap would substitute and in #if and #elif
+// conditions, once each in order of first appearance. Function-call
+// placeholders name no key and are left out.
+func templateKeys(path, txt string, cfg config, keyMap map[string]string) ([]string, error) {
+	in, err := cfg.withIncludes(path, []byte(txt))
+	if err != nil {
+		return nil, err
+	}
+	txt = string(in)
+	open, close := cfg.delims(path)
+	refs, err := scanPlaceholders(txt, open, close, keyMap, cfg.replacerOptions())
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	add := func(k string) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	for _, ref := range refs {
+		if ref.Source == nil {
+			add(ref.Key)
+		}
+	}
+	for _, expr := range directives(txt, open, close) {
+		for _, k := range conditionKeys(expr) {
+			add(k)
+		}
+	}
+	return keys, nil
+}
+
+// directives returns the conditions of every #if and #elif in txt.
+func directives(txt, open, close string) []string {
+	var conds []string
+	for {
+		idx := strings.Index(txt, open)
+		if idx == -1 {
+			return conds
+		}
+		txt = txt[idx+len(open):]
+		end := strings.Index(txt, close)
+		if end == -1 {
+			return conds
+		}
+		expr := strings.TrimSpace(txt[:end])
+		if cond, ok := strings.CutPrefix(expr, "#if "); ok {
+			conds = append(conds, cond)
+		} else if cond, ok := strings.CutPrefix(expr, "#elif "); ok {
+			conds = append(conds, cond)
+		}
+		txt = txt[end+len(close):]
+	}
+}
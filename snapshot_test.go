@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSnapshotValues(t *testing.T) {
+	src := t.TempDir()
+	tpl := "v: <::V | upper::>\n<::#if eq(ENV, \"prod\")::>\nr: 3\n<::#end::>\nm: <::MISSING::>\n"
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte(tpl), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		FileFilter: ff,
+		KeyMap:     map[string]string{"V": "one", "ENV": "prod", "HOME": "/root", "PATH": "/bin"},
+		RenderOut:  filepath.Join(t.TempDir(), "values.lock.json"),
+	}
+	if err := snapshotValuesCmd(cfg, nil); err != nil {
+		t.Fatalf("snapshotValuesCmd: %v", err)
+	}
+
+	got, err := loadValuesFile(cfg.RenderOut)
+	if err != nil {
+		t.Fatalf("load snapshot: %v", err)
+	}
+	if want := map[string]string{"V": "one", "ENV": "prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot = %v, want %v", got, want)
+	}
+	if fi, err := os.Stat(cfg.RenderOut); err == nil && fi.Mode().Perm()&0o077 != 0 && runtime.GOOS != "windows" {
+		t.Errorf("snapshot mode = %v, want it private", fi.Mode().Perm())
+	}
+}
+
+func TestValuesLock_RoundTrip(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("cmd: <::CMD::>\nhex: <::HEX::>\nb64: <::B64::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	// Values as the environment held them, already resolved: none of them
+	// may be decoded or expanded again.
+	values := map[string]string{"CMD": "echo ${HOME}", "HEX": "hex:41", "B64": "b64:aGk="}
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		FileFilter: ff,
+		KeyMap:     values,
+		RenderOut:  filepath.Join(t.TempDir(), "values.lock.json"),
+	}
+	if err := snapshotValuesCmd(cfg, nil); err != nil {
+		t.Fatalf("snapshotValuesCmd: %v", err)
+	}
+	got, origins, err := loadValuesLock(cfg.RenderOut)
+	if err != nil {
+		t.Fatalf("loadValuesLock: %v", err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("locked values = %v, want %v", got, values)
+	}
+	if origins["CMD"] != "values-lock:"+cfg.RenderOut {
+		t.Errorf("origin of CMD = %q", origins["CMD"])
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sourceCall is a function-call placeholder such as <::env("HOME")::>, whose
+// function names where the value comes from: env reads an environment
+// variable, file the contents of a file and secret a file under
+// -secrets-dir. Its value is read when the placeholder is rendered and
+// it may be piped through filters like a key.
+type sourceCall struct {
+	fn, arg string
+}
+
+// sourceFuncs are the functions of function-call placeholders.
+var sourceFuncs = map[string]func(arg string, opts replacerOptions) (string, error){
+	"env":    envSource,
+	"file":   fileSource,
+	"secret": secretSource,
+}
+
+// String returns the placeholder expression of c, which also stands for its
+// key in messages and pipelines.
+func (c sourceCall) String() string {
+	return c.fn + "(" + strconv.Quote(c.arg) + ")"
+}
+
+// value reads the value of c.
+func (c sourceCall) value(opts replacerOptions) (string, error) {
+	v, err := sourceFuncs[c.fn](c.arg, opts)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", c, err)
+	}
+	return v, nil
+}
+
+// cutCall parses the function call expr starts with, returning it and the
+// rest of expr, which is empty or a pipeline of filters. ok is false when
+// expr does not start with a call of one of sourceFuncs, so that it is taken
+// for a key.
+func cutCall(expr string) (c sourceCall, rest string, ok bool) {
+	s := strings.TrimLeft(expr, " \t")
+	fn, s, found := strings.Cut(s, "(")
+	if !found || sourceFuncs[fn] == nil {
+		return sourceCall{}, "", false
+	}
+	s = strings.TrimLeft(s, " \t")
+	if s == "" || s[0] != '"' {
+		return sourceCall{}, "", false
+	}
+	end := 1
+	for end < len(s) && s[end] != '"' {
+		if s[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(s) {
+		return sourceCall{}, "", false
+	}
+	arg, err := strconv.Unquote(s[:end+1])
+	if err != nil {
+		return sourceCall{}, "", false
+	}
+	s = strings.TrimLeft(s[end+1:], " \t")
+	if !strings.HasPrefix(s, ")") {
+		return sourceCall{}, "", false
+	}
+	rest = strings.TrimSpace(s[1:])
+	if rest != "" && rest[0] != '|' {
+		return sourceCall{}, "", false
+	}
+	return sourceCall{fn: fn, arg: arg}, rest, true
+}
+
+// envSource returns the environment variable name. -allow-keys, when set,
+// restricts it like keys. With -mode flag or -values-lock, which keep the
+// environment out of the values, it fails rather than read it behind their
+// back.
+func envSource(name string, opts replacerOptions) (string, error) {
+	if opts.EnvOff != "" {
+		return "", fmt.Errorf("the environment is not read with %s", opts.EnvOff)
+	}
+	if opts.Allowed != nil && !opts.Allowed[name] {
+		return "", fmt.Errorf("%q is not in the allow-list", name)
+	}
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %q not set", name)
+	}
+	return v, nil
+}
+
+// fileSource returns the contents of the file at path, relative to the
+// working directory like other paths given to charmap. Like #include
+// partials, unless -allow-outside is set, path must not be absolute or have
+// .. elements and must resolve inside -dir or a -template-path directory.
+func fileSource(path string, opts replacerOptions) (string, error) {
+	if !opts.AllowOutside {
+		if escapesName(path) {
+			return "", fmt.Errorf("absolute paths and .. elements need -allow-outside")
+		}
+		if err := checkReadScope(path, opts.ReadRoots); err != nil {
+			return "", err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// usesSecret reports whether a placeholder of txt delimited by open and close
+// reads a secret() value.
+func usesSecret(txt, open, close []byte) bool {
+	if len(open) == 0 || len(close) == 0 {
+		return false
+	}
+	for {
+		_, after, ok := bytes.Cut(txt, open)
+		if !ok {
+			return false
+		}
+		expr, rest, ok := bytes.Cut(after, close)
+		if !ok {
+			return false
+		}
+		// Most placeholders are keys: leave them unconverted.
+		if bytes.Contains(expr, []byte("secret")) {
+			if c, _, ok := cutCall(string(expr)); ok && c.fn == "secret" {
+				return true
+			}
+		}
+		txt = rest
+	}
+}
+
+// secretSource returns the secret name, the file of that name under
+// -secrets-dir as container runtimes mount them, without its trailing
+// newline. name may have slashes but must stay inside the directory.
+func secretSource(name string, opts replacerOptions) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("%q is outside -secrets-dir", name)
+	}
+	data, err := os.ReadFile(filepath.Join(opts.SecretsDir, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSourceCalls(t *testing.T) {
+	dir := t.TempDir()
+	secrets := filepath.Join(dir, "secrets")
+	if err := os.MkdirAll(filepath.Join(secrets, "db"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(secrets, "db", "password"), []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, []byte("line1\nline2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CHARMAP_TEST_HOST", "db.internal")
+
+	opts := replacerOptions{SecretsDir: secrets, AllowOutside: true}
+	r := buildNewReplacer([]byte("<::"), []byte("::>"), map[string]string{"HOST": "shadowed"}, opts)
+	tests := map[string]string{
+		`host: <::env("CHARMAP_TEST_HOST")::>`:                 "host: db.internal",
+		`pass: <::secret("db/password")::>`:                    "pass: hunter2",
+		`pass: <::secret("db/password") | upper::>`:            "pass: HUNTER2",
+		"ca:\n  <::file(\"" + ca + "\") | autoindent::>":       "ca:\n  line1\n  line2",
+		`port: <::env("CHARMAP_TEST_UNSET") | default 5432::>`: "port: 5432",
+		`key: <::HOST::>`: "key: shadowed",
+	}
+	for in, want := range tests {
+		out, _, err := r([]byte(in))
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+			continue
+		}
+		if string(out) != want {
+			t.Errorf("%s: got %q, want %q", in, out, want)
+		}
+	}
+
+	failures := map[string]string{
+		`<::env("CHARMAP_TEST_UNSET")::>`: `env("CHARMAP_TEST_UNSET"): environment variable "CHARMAP_TEST_UNSET" not set`,
+		`<::secret("../ca.pem")::>`:       "outside -secrets-dir",
+		`<::secret("missing")::>`:         `secret("missing"): open`,
+	}
+	for in, want := range failures {
+		if _, _, err := r([]byte(in)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", in, err, want)
+		}
+	}
+
+	allowed := buildNewReplacer([]byte("<::"), []byte("::>"), nil, replacerOptions{Allowed: map[string]bool{"HOST": true}})
+	if _, _, err := allowed([]byte(`<::env("CHARMAP_TEST_HOST")::>`)); err == nil || !strings.Contains(err.Error(), "allow-list") {
+		t.Errorf("env outside -allow-keys: got error %v", err)
+	}
+}
+
+func TestCutCall(t *testing.T) {
+	tests := []struct {
+		expr, call, rest string
+		ok               bool
+	}{
+		{`env("FOO")`, `env("FOO")`, "", true},
+		{` file( "a \"b\".pem" ) | indent 2`, `file("a \"b\".pem")`, "| indent 2", true},
+		{`lookup("FOO")`, "", "", false},
+		{`env(FOO)`, "", "", false},
+		{`env("FOO") extra`, "", "", false},
+		{`ENV`, "", "", false},
+	}
+	for _, tt := range tests {
+		c, rest, ok := cutCall(tt.expr)
+		if ok != tt.ok || (ok && (c.String() != tt.call || rest != tt.rest)) {
+			t.Errorf("cutCall(%q) = %s, %q, %v, want %s, %q, %v", tt.expr, c, rest, ok, tt.call, tt.rest, tt.ok)
+		}
+	}
+}
+
+func TestCheckTree_SourceCalls(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "app.yaml")
+	if err := os.WriteFile(tmpl, []byte("a: <::env(\"CHARMAP_TEST_UNSET\")::>\nb: <::env(\"CHARMAP_TEST_UNSET\") | default x::>\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ff, _ := newFileFilter(nil, nil)
+	cfg := config{OpenDelim: "<::", CloseDelim: "::>", TargetDir: dir, Workers: 1, FileFilter: ff}
+	var sb strings.Builder
+	n, err := checkTree(cfg, &sb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := tmpl + `:1: env("CHARMAP_TEST_UNSET"): environment variable "CHARMAP_TEST_UNSET" not set`; n != 1 || strings.TrimSpace(sb.String()) != want {
+		t.Errorf("got %d:\n%s\nwant 1:\n%s", n, sb.String(), want)
+	}
+}
+
+func TestSourceCalls_Confined(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(filepath.Join("tree", "certs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, txt := range map[string]string{"tree/certs/ca.pem": "ca", "secret.txt": "s3cr3t"} {
+		if err := os.WriteFile(filepath.FromSlash(name), []byte(txt), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	abs, _ := filepath.Abs("secret.txt")
+	symlinked := os.Symlink(filepath.Join("..", "secret.txt"), filepath.Join("tree", "link.pem")) == nil
+
+	r := buildNewReplacer([]byte("<::"), []byte("::>"), nil, replacerOptions{ReadRoots: []string{"tree"}})
+	if out, _, err := r([]byte(`<::file("tree/certs/ca.pem")::>`)); err != nil || string(out) != "ca" {
+		t.Errorf("file inside -dir: got %q, %v", out, err)
+	}
+	failures := map[string]string{
+		`<::file("secret.txt")::>`:         "outside tree",
+		`<::file("tree/../secret.txt")::>`: "-allow-outside",
+		`<::file("` + abs + `")::>`:        "-allow-outside",
+	}
+	if symlinked {
+		failures[`<::file("tree/link.pem")::>`] = "outside tree"
+	}
+	for in, want := range failures {
+		if out, _, err := r([]byte(in)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %q, %v, want an error %q", in, out, err, want)
+		}
+	}
+
+	// -mode flag and -values-lock keep env() from reading the environment.
+	t.Setenv("CHARMAP_TEST_HOST", "db.internal")
+	for _, cfg := range []config{{Mode: "flag"}, {Mode: "both", ValuesLock: "values.lock"}} {
+		r := buildNewReplacer([]byte("<::"), []byte("::>"), nil, cfg.replacerOptions())
+		if out, _, err := r([]byte(`<::env("CHARMAP_TEST_HOST")::>`)); err == nil || !strings.Contains(err.Error(), cfg.envOff()) {
+			t.Errorf("env with %s: got %q, %v", cfg.envOff(), out, err)
+		}
+	}
+}
+
+func TestProcessTree_ReadableSecrets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes do not limit readers on Windows")
+	}
+	src, out, secrets := t.TempDir(), t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(secrets, "db"), []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := filepath.Join(src, "app.yaml")
+	if err := os.WriteFile(tmpl, []byte("pass: <::secret(\"db\") | upper::>\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "plain.yaml"), []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ff, _ := newFileFilter(nil, nil)
+	cfg := config{OpenDelim: "<::", CloseDelim: "::>", TargetDir: src, OutDir: out, Workers: 1,
+		KeyMap: map[string]string{"V": "1"}, FileFilter: ff, SecretsDir: secrets}
+
+	if _, err := processTree(cfg); err == nil || !strings.Contains(err.Error(), "-readable-secrets") {
+		t.Errorf("0644 template: got %v, want an error suggesting -readable-secrets", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "app.yaml")); !os.IsNotExist(err) {
+		t.Errorf("secret written to a readable file: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "plain.yaml")); err != nil {
+		t.Errorf("file without secrets: %v", err)
+	}
+
+	if err := os.Chmod(tmpl, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Errorf("0600 template: %v", err)
+	}
+	if err := os.Chmod(tmpl, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.ReadableSecrets = true
+	if _, err := processTree(cfg); err != nil {
+		t.Errorf("-readable-secrets: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "app.yaml")); string(data) != "pass: HUNTER2\n" {
+		t.Errorf("got %q", data)
+	}
+}
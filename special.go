@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+)
+
+// specialFileKind names the kind of a non-regular file that must not be read:
+// reading a FIFO blocks until a writer shows up and device files may never
+// end. Symlinks are followed. It returns "" for regular files.
+func specialFileKind(path string, d fs.DirEntry) string {
+	mode := d.Type()
+	if mode&fs.ModeSymlink != 0 {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return ""
+		}
+		mode = fi.Mode().Type()
+	}
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "character device"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	case mode&fs.ModeIrregular != 0:
+		return "irregular file"
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWalkFiles_SkipsFIFO(t *testing.T) {
+	tmp := t.TempDir()
+	if err := syscall.Mkfifo(filepath.Join(tmp, "pipe.yaml"), 0o644); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	if err := os.Symlink("pipe.yaml", filepath.Join(tmp, "link.yaml")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "app.yaml"), nil, 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(f *os.File) { os.Stderr = f }(os.Stderr)
+	os.Stderr = stderr
+
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	var got []string
+	err = walkFiles(config{TargetDir: tmp, FileFilter: ff}, func(p string) error {
+		got = append(got, filepath.Base(p))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walkFiles: %v", err)
+	}
+	if len(got) != 1 || got[0] != "app.yaml" {
+		t.Errorf("walked %v, want only app.yaml", got)
+	}
+	warned, _ := os.ReadFile(stderr.Name())
+	for _, name := range []string{"pipe.yaml, it is a named pipe", "link.yaml, it is a named pipe"} {
+		if !strings.Contains(string(warned), name) {
+			t.Errorf("stderr = %q, want a warning for %s", warned, name)
+		}
+	}
+}
+
+func TestProcessTree_HardLinks(t *testing.T) {
+	for _, policy := range []string{"once", "break"} {
+		src := t.TempDir()
+		a, b := filepath.Join(src, "a.yaml"), filepath.Join(src, "b.yaml")
+		if err := os.WriteFile(a, []byte("v: <::V::>\n"), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+		if err := os.Link(a, b); err != nil {
+			t.Skipf("link: %v", err)
+		}
+		ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+		cfg := config{
+			OpenDelim:  "<::",
+			CloseDelim: "::>",
+			TargetDir:  src,
+			Workers:    2,
+			KeyMap:     map[string]string{"V": "1"},
+			FileFilter: ff,
+			HardLinks:  policy,
+		}
+		results, err := processTree(cfg)
+		if err != nil {
+			t.Fatalf("%s: processTree: %v", policy, err)
+		}
+		for _, p := range []string{a, b} {
+			if got, _ := os.ReadFile(p); string(got) != "v: 1\n" {
+				t.Errorf("%s: %s = %q, want it rendered", policy, filepath.Base(p), got)
+			}
+		}
+
+		fa, _ := os.Stat(a)
+		fb, _ := os.Stat(b)
+		switch policy {
+		case "once":
+			if len(results) != 1 || !os.SameFile(fa, fb) {
+				t.Errorf("once: %d result(s), same file %v; want one render of the shared file", len(results), os.SameFile(fa, fb))
+			}
+		case "break":
+			if len(results) != 2 || os.SameFile(fa, fb) {
+				t.Errorf("break: %d result(s), same file %v; want two separate files", len(results), os.SameFile(fa, fb))
+			}
+		}
+	}
+}
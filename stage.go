@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// stageMarker brackets the index of a placeholder held back for a later stage.
+const stageMarker = "\x00charmap-stage\x00"
+
+// resolveStages prepares txt for rendering pass stage. A placeholder tagged
+// with a stage, such as <::2:KEY::>, loses its tag in its own pass and then
+// renders like any other. Placeholders of later stages are masked so that the
+// pass leaves them verbatim; the masked text is returned for unmaskRegions.
+// A placeholder of an earlier stage is an error, since its pass should have
+// rendered it. Stage 0 renders every stage at once.
+func resolveStages(txt, open, close string, stage int) (string, []string, error) {
+	if !strings.Contains(txt, open) {
+		return txt, nil, nil
+	}
+
+	var sb strings.Builder
+	var later []string
+	pos, search := 0, 0 // pos is the start of text not yet copied to sb
+	for {
+		idx := strings.Index(txt[search:], open)
+		if idx == -1 {
+			break
+		}
+		idx += search
+		start := idx + len(open)
+		end := strings.Index(txt[start:], close)
+		if end == -1 {
+			break
+		}
+		end += start
+
+		n, expr, ok := stageTag(txt[start:end])
+		if !ok {
+			// The text after open may still hold a tagged placeholder, as in
+			// "<::<::1:KEY::>".
+			search = start
+			continue
+		}
+		sb.WriteString(txt[pos:idx])
+		switch {
+		case stage == 0 || n == stage:
+			sb.WriteString(open + expr + close)
+		case n > stage:
+			sb.WriteString(stageMarker + strconv.Itoa(len(later)) + stageMarker)
+			later = append(later, txt[idx:end+len(close)])
+		default:
+			line := strings.Count(txt[:idx], "\n") + 1
+			return "", nil, fmt.Errorf("line %d: stage %d placeholder %s was not rendered by an earlier pass", line, n, txt[idx:end+len(close)])
+		}
+		pos = end + len(close)
+		search = pos
+	}
+	if pos == 0 {
+		return txt, nil, nil
+	}
+	sb.WriteString(txt[pos:])
+	return sb.String(), later, nil
+}
+
+// stageTag splits a placeholder expression of the form "N:EXPR" into its
+// stage and the expression. ok is false when expr carries no stage tag.
+func stageTag(expr string) (stage int, rest string, ok bool) {
+	num, rest, found := strings.Cut(expr, ":")
+	if !found || num == "" || rest == "" {
+		return 0, "", false
+	}
+	for i := 0; i < len(num); i++ {
+		if num[i] < '0' || num[i] > '9' {
+			return 0, "", false
+		}
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n == 0 {
+		return 0, "", false
+	}
+	return n, rest, true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStages(t *testing.T) {
+	const in = `image: <::1:IMAGE::>
+host: <::2:HOST | upper::>
+name: <::NAME::>
+`
+	build := map[string]string{"IMAGE": "app:1.2", "NAME": "svc"}
+	deploy := map[string]string{"HOST": "example.com"}
+
+	first, _, err := buildNewReplacer([]byte("<::"), []byte("::>"), build, replacerOptions{Stage: 1})([]byte(in))
+	if err != nil {
+		t.Fatalf("stage 1: %v", err)
+	}
+	const wantFirst = `image: app:1.2
+host: <::2:HOST | upper::>
+name: svc
+`
+	if string(first) != wantFirst {
+		t.Errorf("stage 1: got %q, want %q", first, wantFirst)
+	}
+
+	second, _, err := buildNewReplacer([]byte("<::"), []byte("::>"), deploy, replacerOptions{Stage: 2})(first)
+	if err != nil {
+		t.Fatalf("stage 2: %v", err)
+	}
+	if want := "image: app:1.2\nhost: EXAMPLE.COM\nname: svc\n"; string(second) != want {
+		t.Errorf("stage 2: got %q, want %q", second, want)
+	}
+
+	all := map[string]string{"IMAGE": "app:1.2", "NAME": "svc", "HOST": "example.com"}
+	out, _, err := buildNewReplacer([]byte("<::"), []byte("::>"), all, replacerOptions{})([]byte(in))
+	if err != nil {
+		t.Fatalf("all stages: %v", err)
+	}
+	if string(out) != string(second) {
+		t.Errorf("all stages: got %q, want %q", out, second)
+	}
+
+	_, _, err = buildNewReplacer([]byte("<::"), []byte("::>"), deploy, replacerOptions{Stage: 2})([]byte(in))
+	if err == nil || !strings.Contains(err.Error(), "line 1: stage 1 placeholder") {
+		t.Errorf("skipped stage: got %v, want a stage 1 error", err)
+	}
+}
+
+func TestFindMissing_Stage(t *testing.T) {
+	const txt = "a: <::1:A::>\nb: <::2:B::>\n"
+	missing, err := findMissing(txt, "<::", "::>", map[string]string{}, replacerOptions{Stage: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0] != (missingKey{Key: "A", Line: 1}) {
+		t.Errorf("got %+v, want only A on line 1", missing)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// syntaxMarker brackets the index of text that -syntax protects from
+// substitution.
+const syntaxMarker = "\x00charmap-syntax\x00"
+
+// syntaxMode makes substitution aware of a file format: placeholders are only
+// rendered outside the spans protected returns, and substituted values are
+// escaped for the format.
+type syntaxMode struct {
+	protected func(txt, open, close string) [][2]int
+	escape    func(string) string
+}
+
+// syntaxModes holds every -syntax. plain substitutes everywhere.
+var syntaxModes = map[string]syntaxMode{
+	"plain": {},
+	"xml":   {protected: xmlProtected, escape: xmlEscaper.Replace},
+	"ini":   {protected: iniProtected},
+}
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+
+// xmlProtected returns the spans of txt that are not text nodes or attribute
+// values: element and attribute names, comments, CDATA sections, processing
+// instructions and declarations.
+func xmlProtected(txt, open, close string) [][2]int {
+	var spans [][2]int
+	upTo := func(from int, end string) int {
+		idx := strings.Index(txt[from:], end)
+		if idx == -1 {
+			return len(txt)
+		}
+		return from + idx + len(end)
+	}
+
+	for i := 0; i < len(txt); {
+		if strings.HasPrefix(txt[i:], open) {
+			// A placeholder in a text node; its delimiters may look like markup.
+			if end := strings.Index(txt[i+len(open):], close); end != -1 {
+				i += len(open) + end + len(close)
+				continue
+			}
+		}
+		if txt[i] != '<' {
+			i++
+			continue
+		}
+
+		var end int
+		switch rest := txt[i:]; {
+		case strings.HasPrefix(rest, "<!--"):
+			end = upTo(i, "-->")
+		case strings.HasPrefix(rest, "<![CDATA["):
+			end = upTo(i, "]]>")
+		case strings.HasPrefix(rest, "<?"):
+			end = upTo(i, "?>")
+		case strings.HasPrefix(rest, "<!"):
+			end = upTo(i, ">")
+		default:
+			// A tag: everything but the contents of quoted attribute values.
+			start, j := i, i+1
+			for j < len(txt) && txt[j] != '>' {
+				if q := txt[j]; q == '"' || q == '\'' {
+					spans = append(spans, [2]int{start, j + 1})
+					k := strings.IndexByte(txt[j+1:], q)
+					if k == -1 {
+						return spans
+					}
+					start, j = j+1+k, j+1+k
+				}
+				j++
+			}
+			end = min(j+1, len(txt))
+			spans = append(spans, [2]int{start, end})
+			i = end
+			continue
+		}
+		spans = append(spans, [2]int{i, end})
+		i = end
+	}
+	return spans
+}
+
+// iniProtected returns the spans of txt that are not values: section headers,
+// comments and keys up to and including their '=' or ':'. Indented lines
+// without a separator continue the previous value.
+func iniProtected(txt, open, close string) [][2]int {
+	var spans [][2]int
+	for pos := 0; pos < len(txt); {
+		end := strings.IndexByte(txt[pos:], '\n')
+		if end == -1 {
+			end = len(txt)
+		} else {
+			end += pos
+		}
+		line := txt[pos:end]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+		case trimmed[0] == ';' || trimmed[0] == '#' || trimmed[0] == '[':
+			spans = append(spans, [2]int{pos, end})
+		default:
+			if sep := iniSeparator(line, open, close); sep != -1 {
+				spans = append(spans, [2]int{pos, pos + sep + 1})
+			} else if line[0] != ' ' && line[0] != '\t' {
+				spans = append(spans, [2]int{pos, end})
+			}
+		}
+		pos = end + 1
+	}
+	return spans
+}
+
+// iniSeparator returns the offset of the first '=' or ':' in line outside a
+// placeholder, or -1.
+func iniSeparator(line, open, close string) int {
+	for i := 0; i < len(line); i++ {
+		if strings.HasPrefix(line[i:], open) {
+			if end := strings.Index(line[i+len(open):], close); end != -1 {
+				i += len(open) + end + len(close) - 1
+				continue
+			}
+		}
+		if line[i] == '=' || line[i] == ':' {
+			return i
+		}
+	}
+	return -1
+}
+
+// maskSpans replaces every span of txt that holds the open delimiter with a
+// marker, returning the masked text for unmaskRegions.
+func maskSpans(txt, open, marker string, spans [][2]int) (string, []string) {
+	var sb strings.Builder
+	var regions []string
+	pos := 0
+	for _, s := range spans {
+		if !strings.Contains(txt[s[0]:s[1]], open) {
+			continue
+		}
+		if regions == nil {
+			sb.Grow(len(txt))
+		}
+		sb.WriteString(txt[pos:s[0]])
+		sb.WriteString(marker + strconv.Itoa(len(regions)) + marker)
+		regions = append(regions, txt[s[0]:s[1]])
+		pos = s[1]
+	}
+	if regions == nil {
+		return txt, nil
+	}
+	sb.WriteString(txt[pos:])
+	return sb.String(), regions
+}
+
+// blankSpans replaces every span of txt with its newlines, keeping line
+// numbers intact.
+func blankSpans(txt string, spans [][2]int) string {
+	if len(spans) == 0 {
+		return txt
+	}
+	var sb strings.Builder
+	sb.Grow(len(txt))
+	pos := 0
+	for _, s := range spans {
+		sb.WriteString(txt[pos:s[0]])
+		sb.WriteString(strings.Repeat("\n", strings.Count(txt[s[0]:s[1]], "\n")))
+		pos = s[1]
+	}
+	sb.WriteString(txt[pos:])
+	return sb.String()
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestSyntaxModes(t *testing.T) {
+	values := map[string]string{"URL": `https://example.com/?a=1&b="2"`, "NAME": "db", "K": "key"}
+	tests := []struct {
+		syntax, in, want string
+	}{
+		{
+			syntax: "xml",
+			in: `<?xml version="1.0"?>
+<!-- set <::NAME::> below -->
+<config name="<::NAME::>" <::K::>="x">
+  <url><::URL::></url>
+  <upper><::NAME | upper::></upper>
+  <raw><![CDATA[<::URL::>]]></raw>
+</config>
+`,
+			want: `<?xml version="1.0"?>
+<!-- set <::NAME::> below -->
+<config name="db" <::K::>="x">
+  <url>https://example.com/?a=1&amp;b=&quot;2&quot;</url>
+  <upper>DB</upper>
+  <raw><![CDATA[<::URL::>]]></raw>
+</config>
+`,
+		},
+		{
+			syntax: "ini",
+			in: `; <::NAME::> settings
+[<::NAME::>]
+<::K::> = <::NAME::>
+url: <::URL::>
+  <::NAME::>
+`,
+			want: `; <::NAME::> settings
+[<::NAME::>]
+<::K::> = db
+url: https://example.com/?a=1&b="2"
+  db
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.syntax, func(t *testing.T) {
+			r := buildNewReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{Syntax: tt.syntax})
+			out, _, err := r([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
+			}
+
+			missing, err := findMissing(tt.in, "<::", "::>", map[string]string{}, replacerOptions{Syntax: tt.syntax})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range missing {
+				if m.Key == "K" {
+					t.Errorf("key %q in protected text reported missing", m.Key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tarTree is a tar archive as an fs.FS. Only its headers are read up front;
+// the contents of a file are read from the archive when it is opened.
+type tarTree struct {
+	r       io.ReaderAt
+	entries map[string]*tarEntry
+}
+
+// tarEntry is a regular file of a tar archive, or a directory, which is
+// implied by the names below it.
+type tarEntry struct {
+	name     string // slash-separated, "." for the root
+	mode     fs.FileMode
+	modTime  time.Time
+	off      int64
+	size     int64
+	children []*tarEntry // sorted by name, for directories
+}
+
+// tarFS indexes the tar archive in r, size bytes long. Only regular files
+// are kept, like special files on disk are skipped; names leaving the
+// archive root are an error.
+func tarFS(r io.ReaderAt, size int64) (fs.FS, error) {
+	t := &tarTree{r: r, entries: map[string]*tarEntry{".": {name: ".", mode: fs.ModeDir | 0o755}}}
+	sr := io.NewSectionReader(r, 0, size)
+	tr := tar.NewReader(sr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		for k := range hdr.PAXRecords {
+			if strings.HasPrefix(k, "GNU.sparse.") {
+				return nil, fmt.Errorf("archive entry %q is a sparse file, which is not supported", hdr.Name)
+			}
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("archive entry %q leaves the archive root", hdr.Name)
+		}
+		// The reader stops right after the header, at the file's contents.
+		off, err := sr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		e := &tarEntry{name: name, mode: hdr.FileInfo().Mode().Perm(), modTime: hdr.ModTime, off: off, size: hdr.Size}
+		if err := t.add(e); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range t.entries {
+		sort.Slice(e.children, func(i, j int) bool { return e.children[i].name < e.children[j].name })
+	}
+	return t, nil
+}
+
+// add enters e and the directories above it. A later entry of the same name
+// replaces an earlier one, as extracting the archive would.
+func (t *tarTree) add(e *tarEntry) error {
+	if old, ok := t.entries[e.name]; ok {
+		if old.IsDir() {
+			return fmt.Errorf("archive entry %q is both a file and a directory", e.name)
+		}
+		*old = *e
+		return nil
+	}
+	t.entries[e.name] = e
+	for child := e; child.name != "."; {
+		dir := path.Dir(child.name)
+		parent, ok := t.entries[dir]
+		if ok && !parent.IsDir() {
+			return fmt.Errorf("archive entry %q is both a file and a directory", dir)
+		}
+		if !ok {
+			parent = &tarEntry{name: dir, mode: fs.ModeDir | 0o755, modTime: e.modTime}
+			t.entries[dir] = parent
+		}
+		parent.children = append(parent.children, child)
+		if ok {
+			break
+		}
+		child = parent
+	}
+	return nil
+}
+
+func (t *tarTree) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := t.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.IsDir() {
+		return &tarDir{entry: e}, nil
+	}
+	return &tarFile{SectionReader: io.NewSectionReader(t.r, e.off, e.size), entry: e}, nil
+}
+
+// tarEntry is its own fs.FileInfo and fs.DirEntry.
+
+func (e *tarEntry) Name() string               { return path.Base(e.name) }
+func (e *tarEntry) Size() int64                { return e.size }
+func (e *tarEntry) Mode() fs.FileMode          { return e.mode }
+func (e *tarEntry) ModTime() time.Time         { return e.modTime }
+func (e *tarEntry) IsDir() bool                { return e.mode.IsDir() }
+func (e *tarEntry) Sys() any                   { return nil }
+func (e *tarEntry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *tarEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+// tarFile is an open file of a tarTree.
+type tarFile struct {
+	*io.SectionReader
+	entry *tarEntry
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *tarFile) Close() error               { return nil }
+
+// tarDir is an open directory of a tarTree.
+type tarDir struct {
+	entry *tarEntry
+	pos   int
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *tarDir) Close() error               { return nil }
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: fs.ErrInvalid}
+}
+
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entry.children[d.pos:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.pos += len(rest)
+	entries := make([]fs.DirEntry, len(rest))
+	for i, e := range rest {
+		entries[i] = e
+	}
+	return entries, nil
+}
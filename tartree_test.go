@@ -0,0 +1,58 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTarFS(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct {
+		typ        byte
+		name, body string
+	}{
+		{tar.TypeDir, "./deploy/", ""},
+		{tar.TypeReg, "./deploy/app.yaml", "old"},
+		{tar.TypeReg, "deploy/db/db.yaml", "db"},
+		{tar.TypeSymlink, "deploy/link.yaml", ""},
+		{tar.TypeReg, "README", "readme"},
+		// A later entry of the same name wins, as when extracting.
+		{tar.TypeReg, "deploy/app.yaml", "new"},
+	} {
+		hdr := &tar.Header{Typeflag: e.typ, Name: e.name, Mode: 0o644, Size: int64(len(e.body)), Linkname: "app.yaml"}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.body))
+	}
+	tw.Close()
+
+	fsys, err := tarFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "README", "deploy/app.yaml", "deploy/db/db.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fs.ReadFile(fsys, "deploy/app.yaml"); string(got) != "new" {
+		t.Errorf("deploy/app.yaml = %q, want the later entry", got)
+	}
+	if _, err := fs.Stat(fsys, "deploy/link.yaml"); err == nil {
+		t.Error("tarFS kept a symlink")
+	}
+}
+
+func TestTarFS_UnsafeName(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../escape.yaml", Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+	if _, err := tarFS(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+		t.Error("tarFS accepted an entry outside the archive root")
+	}
+}
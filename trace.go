@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// traceFile explains, for the file cfg.TracePath, every decision a render
+// makes: each delimiter found with its line, column and byte range, the key
+// parsed from it, where its value comes from and whether it is replaced. The
+// run itself is not affected. Values are never written.
+func traceFile(cfg config, w io.Writer) error {
+	path := cfg.TracePath
+	in, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	txt := string(in)
+
+	if rel, err := relativeTo(cfg.TargetDir, path); err != nil || !filepath.IsLocal(rel) {
+		fmt.Fprintf(w, "trace %s: outside -dir %s, the run does not render it\n", path, cfg.TargetDir)
+	} else if !cfg.FileFilter.match(filepath.Join(cfg.TargetDir, rel)) {
+		fmt.Fprintf(w, "trace %s: excluded by -include/-ignore, the run does not render it\n", path)
+	} else if cfg.skipContent(in) {
+		fmt.Fprintf(w, "trace %s: skipped by -ignore-content or -skip-vendored, the run does not render it\n", path)
+	}
+
+	side, sideOrigins, err := loadLocalValues(path, cfg)
+	if err != nil {
+		return err
+	}
+	keySets := []profile{{KeyMap: cfg.KeyMap, Origins: cfg.Origins}}
+	if len(cfg.Profiles) > 0 {
+		keySets = cfg.Profiles
+	}
+	open, close := cfg.delims(path)
+	for _, ks := range keySets {
+		prefix := "trace " + path
+		if ks.Name != "" {
+			prefix += fmt.Sprintf(": profile %q", ks.Name)
+		}
+		values, opts := map[string]string(ks.KeyMap), cfg.replacerOptions()
+		if side != nil {
+			if values, opts, err = withSidecar(side, values, opts); err != nil {
+				return err
+			}
+		}
+		refs, err := scanPlaceholders(txt, open, close, values, opts)
+		if err != nil {
+			fmt.Fprintf(w, "%s: %v\n", prefix, err)
+			continue
+		}
+		rendered := make(map[string]int)
+		for _, ref := range refs {
+			rendered[strconv.Itoa(ref.Line)+"\x00"+ref.Key]++
+		}
+
+		source := func(key string) string {
+			if o, ok := sideOrigins[key]; ok {
+				return o
+			}
+			if o := ks.Origins[key]; o != "" {
+				return o
+			}
+			return "values"
+		}
+		var hits int
+		for _, r := range splitDelimPragmas(txt) {
+			o, c := open, close
+			if r.open != "" {
+				o, c = r.open, r.close
+			}
+			for _, h := range delimHits(r.text, o, c) {
+				hits++
+				start := r.offset + h.start
+				line := strings.Count(txt[:start], "\n") + 1
+				col := start - strings.LastIndex(txt[:start], "\n")
+				fmt.Fprintf(w, "%s:%d:%d: bytes %d-%d: ", prefix, line, col, start, r.offset+h.end)
+				if h.end == h.start+len(o) {
+					fmt.Fprintf(w, "%q without %q, left as is\n", o, c)
+					continue
+				}
+				expr := r.text[h.start+len(o) : h.end-len(c)]
+				fmt.Fprintf(w, "%s: %s\n", r.text[h.start:h.end], traceDecision(expr, line, values, opts, rendered, source))
+			}
+		}
+		if hits == 0 {
+			fmt.Fprintf(w, "%s: no %q found\n", prefix, open)
+		}
+	}
+	return nil
+}
+
+// traceDecision describes what a render does with the placeholder expression
+// expr on line. A key found in rendered is counted off, so that repeated
+// placeholders on one line are matched one by one.
+func traceDecision(expr string, line int, values map[string]string, opts replacerOptions, rendered map[string]int, source func(string) string) string {
+	if strings.HasPrefix(strings.TrimSpace(expr), "#") {
+		return "directive"
+	}
+	if _, rest, ok := stageTag(expr); ok {
+		expr = rest
+	}
+	key, hasDefault := expr, false
+	var src *sourceCall
+	if _, _, call := cutCall(expr); call || strings.Contains(expr, "|") {
+		p, err := parsePipeline(expr)
+		if err != nil {
+			return err.Error()
+		}
+		key, src = p.key, p.source
+		hasDefault = len(p.calls) > 0 && p.calls[0].name == "default"
+	}
+
+	id := strconv.Itoa(line) + "\x00" + key
+	if rendered[id] == 0 {
+		return fmt.Sprintf("key %s not rendered: inside an -opaque region, an #if branch not taken, a later -stage or text protected by -syntax", key)
+	}
+	rendered[id]--
+	if src != nil {
+		_, err := src.value(opts)
+		switch {
+		case err == nil:
+			return fmt.Sprintf("%s read by its function, replaced", key)
+		case hasDefault:
+			return fmt.Sprintf("%v, replaced by its default", err)
+		default:
+			return err.Error()
+		}
+	}
+	switch _, ok := values[key]; {
+	case ok:
+		return fmt.Sprintf("key %s from %s, replaced", key, source(key))
+	case hasDefault:
+		return fmt.Sprintf("key %s not set, replaced by its default", key)
+	default:
+		return fmt.Sprintf("key %s: %v", key, &missingKeyError{key: key, denied: opts.Denied[key]})
+	}
+}
+
+// delimHit is one opening delimiter in a text and the end of its placeholder,
+// which is just past the delimiter when it is never closed.
+type delimHit struct {
+	start, end int
+}
+
+// delimHits returns every placeholder of txt, found like scanPlaceholders
+// does: from each open to the next close.
+func delimHits(txt, open, close string) []delimHit {
+	var hits []delimHit
+	pos := 0
+	for {
+		idx := strings.Index(txt[pos:], open)
+		if idx == -1 {
+			return hits
+		}
+		start := pos + idx
+		end := strings.Index(txt[start+len(open):], close)
+		if end == -1 {
+			return append(hits, delimHit{start, start + len(open)})
+		}
+		end += start + len(open) + len(close)
+		hits = append(hits, delimHit{start, end})
+		pos = end
+	}
+}
+
+// relativeTo returns path relative to dir, both made absolute first.
+func relativeTo(dir, path string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absDir, absPath)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTraceFile(t *testing.T) {
+	src := t.TempDir()
+	path := filepath.Join(src, "app.yaml")
+	txt := "a: <::A::>\n<::#if has(C)::>\nc: <::C::>\n<::#end::>\nd: <::D | default \"x\"::> <::E::> <::F\n"
+	if err := os.WriteFile(path, []byte(txt), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	if err := os.WriteFile(path+sidecarSuffix, []byte("E=side\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		KeyMap:     map[string]string{"A": "secret-1"},
+		Origins:    map[string]string{"A": "env"},
+		FileFilter: ff,
+		TracePath:  path,
+	}
+
+	var buf bytes.Buffer
+	if err := traceFile(cfg, &buf); err != nil {
+		t.Fatalf("traceFile: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"app.yaml:1:4: bytes 3-10: <::A::>: key A from env, replaced\n",
+		"app.yaml:2:1: bytes 11-27: <::#if has(C)::>: directive\n",
+		"app.yaml:3:4: bytes 31-38: <::C::>: key C not rendered",
+		"app.yaml:5:4: bytes 53-74: <::D | default \"x\"::>: key D not set, replaced by its default\n",
+		"app.yaml:5:26: bytes 75-82: <::E::>: key E from " + path + sidecarSuffix + ", replaced\n",
+		"app.yaml:5:34: bytes 83-86: \"<::\" without \"::>\", left as is\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("trace lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "secret-1") {
+		t.Errorf("trace leaks a value:\n%s", got)
+	}
+
+	cfg.FileFilter, _ = newFileFilter([]string{`.*\.json$`}, nil)
+	buf.Reset()
+	if err := traceFile(cfg, &buf); err != nil {
+		t.Fatalf("traceFile: %v", err)
+	}
+	if !strings.Contains(buf.String(), "excluded by -include/-ignore") {
+		t.Errorf("trace of an excluded file:\n%s", buf.String())
+	}
+}
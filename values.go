@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// buildKeyMap merges the value sources in precedence order: environment
+// variables, then values files in the order given, then -set pairs. Values
+// that come from files may reference other keys as ${KEY}; they are expanded
+// against the merged map once all sources are applied.
+func buildKeyMap(useEnv, useFlags bool, files []string, set map[string]string) (map[string]string, error) {
+	values, _, err := buildKeyMapWithOrigins(useEnv, useFlags, files, set)
+	return values, err
+}
+
+// buildKeyMapWithOrigins is buildKeyMap that also returns where each key's
+// value came from: "env", "set" or "values:PATH". Values from -set and files
+// may be encoded, see decodeValues; environment variables are taken as is.
+func buildKeyMapWithOrigins(useEnv, useFlags bool, files []string, set map[string]string) (map[string]string, map[string]string, error) {
+	values := make(map[string]string)
+	origins := make(map[string]string)
+	fromFile := make(map[string]bool)
+	explicit := make(map[string]bool) // keys whose values may be encoded
+	if useEnv {
+		for _, kv := range os.Environ() {
+			if idx := strings.IndexByte(kv, '='); idx != -1 {
+				values[kv[:idx]] = kv[idx+1:]
+				origins[kv[:idx]] = "env"
+			}
+		}
+	}
+	for _, path := range files {
+		fileValues, err := loadValuesFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load values file %q: %w", path, err)
+		}
+		for k, v := range fileValues {
+			values[k] = v
+			origins[k] = "values:" + path
+			fromFile[k] = true
+			explicit[k] = true
+		}
+	}
+	if useFlags {
+		for k, v := range set {
+			values[k] = v
+			origins[k] = "set"
+			delete(fromFile, k)
+			explicit[k] = true
+		}
+	}
+
+	if err := decodeValues(values, explicit, fromFile); err != nil {
+		return nil, nil, err
+	}
+	if err := interpolateValues(values, fromFile); err != nil {
+		return nil, nil, err
+	}
+	return values, origins, nil
+}
+
+// addFallback sets every key of extra that values lacks, with origin, so that
+// every other source wins over it.
+func addFallback(values, origins, extra map[string]string, origin string) {
+	for k, v := range extra {
+		if _, ok := values[k]; !ok {
+			values[k], origins[k] = v, origin
+		}
+	}
+}
+
+// expandJSONEnv adds a key for every leaf of each environment variable whose
+// value is a JSON object, named by the path to it: APP_CONFIG='{"db":{"host":
+// "x"}}' gives APP_CONFIG.db.host=x, and array elements are numbered from 0.
+// The variable keeps its own value, and keys set by any source win over the
+// expanded ones. Values that only look like JSON are logged and left as is.
+func expandJSONEnv(values, origins map[string]string) {
+	var blobs []string
+	for k, o := range origins {
+		if o == "env" && strings.HasPrefix(strings.TrimSpace(values[k]), "{") {
+			blobs = append(blobs, k)
+		}
+	}
+	for _, k := range blobs {
+		dec := json.NewDecoder(strings.NewReader(values[k]))
+		dec.UseNumber()
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			slog.Warn("environment variable is not a JSON object, not expanded", slog.String("key", k), slog.Any("error", err))
+			continue
+		}
+		flattenJSON(k, obj, func(key, val string) {
+			if _, ok := values[key]; !ok {
+				values[key] = val
+				origins[key] = "env"
+			}
+		})
+	}
+}
+
+// flattenJSON calls set for every leaf below v, with its path joined to
+// prefix by dots.
+func flattenJSON(prefix string, v any, set func(key, val string)) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			flattenJSON(prefix+"."+k, child, set)
+		}
+	case []any:
+		for i, child := range v {
+			flattenJSON(prefix+"."+strconv.Itoa(i), child, set)
+		}
+	case nil:
+		set(prefix, "")
+	default:
+		set(prefix, fmt.Sprint(v))
+	}
+}
+
+// decodeValues replaces the values of keys written as b64:DATA (standard
+// base64) or hex:DATA with the bytes they encode, so certificates and keys
+// need no quoting. Decoded values are taken literally: they are removed from
+// interpolate, the keys ${KEY} references are expanded in.
+func decodeValues(values map[string]string, keys, interpolate map[string]bool) error {
+	for k := range keys {
+		v := values[k]
+		var data []byte
+		var err error
+		switch {
+		case strings.HasPrefix(v, "b64:"):
+			data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(v[len("b64:"):]))
+		case strings.HasPrefix(v, "hex:"):
+			data, err = hex.DecodeString(strings.TrimSpace(v[len("hex:"):]))
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("key %q: invalid %s value: %w", k, v[:3], err)
+		}
+		values[k] = string(data)
+		delete(interpolate, k)
+	}
+	return nil
+}
+
+// interpolateValues expands ${KEY} references in the values of the given keys.
+// References resolve against values, recursively for other interpolated keys;
+// "$${" escapes a literal "${".
+func interpolateValues(values map[string]string, keys map[string]bool) error {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(keys))
+
+	var resolve func(key string, chain []string) error
+	resolve = func(key string, chain []string) error {
+		switch state[key] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("cycle in value references: %s", strings.Join(append(chain, key), " -> "))
+		}
+		state[key] = visiting
+		chain = append(chain, key)
+
+		v := values[key]
+		var sb strings.Builder
+		for {
+			idx := strings.Index(v, "${")
+			if idx == -1 {
+				sb.WriteString(v)
+				break
+			}
+			if idx > 0 && v[idx-1] == '$' {
+				sb.WriteString(v[:idx-1] + "${")
+				v = v[idx+2:]
+				continue
+			}
+			end := strings.IndexByte(v[idx+2:], '}')
+			if end == -1 {
+				return fmt.Errorf("key %q: unterminated ${ reference", key)
+			}
+			ref := v[idx+2 : idx+2+end]
+			if _, ok := values[ref]; !ok {
+				return fmt.Errorf("key %q references %q which is not set", key, ref)
+			}
+			if keys[ref] {
+				if err := resolve(ref, chain); err != nil {
+					return err
+				}
+			}
+			sb.WriteString(v[:idx])
+			sb.WriteString(values[ref])
+			v = v[idx+2+end+1:]
+		}
+
+		values[key] = sb.String()
+		state[key] = done
+		return nil
+	}
+
+	for key := range keys {
+		if err := resolve(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAllowList reads one key per line. Blank lines and lines starting with
+// '#' are ignored.
+func loadAllowList(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if key := strings.TrimSpace(line); key != "" && key[0] != '#' {
+			allowed[key] = true
+		}
+	}
+	return allowed, nil
+}
+
+// restrictKeys drops every key of values that is not allowed and records it
+// in denied, so that a template referencing it fails with a clear error
+// instead of silently picking up a stray environment variable.
+func restrictKeys(values map[string]string, allowed, denied map[string]bool) {
+	for k := range values {
+		if !allowed[k] {
+			delete(values, k)
+			denied[k] = true
+		}
+	}
+}
+
+// loadValuesLock reads a -values-lock snapshot. Its values were resolved when
+// the snapshot was taken, so unlike those of a -values file they are taken
+// verbatim: no b64: or hex: decoding and no ${} expansion.
+func loadValuesLock(path string) (map[string]string, map[string]string, error) {
+	values, err := loadValuesFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load values lock %q: %w", path, err)
+	}
+	origins := make(map[string]string, len(values))
+	for k := range values {
+		origins[k] = "values-lock:" + path
+	}
+	return values, origins, nil
+}
+
+// loadValuesFile reads a flat key/value map. The format is chosen by extension:
+// .json holds a single object, .yaml/.yml a flat mapping of scalars, and
+// anything else KEY=value lines in dotenv style. A path of "-" reads standard
+// input, whose format is sniffed from its content.
+func loadValuesFile(path string) (map[string]string, error) {
+	if path == "-" {
+		data, err := readStdin()
+		if err != nil {
+			return nil, err
+		}
+		return parseValues(data, sniffValuesFormat(data))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseValues(data, strings.ToLower(filepath.Ext(path)))
+}
+
+var stdin struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// readStdin reads standard input once; later calls return the same data.
+func readStdin() ([]byte, error) {
+	stdin.once.Do(func() {
+		stdin.data, stdin.err = io.ReadAll(os.Stdin)
+	})
+	return stdin.data, stdin.err
+}
+
+// sniffValuesFormat returns the extension matching data: .json for an object,
+// otherwise .yaml or .env depending on whether the first entry separates its
+// key with ':' or '='.
+func sniffValuesFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return ".json"
+	}
+	for _, line := range strings.Split(string(trimmed), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line == "---" {
+			continue
+		}
+		colon, eq := strings.IndexByte(line, ':'), strings.IndexByte(line, '=')
+		if colon != -1 && (eq == -1 || colon < eq) {
+			return ".yaml"
+		}
+		break
+	}
+	return ".env"
+}
+
+func parseValues(data []byte, ext string) (map[string]string, error) {
+	switch ext {
+	case ".json":
+		return parseJSONValues(data)
+	case ".yaml", ".yml":
+		return parseLineValues(data, ':')
+	default:
+		return parseLineValues(data, '=')
+	}
+}
+
+func parseJSONValues(data []byte) (map[string]string, error) {
+	var raw map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			values[k] = v
+		case json.Number, bool:
+			values[k] = fmt.Sprint(v)
+		case nil:
+			values[k] = ""
+		default:
+			return nil, fmt.Errorf("key %q: nested values are not supported", k)
+		}
+	}
+	return values, nil
+}
+
+// parseLineValues parses one `key<sep>value` pair per line. Blank lines and
+// lines starting with '#' are ignored; values may be single or double quoted.
+func parseLineValues(data []byte, sep byte) (map[string]string, error) {
+	values := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || trimmed[0] == '-' {
+			return nil, fmt.Errorf("line %d: nested values are not supported", n)
+		}
+		if sep == '=' {
+			trimmed = strings.TrimPrefix(trimmed, "export ")
+		}
+
+		idx := strings.IndexByte(trimmed, sep)
+		if idx <= 0 {
+			return nil, fmt.Errorf("line %d: expected key%cvalue", n, sep)
+		}
+		key := strings.TrimSpace(trimmed[:idx])
+		val, err := unquoteValue(strings.TrimSpace(trimmed[idx+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		values[key] = val
+	}
+	return values, sc.Err()
+}
+
+func unquoteValue(v string) (string, error) {
+	if v != "" && (v[0] == '"' || v[0] == '\'') {
+		q := v[0]
+		end := 1
+		for end < len(v) {
+			if v[end] == q {
+				if q == '\'' && end+1 < len(v) && v[end+1] == '\'' {
+					end += 2
+					continue
+				}
+				break
+			}
+			if q == '"' && v[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(v) {
+			return "", fmt.Errorf("unterminated quoted value %s", v)
+		}
+		if rest := strings.TrimSpace(v[end+1:]); rest != "" && rest[0] != '#' {
+			return "", fmt.Errorf("unexpected %q after quoted value", rest)
+		}
+		if q == '"' {
+			return strconv.Unquote(v[:end+1])
+		}
+		return strings.ReplaceAll(v[1:end], "''", "'"), nil
+	}
+
+	// Unquoted values end at a trailing comment.
+	if idx := strings.Index(v, " #"); idx != -1 {
+		v = strings.TrimSpace(v[:idx])
+	}
+	return v, nil
+}
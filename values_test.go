@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadValuesFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"values.json": `{"HOST": "db", "PORT": 5432, "TLS": true}`,
+		"values.yaml": "# fixtures\nHOST: db\nPORT: \"5432\" # quoted\nTLS: 'true'\n",
+		"values.env":  "export HOST=db\nPORT=5432\n\nTLS=\"true\"\n",
+	}
+	want := map[string]string{"HOST": "db", "PORT": "5432", "TLS": "true"}
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		got, err := loadValuesFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+
+	nested := filepath.Join(dir, "nested.yaml")
+	if err := os.WriteFile(nested, []byte("db:\n  host: x\n"), 0o644); err != nil {
+		t.Fatalf("write nested: %v", err)
+	}
+	if _, err := loadValuesFile(nested); err == nil {
+		t.Errorf("expected error for nested YAML")
+	}
+}
+
+func TestBuildKeyMap_Interpolation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.env")
+	const data = "API_URL=https://${HOST}:${PORT}\nHOST=${DOMAIN}\nLITERAL=$${HOST}\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write values: %v", err)
+	}
+
+	values, err := buildKeyMap(false, true, []string{path}, map[string]string{"DOMAIN": "example.com", "PORT": "8443"})
+	if err != nil {
+		t.Fatalf("buildKeyMap: %v", err)
+	}
+	if got, want := values["API_URL"], "https://example.com:8443"; got != want {
+		t.Errorf("API_URL = %q, want %q", got, want)
+	}
+	if got, want := values["LITERAL"], "${HOST}"; got != want {
+		t.Errorf("LITERAL = %q, want %q", got, want)
+	}
+
+	cyclic := filepath.Join(t.TempDir(), "cycle.env")
+	if err := os.WriteFile(cyclic, []byte("A=${B}\nB=x${A}\n"), 0o644); err != nil {
+		t.Fatalf("write values: %v", err)
+	}
+	if _, err := buildKeyMap(false, false, []string{cyclic}, nil); err == nil {
+		t.Errorf("expected cycle error")
+	}
+}
+
+func TestAllowList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(path, []byte("# allowed keys\nPUBLIC_DOMAIN\n\n"), 0o644); err != nil {
+		t.Fatalf("write allow-list: %v", err)
+	}
+	allowed, err := loadAllowList(path)
+	if err != nil {
+		t.Fatalf("loadAllowList: %v", err)
+	}
+
+	values := map[string]string{"PUBLIC_DOMAIN": "example.com", "HOME": "/root"}
+	denied := make(map[string]bool)
+	restrictKeys(values, allowed, denied)
+
+	r := buildNewReplacer([]byte("<::"), []byte("::>"), values, replacerOptions{Denied: denied})
+	if out, _, err := r([]byte("<::PUBLIC_DOMAIN::>")); err != nil || string(out) != "example.com" {
+		t.Errorf("allowed key: got %q, %v", out, err)
+	}
+	_, _, err = r([]byte("home: <::HOME::>"))
+	if err == nil || !strings.Contains(err.Error(), "allow-list") {
+		t.Errorf("expected allow-list error for HOME, got %v", err)
+	}
+	_, _, err = r([]byte("<::UNSET::>"))
+	if err == nil || strings.Contains(err.Error(), "allow-list") {
+		t.Errorf("expected plain missing-key error for UNSET, got %v", err)
+	}
+}
+
+func TestLoadValuesFile_Stdin(t *testing.T) {
+	tests := map[string]string{
+		`{"HOST": "db", "PORT": 5432}`:      ".json",
+		"# comment\nHOST: db\nPORT: 5432\n": ".yaml",
+		"HOST=db\nPORT=5432\nURL=a:b\n":     ".env",
+	}
+	for input, format := range tests {
+		if got := sniffValuesFormat([]byte(input)); got != format {
+			t.Errorf("sniffValuesFormat(%q) = %q, want %q", input, got, format)
+		}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(f *os.File) { os.Stdin = f }(os.Stdin)
+	os.Stdin = r
+	stdin.once = sync.Once{}
+	go func() {
+		w.WriteString(`{"HOST": "db", "PORT": 5432}`)
+		w.Close()
+	}()
+
+	values, err := loadValuesFile("-")
+	if err != nil {
+		t.Fatalf("loadValuesFile(-): %v", err)
+	}
+	if want := map[string]string{"HOST": "db", "PORT": "5432"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+}
+
+func TestBuildKeyMap_Encoded(t *testing.T) {
+	t.Setenv("FROM_ENV", "hex:00")
+	path := filepath.Join(t.TempDir(), "values.env")
+	// "JHt4fQ==" is "${x}", which is not interpolated once decoded.
+	const data = "KEY=b64:JHt4fQ==\nUSES=<${BIN}>\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write values: %v", err)
+	}
+
+	values, err := buildKeyMap(true, true, []string{path}, map[string]string{"BIN": "hex:00ff0a"})
+	if err != nil {
+		t.Fatalf("buildKeyMap: %v", err)
+	}
+	want := map[string]string{"KEY": "${x}", "BIN": "\x00\xff\n", "USES": "<\x00\xff\n>", "FROM_ENV": "hex:00"}
+	for k, v := range want {
+		if values[k] != v {
+			t.Errorf("%s = %q, want %q", k, values[k], v)
+		}
+	}
+
+	if _, err := buildKeyMap(false, true, nil, map[string]string{"BAD": "b64:not base64!"}); err == nil {
+		t.Errorf("expected an error for invalid base64")
+	}
+}
+
+func TestExpandJSONEnv(t *testing.T) {
+	t.Setenv("APP_CONFIG", `{"db": {"host": "x", "port": 5432, "replicas": ["r1", "r2"], "tls": true, "ca": null}}`)
+	t.Setenv("NOT_JSON", "{not json")
+	values, origins, err := buildKeyMapWithOrigins(true, true, nil, map[string]string{"APP_CONFIG.db.host": "override"})
+	if err != nil {
+		t.Fatalf("buildKeyMap: %v", err)
+	}
+	expandJSONEnv(values, origins)
+	want := map[string]string{
+		"APP_CONFIG.db.host":       "override",
+		"APP_CONFIG.db.port":       "5432",
+		"APP_CONFIG.db.replicas.0": "r1",
+		"APP_CONFIG.db.replicas.1": "r2",
+		"APP_CONFIG.db.tls":        "true",
+		"APP_CONFIG.db.ca":         "",
+		"NOT_JSON":                 "{not json",
+	}
+	for k, v := range want {
+		if got, ok := values[k]; !ok || got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if origins["APP_CONFIG.db.port"] != "env" || origins["APP_CONFIG.db.host"] != "set" {
+		t.Errorf("origins = %q, %q", origins["APP_CONFIG.db.port"], origins["APP_CONFIG.db.host"])
+	}
+	if !strings.HasPrefix(values["APP_CONFIG"], "{") {
+		t.Errorf("APP_CONFIG lost its JSON value: %q", values["APP_CONFIG"])
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// valueScopes finds the -scoped-values files of a tree: a file of that name
+// in a directory holds values for the templates under it, over those of the
+// directories above. Parsed files are cached for as long as the scopes are
+// used, which is one run.
+type valueScopes struct {
+	src  inputSource
+	name string
+	root string
+	mu   sync.Mutex
+	// dirs holds the parsed file of every directory looked at, nil for
+	// directories without one.
+	dirs map[string]map[string]string
+}
+
+// newValueScopes returns the scopes below root of src for files called name.
+func newValueScopes(src inputSource, root, name string) *valueScopes {
+	return &valueScopes{src: src, name: name, root: filepath.Clean(root), dirs: make(map[string]map[string]string)}
+}
+
+// lookup merges the scoped values files from the root down to the directory
+// of path, nearer ones winning. origins maps every key to its file. A path
+// outside the root has no scoped values.
+func (s *valueScopes) lookup(path string) (values, origins map[string]string, err error) {
+	rel, err := relativeTo(s.root, filepath.Dir(path))
+	if err != nil || !filepath.IsLocal(rel) && rel != "." {
+		return nil, nil, nil
+	}
+	chain := []string{s.root}
+	if rel != "." {
+		for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+			chain = append(chain, filepath.Join(chain[len(chain)-1], elem))
+		}
+	}
+	for _, d := range chain {
+		vals, err := s.load(d)
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, v := range vals {
+			if values == nil {
+				values, origins = make(map[string]string), make(map[string]string)
+			}
+			values[k] = v
+			origins[k] = filepath.Join(d, s.name)
+		}
+	}
+	return values, origins, nil
+}
+
+// load returns the parsed scoped values file of dir, or nil.
+func (s *valueScopes) load(dir string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if vals, ok := s.dirs[dir]; ok {
+		return vals, nil
+	}
+	path := filepath.Join(dir, s.name)
+	data, err := s.src.readFile(path)
+	var vals map[string]string
+	if err == nil {
+		vals, err = parseValues(data, strings.ToLower(filepath.Ext(path)))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		vals, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %q: %w", path, err)
+	}
+	s.dirs[dir] = vals
+	return vals, nil
+}
+
+// loadLocalValues returns the values that apply to path alone: those of its
+// scoped values files and, over them, those of its sidecar. origins maps every
+// key to the file it came from. Both are nil when there are none. Outside a
+// run, which caches scoped values files in cfg.Scopes, they are read on every
+// call.
+func loadLocalValues(path string, cfg config) (values, origins map[string]string, err error) {
+	if cfg.ScopedValues != "" {
+		scopes := cfg.Scopes
+		if scopes == nil {
+			scopes = newValueScopes(cfg.input(), cfg.TargetDir, cfg.ScopedValues)
+		}
+		if values, origins, err = scopes.lookup(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	side, err := loadSidecar(cfg.input(), path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %q: %w", path+sidecarSuffix, err)
+	}
+	for k, v := range side {
+		if values == nil {
+			values, origins = make(map[string]string), make(map[string]string)
+		}
+		values[k] = v
+		origins[k] = path + sidecarSuffix
+	}
+	return values, origins, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessTree_ScopedValues(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		"values.yaml":             "REGION: eu\nREPLICAS: 2\n",
+		"app.yaml":                "<::APP::> <::REGION::> <::REPLICAS::>\n",
+		"billing/values.yaml":     "REPLICAS: 5\n",
+		"billing/api/deploy.yaml": "<::APP::> <::REGION::> <::REPLICAS::>\n",
+		"billing/api/deploy.yaml" + sidecarSuffix: "APP=billing-api\n",
+		"search/deploy.yaml":                      "<::APP::> <::REGION::> <::REPLICAS::>\n",
+	}
+	for name, txt := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:    "<::",
+		CloseDelim:   "::>",
+		TargetDir:    src,
+		OutDir:       out,
+		Workers:      2,
+		KeyMap:       map[string]string{"APP": "shop", "REGION": "us"},
+		FileFilter:   ff,
+		ScopedValues: "values.yaml",
+	}
+	results, err := processTree(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Errorf("rendered %d files, want the 3 templates", len(results))
+	}
+	want := map[string]string{
+		"app.yaml":                "shop eu 2\n",
+		"billing/api/deploy.yaml": "billing-api eu 5\n",
+		"search/deploy.yaml":      "shop eu 2\n",
+	}
+	for name, w := range want {
+		if got, _ := os.ReadFile(filepath.Join(out, name)); string(got) != w {
+			t.Errorf("%s: got %q, want %q", name, got, w)
+		}
+	}
+}
+
+func TestProcessTree_ScopedValuesEdited(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	values := filepath.Join(src, "values.yaml")
+	for name, txt := range map[string]string{"values.yaml": "REGION: eu\n", "app.yaml": "<::REGION::>\n"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:    "<::",
+		CloseDelim:   "::>",
+		TargetDir:    src,
+		OutDir:       out,
+		Workers:      1,
+		FileFilter:   ff,
+		ScopedValues: "values.yaml",
+	}
+	// The same config renders twice, as a watch does; the second run sees
+	// the edited file.
+	for _, region := range []string{"eu", "us"} {
+		if err := os.WriteFile(values, []byte("REGION: "+region+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := processTree(cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(filepath.Join(out, "app.yaml")); string(got) != region+"\n" {
+			t.Errorf("got %q, want %q", got, region+"\n")
+		}
+	}
+}
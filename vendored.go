@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// vendoredDirs are directory names holding third-party or generated code
+// that -skip-vendored prunes from the walk.
+var vendoredDirs = map[string]bool{
+	"vendor":           true,
+	"node_modules":     true,
+	"bower_components": true,
+	"third_party":      true,
+	".terraform":       true,
+	"__pycache__":      true,
+	".venv":            true,
+}
+
+// generatedMarker matches the conventional header of generated files, e.g.
+// "// Code generated by protoc-gen-go. DO NOT EDIT." or "# @generated", in
+// any comment syntax.
+var generatedMarker = regexp.MustCompile(`(?m)^\W*(Code generated .*DO NOT EDIT|@generated\b)`)
+
+// generatedHeadBytes is how much of the start of a file is searched for
+// generatedMarker. The marker belongs on one of the first lines.
+const generatedHeadBytes = 1024
+
+// skipDir reports whether the directory name is pruned by -skip-vendored.
+func (f *fileFilter) skipDir(name string) bool {
+	return f != nil && f.vendored && vendoredDirs[name]
+}
+
+// isGenerated reports whether data starts with a generated-code marker.
+func isGenerated(data []byte) bool {
+	head := data[:min(len(data), generatedHeadBytes)]
+	return bytes.Contains(head, []byte("generated")) && generatedMarker.Match(head)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkFiles_SkipVendored(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"app.yaml":                      "v: <::V::>\n",
+		"vendor/lib/lib.yaml":           "v: <::V::>\n",
+		"web/node_modules/pkg/pkg.yaml": "v: <::V::>\n",
+		"gen/api.yaml":                  "# Code generated by openapi-gen. DO NOT EDIT.\nv: <::V::>\n",
+		"gen/notes.yaml":                "# generated docs are under gen/\nv: <::V::>\n",
+	}
+	for name, txt := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	ff.vendored = true
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		Workers:    2,
+		KeyMap:     map[string]string{"V": "1"},
+		FileFilter: ff,
+	}
+	if err := processFiles(cfg); err != nil {
+		t.Fatalf("processFiles: %v", err)
+	}
+	for name, txt := range files {
+		want := txt
+		if name == "app.yaml" || name == "gen/notes.yaml" {
+			want = txt[:len(txt)-len("<::V::>\n")] + "1\n"
+		}
+		if got, _ := os.ReadFile(filepath.Join(src, filepath.FromSlash(name))); string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestIsGenerated(t *testing.T) {
+	tests := map[string]bool{
+		"// Code generated by protoc-gen-go. DO NOT EDIT.\npackage x\n": true,
+		"<!-- Code generated by tool; DO NOT EDIT. -->\n":               true,
+		"# @generated\nv: 1\n":                          true,
+		"v: 1\n# Code generated by hand, edit freely\n": false,
+		"v: 1\n": false,
+	}
+	for in, want := range tests {
+		if got := isGenerated([]byte(in)); got != want {
+			t.Errorf("isGenerated(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// verifyCmd renders every template again, in memory and with the values the
+// sources hold now, and reports each file previously written to -out or
+// -out-template that no longer matches. Nothing is written. It fails when any
+// file drifted, for nightly jobs.
+func verifyCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("verify: unexpected arguments %v", args)
+	}
+	if cfg.OutDir == "" && len(cfg.Profiles) == 0 {
+		return fmt.Errorf("verify: -out or -out-template must be set, files rendered in place cannot be verified")
+	}
+	if cfg.Encrypter != nil || cfg.ApplyCmd != "" {
+		return fmt.Errorf("verify: encrypted or piped output cannot be compared")
+	}
+
+	checked, drifted, err := verifyTree(cfg, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if drifted > 0 {
+		return fmt.Errorf("%d of %d rendered file(s) drifted from their sources", drifted, checked)
+	}
+	fmt.Printf("%d rendered file(s) match their sources\n", checked)
+	return nil
+}
+
+// verifyTree writes one line per rendered file that differs from a fresh
+// render and returns how many files it checked and how many differ. With
+// cfg.ManifestPath naming the manifest of the earlier run, a file edited after
+// it was rendered is told apart from one whose values or template changed.
+func verifyTree(cfg config, w io.Writer) (checked, drifted int, err error) {
+	recorded := make(map[string]string) // output -> sha256 at render time
+	if cfg.ManifestPath != "" {
+		m, err := readManifest(cfg.ManifestPath)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, f := range m.Files {
+			recorded[f.Output] = f.OutputSHA256
+		}
+	}
+
+	targets := renderTargets(cfg)
+	err = walkFiles(cfg, func(path string) error {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if cfg.skipContent(in) {
+			return nil
+		}
+		if in, err = cfg.decode(path, in); err != nil {
+			return err
+		}
+		if in, err = cfg.withIncludes(path, in); err != nil {
+			return err
+		}
+		side, _, err := loadLocalValues(path, cfg)
+		if err != nil {
+			return err
+		}
+		for _, t := range targets {
+			t = t.forPath(path)
+			dest, err := targetPath(cfg.TargetDir, path, t)
+			if err != nil {
+				return err
+			}
+			checked++
+			status := verifyFile(path, dest, in, side, t, recorded)
+			if status == "" {
+				continue
+			}
+			drifted++
+			if t.name != "" {
+				fmt.Fprintf(w, "%s: profile %q: %s\n", dest, t.name, status)
+			} else {
+				fmt.Fprintf(w, "%s: %s\n", dest, status)
+			}
+		}
+		return nil
+	})
+	return checked, drifted, err
+}
+
+// verifyFile compares dest with a fresh render of in for t and describes how
+// it drifted, or returns "" when it matches.
+func verifyFile(path, dest string, in []byte, side map[string]string, t renderTarget, recorded map[string]string) string {
+	cur, err := os.ReadFile(longPath(dest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "missing"
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if sum, ok := recorded[dest]; ok && sum != sha256Hex(cur) {
+		return "edited after it was rendered"
+	}
+
+	if side != nil {
+		values, opts, err := withSidecar(side, t.keyMap, t.opts)
+		if err != nil {
+			return err.Error()
+		}
+		t.replacer = buildCountingReplacer(t.open, t.close, values, opts)
+	}
+	out, _, err := t.replacer(in, nil)
+	if err != nil {
+		return fmt.Sprintf("no longer renders: %v", err)
+	}
+	if normalize := normalizerFor(t.normalize, path); normalize != nil {
+		if out, err = normalize(out); err != nil {
+			return fmt.Sprintf("no longer normalizes: %v", err)
+		}
+	}
+	if t.header {
+		cur = withoutHeader(cur)
+	}
+	if !bytes.Equal(cur, out) {
+		return fmt.Sprintf("differs from a fresh render of %s, its values or template changed", path)
+	}
+	return ""
+}
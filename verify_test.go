@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyTree(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	for name, txt := range map[string]string{"a.yaml": "a: <::A::>\n", "b.yaml": "b: <::B::>\n", "c.yaml": "c: 1\n"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(txt), 0o644); err != nil {
+			t.Fatalf("write temp file: %v", err)
+		}
+	}
+	ff, _ := newFileFilter([]string{`.*\.ya?ml$`}, nil)
+	cfg := config{
+		OpenDelim:    "<::",
+		CloseDelim:   "::>",
+		TargetDir:    src,
+		OutDir:       out,
+		Workers:      1,
+		KeyMap:       map[string]string{"A": "1", "B": "2"},
+		FileFilter:   ff,
+		ManifestPath: filepath.Join(t.TempDir(), "manifest.json"),
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if checked, drifted, err := verifyTree(cfg, &buf); err != nil || checked != 3 || drifted != 0 {
+		t.Fatalf("fresh render: %d checked, %d drifted, %v\n%s", checked, drifted, err, buf.String())
+	}
+
+	// A changed value, a hand-edited output and a deleted one.
+	cfg.KeyMap = map[string]string{"A": "s3cr3t", "B": "2"}
+	if err := os.WriteFile(filepath.Join(out, "b.yaml"), []byte("b: edited\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(out, "c.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	checked, drifted, err := verifyTree(cfg, &buf)
+	if err != nil || checked != 3 || drifted != 3 {
+		t.Fatalf("after drift: %d checked, %d drifted, %v", checked, drifted, err)
+	}
+	report := buf.String()
+	for _, want := range []string{
+		filepath.Join(out, "a.yaml") + ": differs from a fresh render",
+		filepath.Join(out, "b.yaml") + ": edited after it was rendered",
+		filepath.Join(out, "c.yaml") + ": missing",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report lacks %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "s3cr3t") {
+		t.Errorf("report leaks a value:\n%s", report)
+	}
+}
+
+func TestVerifyTree_Normalize(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.json"), []byte(`{"replicas": <::N::>, "name": "web"}`), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.json$`}, nil)
+	cfg := config{
+		OpenDelim:  "<::",
+		CloseDelim: "::>",
+		TargetDir:  src,
+		OutDir:     out,
+		Workers:    1,
+		KeyMap:     map[string]string{"N": "3"},
+		FileFilter: ff,
+		Normalize:  []string{"json"},
+	}
+	if _, err := processTree(cfg); err != nil {
+		t.Fatalf("processTree: %v", err)
+	}
+
+	// The output on disk is normalized, so is the render it is compared with.
+	var buf bytes.Buffer
+	if checked, drifted, err := verifyTree(cfg, &buf); err != nil || checked != 1 || drifted != 0 {
+		t.Fatalf("%d checked, %d drifted, %v\n%s", checked, drifted, err, buf.String())
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// kubeDataLink is the symlink Kubernetes swaps atomically when a mounted
+// ConfigMap or Secret is updated.
+const kubeDataLink = "..data"
+
+// sidecarWatchCmd renders the tree, then keeps running: whenever a -watch
+// directory changes, values are reloaded and the tree is rendered again, and
+// when that writes anything the -signal-pid process gets a SIGHUP. A SIGHUP
+// to charmap itself reloads values too, rendering again only when they
+// changed. It is meant to run next to an application in a Kubernetes pod,
+// rendering from mounted ConfigMaps and Secrets into a shared emptyDir. It
+// stops on SIGINT or SIGTERM.
+func sidecarWatchCmd(cfg config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("sidecar: unexpected arguments %v", args)
+	}
+	if len(cfg.WatchDirs) == 0 {
+		return fmt.Errorf("sidecar: -watch must be set")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return watchTree(ctx, cfg)
+}
+
+// watchTree implements sidecarWatchCmd until ctx is done. Errors while
+// rendering, the first render included, are logged and retried at the next
+// tick rather than ending the watch, so one bad update does not take the pod
+// down. Under systemd it reports readiness after the first render and pings
+// the watchdog from the start, see startWatchdog.
+//
+// Rendered files are watched too: one that changes behind charmap's back and
+// has placeholders again, typically because a template was copied over it by
+// hand or by a careless deploy step, is reported as drift and rendered again.
+func watchTree(ctx context.Context, cfg config) error {
+	watchdogCtx, stopWatchdog := context.WithCancel(ctx)
+	defer stopWatchdog()
+	startWatchdog(watchdogCtx, sdWatchdogInterval())
+
+	last, err := watchFingerprint(cfg.WatchDirs)
+	if err != nil {
+		return err
+	}
+	var stats sidecarStats
+	report := func(err error) {
+		if cfg.MetricsFile == "" {
+			return
+		}
+		stats.renders++
+		if err != nil {
+			stats.failures++
+		}
+		stats.last = time.Now()
+		if err := writeMetrics(cfg.MetricsFile, cfg, stats); err != nil {
+			slog.Warn("failed to write metrics", slog.String("path", cfg.MetricsFile), slog.Any("error", err))
+		}
+	}
+
+	results, err := rerender(cfg)
+	report(err)
+	failed, reload := err != nil, false
+	if failed {
+		slog.Error("render failed", slog.Any("error", err))
+	}
+	outputs := statOutputs(results)
+	drifts := 0
+	notify("READY=1")
+	defer notify("STOPPING=1")
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(cfg.WatchInterval)
+	defer ticker.Stop()
+	for {
+		hupped := false
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			hupped = true
+			slog.Info("SIGHUP received, reloading values")
+		case <-ticker.C:
+		}
+		drifted := driftedOutputs(outputs, cfg.OpenDelim, cfg.CloseDelim)
+		for _, path := range drifted {
+			drifts++
+			slog.Warn("config drift: rendered file has placeholders again, rendering it again",
+				slog.String("path", path), slog.Int("drift_total", drifts))
+		}
+		if len(drifted) > 0 {
+			notify(fmt.Sprintf("STATUS=config drift: %d rendered file(s) overwritten with templates since start", drifts))
+		}
+		cur, err := watchFingerprint(cfg.WatchDirs)
+		if err != nil {
+			slog.Warn("cannot read watched directory", slog.Any("error", err))
+			continue
+		}
+		wake := cur != last || len(drifted) > 0 || failed
+		if !wake && (!hupped || cfg.LoadValues == nil) {
+			continue
+		}
+		if cur != last {
+			last = cur
+			reload = true
+			slog.Info("watched directory changed, rendering")
+		}
+		if (reload || hupped) && cfg.LoadValues != nil {
+			values, profiles, err := cfg.LoadValues()
+			if err != nil {
+				slog.Error("failed to reload values", slog.Any("error", err))
+				failed = true
+				continue
+			}
+			if !wake && sameValues(cfg, values, profiles) {
+				slog.Info("values unchanged, not rendering")
+				continue
+			}
+			// The loop is the only renderer, so the next render sees
+			// either the old values or the new ones, never a mix.
+			cfg.KeyMap, cfg.Profiles = values, profiles
+			resetKeySetCaches()
+		}
+		reload = false
+		results, err := rerender(cfg)
+		report(err)
+		if failed = err != nil; failed {
+			slog.Error("render failed", slog.Any("error", err))
+		}
+		outputs = statOutputs(results)
+	}
+}
+
+// sameValues reports whether values and profiles equal those of cfg.
+func sameValues(cfg config, values map[string]string, profiles []profile) bool {
+	return maps.Equal(cfg.KeyMap, values) && slices.EqualFunc(cfg.Profiles, profiles, func(a, b profile) bool {
+		return a.Name == b.Name && maps.Equal(a.KeyMap, b.KeyMap)
+	})
+}
+
+// statOutputs returns the stat of every file results were rendered to.
+func statOutputs(results []fileResult) map[string]fs.FileInfo {
+	outputs := make(map[string]fs.FileInfo, len(results))
+	for _, r := range results {
+		if r.Dest == "" {
+			continue
+		}
+		if fi, err := os.Stat(longPath(r.Dest)); err == nil {
+			outputs[r.Dest] = fi
+		}
+	}
+	return outputs
+}
+
+// driftedOutputs returns, sorted, the files of outputs that were modified
+// since their stat was taken and have placeholders again. The stat of every
+// modified file is refreshed, so an edit is looked at once.
+func driftedOutputs(outputs map[string]fs.FileInfo, open, close string) []string {
+	var drifted []string
+	for path, fi := range outputs {
+		if !modifiedSince(path, fi) {
+			continue
+		}
+		cur, err := os.Stat(longPath(path))
+		if err != nil {
+			delete(outputs, path)
+			continue
+		}
+		outputs[path] = cur
+		data, err := os.ReadFile(longPath(path))
+		if err == nil && len(referencedKeys(string(data), open, close)) > 0 {
+			drifted = append(drifted, path)
+		}
+	}
+	sort.Strings(drifted)
+	return drifted
+}
+
+// notify is sdNotify, logging failures.
+func notify(state string) {
+	if err := sdNotify(state); err != nil {
+		slog.Warn("systemd notification failed", slog.String("state", state), slog.Any("error", err))
+	}
+}
+
+// rerender renders the tree and signals -signal-pid when a file was written.
+func rerender(cfg config) ([]fileResult, error) {
+	results, err := processTree(cfg)
+	if err != nil || cfg.SignalPID == 0 || !anyWritten(results) {
+		return results, err
+	}
+	p, err := os.FindProcess(cfg.SignalPID)
+	if err == nil {
+		err = p.Signal(syscall.SIGHUP)
+	}
+	if err != nil {
+		return results, fmt.Errorf("failed to signal process %d: %w", cfg.SignalPID, err)
+	}
+	slog.Info("sent SIGHUP", slog.Int("pid", cfg.SignalPID))
+	return results, nil
+}
+
+// watchFingerprint summarizes the state of dirs: the target of their ..data
+// symlink for Kubernetes volumes, or the name, size and modification time of
+// every file below them otherwise.
+func watchFingerprint(dirs []string) (string, error) {
+	h := sha256.New()
+	for _, dir := range dirs {
+		fmt.Fprintf(h, "%s\x00", dir)
+		if target, err := os.Readlink(filepath.Join(dir, kubeDataLink)); err == nil {
+			fmt.Fprintf(h, "%s\x00", target)
+			continue
+		}
+		err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00%d\x00%d\x00", p, fi.Size(), fi.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
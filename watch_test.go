@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchTree_ConfigMapSwap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses symlinks like a Kubernetes volume")
+	}
+
+	// A mounted ConfigMap: files link through ..data to a timestamped
+	// directory, and an update swaps ..data.
+	mount := t.TempDir()
+	publish := func(version, value string) {
+		t.Helper()
+		dir := filepath.Join(mount, "..v"+version)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "values.env"), []byte("V="+value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		tmp := filepath.Join(mount, "..data_tmp")
+		if err := os.Symlink(filepath.Base(dir), tmp); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(mount, kubeDataLink)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("1", "one")
+	if err := os.Symlink(filepath.Join(kubeDataLink, "values.env"), filepath.Join(mount, "values.env")); err != nil {
+		t.Fatal(err)
+	}
+
+	src, out := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	loadValues := func() (StringMap, []profile, error) {
+		v, err := loadValuesFile(filepath.Join(mount, "values.env"))
+		return v, nil, err
+	}
+	values, _, _ := loadValues()
+	cfg := config{
+		OpenDelim:     "<::",
+		CloseDelim:    "::>",
+		TargetDir:     src,
+		OutDir:        out,
+		Workers:       1,
+		KeyMap:        values,
+		FileFilter:    ff,
+		WatchDirs:     []string{mount},
+		WatchInterval: 10 * time.Millisecond,
+		LoadValues:    loadValues,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- watchTree(ctx, cfg) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watchTree: %v", err)
+		}
+	}()
+
+	dest := filepath.Join(out, "app.yaml")
+	waitFor := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if got, _ := os.ReadFile(dest); string(got) == want {
+				return
+			}
+		}
+		got, _ := os.ReadFile(dest)
+		t.Fatalf("rendered %q, want %q", got, want)
+	}
+	waitFor("v: one\n")
+	publish("2", "two")
+	waitFor("v: two\n")
+}
+
+func TestWatchTree_Drift(t *testing.T) {
+	src, out := t.TempDir(), t.TempDir()
+	tmpl := []byte("v: <::V::>\n")
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), tmpl, 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:     "<::",
+		CloseDelim:    "::>",
+		TargetDir:     src,
+		OutDir:        out,
+		Workers:       1,
+		KeyMap:        map[string]string{"V": "one"},
+		FileFilter:    ff,
+		WatchDirs:     []string{t.TempDir()},
+		WatchInterval: 10 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- watchTree(ctx, cfg) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watchTree: %v", err)
+		}
+	}()
+
+	dest := filepath.Join(out, "app.yaml")
+	waitFor := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if got, _ := os.ReadFile(dest); string(got) == want {
+				return
+			}
+		}
+		got, _ := os.ReadFile(dest)
+		t.Fatalf("rendered %q, want %q", got, want)
+	}
+	waitFor("v: one\n")
+
+	// Someone copies the template over its rendered output.
+	if err := os.WriteFile(dest, tmpl, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("v: one\n")
+}
+
+func TestWatchTree_ProfilesAndFirstRenderRetry(t *testing.T) {
+	watched, src, out := t.TempDir(), t.TempDir(), t.TempDir()
+	valuesFile := filepath.Join(watched, "prod.env")
+	if err := os.WriteFile(valuesFile, []byte("V=one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	// A file where the profile's output directory goes fails the first render.
+	blocker := filepath.Join(out, "prod")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	loadValues := func() (StringMap, []profile, error) {
+		v, err := loadValuesFile(valuesFile)
+		return StringMap{}, []profile{{Name: "prod", KeyMap: v}}, err
+	}
+	values, profiles, _ := loadValues()
+	cfg := config{
+		OpenDelim:     "<::",
+		CloseDelim:    "::>",
+		TargetDir:     src,
+		OutTmpl:       filepath.Join(out, "{env}"),
+		Workers:       1,
+		KeyMap:        values,
+		Profiles:      profiles,
+		FileFilter:    ff,
+		WatchDirs:     []string{watched},
+		WatchInterval: 10 * time.Millisecond,
+		LoadValues:    loadValues,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- watchTree(ctx, cfg) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watchTree: %v", err)
+		}
+	}()
+
+	dest := filepath.Join(out, "prod", "app.yaml")
+	waitFor := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if got, _ := os.ReadFile(dest); string(got) == want {
+				return
+			}
+		}
+		got, _ := os.ReadFile(dest)
+		t.Fatalf("rendered %q, want %q", got, want)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("v: one\n")
+	if err := os.WriteFile(valuesFile, []byte("V=three\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("v: three\n")
+}
+
+func TestWatchTree_SIGHUP(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs SIGHUP")
+	}
+	src, out := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "app.yaml"), []byte("v: <::V::>\n"), 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	// The values file is outside every -watch directory: only SIGHUP
+	// reloads it.
+	valuesPath := filepath.Join(t.TempDir(), "values.env")
+	if err := os.WriteFile(valuesPath, []byte("V=one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var loads atomic.Int32
+	loadValues := func() (StringMap, []profile, error) {
+		loads.Add(1)
+		v, err := loadValuesFile(valuesPath)
+		return v, nil, err
+	}
+	values, _, _ := loadValues()
+	ff, _ := newFileFilter([]string{`\.yaml$`}, nil)
+	cfg := config{
+		OpenDelim:     "<::",
+		CloseDelim:    "::>",
+		TargetDir:     src,
+		OutDir:        out,
+		Workers:       1,
+		KeyMap:        values,
+		FileFilter:    ff,
+		WatchDirs:     []string{t.TempDir()},
+		WatchInterval: time.Hour,
+		LoadValues:    loadValues,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- watchTree(ctx, cfg) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watchTree: %v", err)
+		}
+	}()
+
+	dest := filepath.Join(out, "app.yaml")
+	waitFor := func(cond func() bool, what string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if cond() {
+				return
+			}
+		}
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	rendered := func(want string) func() bool {
+		return func() bool { got, _ := os.ReadFile(dest); return string(got) == want }
+	}
+	hup := func() {
+		t.Helper()
+		p, _ := os.FindProcess(os.Getpid())
+		if err := p.Signal(syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(rendered("v: one\n"), "the first render")
+
+	// Unchanged values are reloaded but not rendered.
+	fi, _ := os.Stat(dest)
+	hup()
+	waitFor(func() bool { return loads.Load() == 2 }, "the reload")
+	time.Sleep(50 * time.Millisecond)
+	if cur, _ := os.Stat(dest); !cur.ModTime().Equal(fi.ModTime()) {
+		t.Error("output rewritten although the values did not change")
+	}
+
+	if err := os.WriteFile(valuesPath, []byte("V=two\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hup()
+	waitFor(rendered("v: two\n"), "the render with reloaded values")
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestIsReservedName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"nul":        true,
+		"NUL.yaml":   true,
+		"com1.txt":   true,
+		"Lpt9":       true,
+		"con .json":  true,
+		"console":    false,
+		"com10":      false,
+		"values.nul": false,
+		"app.yaml":   false,
+	} {
+		if got := isReservedName(name); got != want {
+			t.Errorf("isReservedName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}